@@ -2,8 +2,11 @@ package log_v1
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
 	status "google.golang.org/grpc/status"
 )
 
@@ -41,3 +44,121 @@ func (e ErrorOffsetOutOfRange) GRPCStatus() *status.Status {
 func (e ErrorOffsetOutOfRange) Error() string {
 	return e.GRPCStatus().Err().Error()
 }
+
+// ErrorNodeDraining is returned for produces sent to a node that is draining
+// before it leaves the cluster. Redirect is the RPC address of a server to
+// send them to instead, if one is known.
+type ErrorNodeDraining struct {
+	Redirect string
+}
+
+// GRPCStatus returns a grpc.Status that represents the error. The status is
+// an Unavailable error whose ErrorInfo detail carries the redirect address
+// as its redirect_addr metadata.
+func (e ErrorNodeDraining) GRPCStatus() *status.Status {
+	st := status.New(codes.Unavailable, "node is draining")
+
+	d := &errdetails.ErrorInfo{
+		Reason: "NODE_DRAINING",
+		Domain: "prolog",
+	}
+
+	if e.Redirect != "" {
+		d.Metadata = map[string]string{"redirect_addr": e.Redirect}
+	}
+
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+
+	return std
+}
+
+// Error implements the error interface. It returns the result of calling
+// GRPCStatus().Err().Error().
+func (e ErrorNodeDraining) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrorStaleRead is returned for consumes a replica cannot serve within the
+// request's bounds on staleness. Leader is the RPC address of the leader,
+// which can serve them, if it is known.
+type ErrorStaleRead struct {
+	Leader string
+}
+
+// GRPCStatus returns a grpc.Status that represents the error. The status is
+// an Unavailable error whose ErrorInfo detail carries the leader's address
+// as its leader_addr metadata.
+func (e ErrorStaleRead) GRPCStatus() *status.Status {
+	st := status.New(codes.Unavailable, "replica is too stale")
+
+	d := &errdetails.ErrorInfo{
+		Reason: "STALE_READ",
+		Domain: "prolog",
+	}
+
+	if e.Leader != "" {
+		d.Metadata = map[string]string{"leader_addr": e.Leader}
+	}
+
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+
+	return std
+}
+
+// Error implements the error interface. It returns the result of calling
+// GRPCStatus().Err().Error().
+func (e ErrorStaleRead) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrorAckTimeout is returned for produces whose record was appended at
+// Offset but not acknowledged by every in-sync replica in time. Replicas
+// names those that had not acknowledged it. Retrying the produce appends the
+// record again.
+type ErrorAckTimeout struct {
+	Offset   uint64
+	Replicas []string
+}
+
+// GRPCStatus returns a grpc.Status that represents the error. The status is
+// a DeadlineExceeded error whose ErrorInfo detail carries the record's
+// offset and the comma separated names of the replicas as its offset and
+// replicas metadata.
+func (e ErrorAckTimeout) GRPCStatus() *status.Status {
+	st := status.New(
+		codes.DeadlineExceeded,
+		fmt.Sprintf(
+			"offset %d not acknowledged by %d replicas",
+			e.Offset,
+			len(e.Replicas),
+		),
+	)
+
+	d := &errdetails.ErrorInfo{
+		Reason: "ACK_TIMEOUT",
+		Domain: "prolog",
+		Metadata: map[string]string{
+			"offset":   strconv.FormatUint(e.Offset, 10),
+			"replicas": strings.Join(e.Replicas, ","),
+		},
+	}
+
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+
+	return std
+}
+
+// Error implements the error interface. It returns the result of calling
+// GRPCStatus().Err().Error().
+func (e ErrorAckTimeout) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
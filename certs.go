@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Gibson-Gichuru/prolog/internal/certs"
+	"github.com/Gibson-Gichuru/prolog/internal/config"
+)
+
+const certsUsage = `Usage: prolog certs <command> [flags]
+
+Certificates are written to the config directory, $CONFIG_DIR or ~/.prolog.
+
+Commands:
+  init      create the CA if needed and issue the server certificate and
+            the root and nobody client certificates used by the tests
+  ca        create a new CA
+  server    issue the server certificate
+  client    issue a client certificate for a common name
+
+Run prolog certs <command> -h for the command's flags.
+`
+
+const (
+	defaultCACommonName     = "prolog test CA"
+	defaultServerCommonName = "127.0.0.1"
+	defaultServerHosts      = "localhost,127.0.0.1"
+)
+
+func runCerts(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, certsUsage)
+		return errors.New("certs: missing command")
+	}
+
+	switch args[0] {
+	case "init":
+		return certsInit(args[1:])
+	case "ca":
+		return certsCA(args[1:])
+	case "server":
+		return certsServer(args[1:])
+	case "client":
+		return certsClient(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(certsUsage)
+		return nil
+	default:
+		fmt.Fprint(os.Stderr, certsUsage)
+		return fmt.Errorf("certs: unknown command %q", args[0])
+	}
+}
+
+func certsInit(args []string) error {
+	flags := flag.NewFlagSet("certs init", flag.ContinueOnError)
+	hosts := flags.String("hosts", defaultServerHosts, "comma separated hosts the server certificate is valid for")
+	clients := flags.String("clients", "root,nobody", "comma separated common names to issue client certificates for")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ca, err := certs.LoadCA(config.CAFile, config.CAKeyFile)
+
+	if errors.Is(err, os.ErrNotExist) {
+		ca, err = writeCA(defaultCACommonName)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := issue(ca, certs.Request{
+		CommonName: defaultServerCommonName,
+		Hosts:      split(*hosts),
+		Profile:    certs.PeerProfile,
+	}, config.ServerCertFile, config.ServerKeyFile); err != nil {
+		return err
+	}
+
+	for _, cn := range split(*clients) {
+		certFile, keyFile := config.ClientFiles(cn)
+
+		if err := issue(ca, certs.Request{
+			CommonName: cn,
+			Profile:    certs.ClientProfile,
+		}, certFile, keyFile); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func certsCA(args []string) error {
+	flags := flag.NewFlagSet("certs ca", flag.ContinueOnError)
+	cn := flags.String("cn", defaultCACommonName, "common name of the CA")
+	force := flags.Bool("force", false, "replace an existing CA, invalidating the certificates it issued")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(config.CAFile); err == nil && !*force {
+		return fmt.Errorf("%s already exists, use -force to replace it", config.CAFile)
+	}
+
+	_, err := writeCA(*cn)
+
+	return err
+}
+
+func certsServer(args []string) error {
+	flags := flag.NewFlagSet("certs server", flag.ContinueOnError)
+	cn := flags.String("cn", defaultServerCommonName, "common name of the certificate")
+	hosts := flags.String("hosts", defaultServerHosts, "comma separated DNS names, IP addresses and URIs the certificate is valid for")
+	serverOnly := flags.Bool("server-only", false, "issue a certificate for server auth only, which cannot connect to other members")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ca, err := certs.LoadCA(config.CAFile, config.CAKeyFile)
+
+	if err != nil {
+		return err
+	}
+
+	profile := certs.PeerProfile
+
+	if *serverOnly {
+		profile = certs.ServerProfile
+	}
+
+	return issue(ca, certs.Request{
+		CommonName: *cn,
+		Hosts:      split(*hosts),
+		Profile:    profile,
+	}, config.ServerCertFile, config.ServerKeyFile)
+}
+
+func certsClient(args []string) error {
+	flags := flag.NewFlagSet("certs client", flag.ContinueOnError)
+	cn := flags.String("cn", "", "common name of the certificate, the subject in ACL policies (required)")
+	hosts := flags.String("hosts", "", "comma separated DNS names and URIs, such as SPIFFE IDs, to add to the certificate")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *cn == "" {
+		flags.Usage()
+		return errors.New("certs client: -cn is required")
+	}
+
+	ca, err := certs.LoadCA(config.CAFile, config.CAKeyFile)
+
+	if err != nil {
+		return err
+	}
+
+	certFile, keyFile := config.ClientFiles(*cn)
+
+	return issue(ca, certs.Request{
+		CommonName: *cn,
+		Hosts:      split(*hosts),
+		Profile:    certs.ClientProfile,
+	}, certFile, keyFile)
+}
+
+func writeCA(cn string) (*certs.CA, error) {
+	ca, err := certs.NewCA(cn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ca.Certificate().Write(config.CAFile, config.CAKeyFile); err != nil {
+		return nil, err
+	}
+
+	fmt.Println("wrote", config.CAFile)
+
+	return ca, nil
+}
+
+func issue(ca *certs.CA, req certs.Request, certFile, keyFile string) error {
+	cert, err := ca.Issue(req)
+
+	if err != nil {
+		return err
+	}
+
+	if err := cert.Write(certFile, keyFile); err != nil {
+		return err
+	}
+
+	fmt.Println("wrote", certFile)
+
+	return nil
+}
+
+func split(s string) []string {
+	var values []string
+
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,8 @@
+package client
+
+import (
+	// Registers the prolog resolver and balancer so connections dialed with
+	// a prolog:///host:port target discover the whole cluster and send
+	// produces to the leader.
+	_ "github.com/Gibson-Gichuru/prolog/internal/loadbalance"
+)
@@ -0,0 +1,206 @@
+package client
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"go.uber.org/zap"
+)
+
+type OffsetStore interface {
+	// Load returns the next offset to consume. ok is false when no offset
+	// has been committed yet.
+	Load(ctx context.Context) (offset uint64, ok bool, err error)
+	// Commit records offset as the next offset to consume.
+	Commit(ctx context.Context, offset uint64) error
+}
+
+type ConsumerConfig struct {
+	// Offset is the offset to start consuming from when Store is nil or has
+	// no committed offset.
+	Offset uint64
+	// Store, if set, is loaded when consuming starts and committed with the
+	// next offset after every delivered record.
+	Store OffsetStore
+	// Backoff is the delay before the first reconnect. It doubles with each
+	// failed reconnect up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+type Consumer struct {
+	Config ConsumerConfig
+	client api.LogClient
+	logger *zap.Logger
+	next   atomic.Uint64
+}
+
+// stopError wraps errors that end consuming without reconnecting.
+type stopError struct {
+	err error
+}
+
+// NewConsumer returns a Consumer that reads records from the log through
+// client's ConsumeStream. Zero values in config are replaced with defaults: a
+// backoff starting at 100ms capped at 5s.
+func NewConsumer(client api.LogClient, config ConsumerConfig) (*Consumer, error) {
+	if config.Backoff == 0 {
+		config.Backoff = 100 * time.Millisecond
+	}
+
+	if config.MaxBackoff == 0 {
+		config.MaxBackoff = 5 * time.Second
+	}
+
+	c := &Consumer{
+		Config: config,
+		client: client,
+		logger: zap.L().Named("consumer"),
+	}
+
+	c.next.Store(config.Offset)
+
+	return c, nil
+}
+
+// Offset returns the next offset the consumer will deliver.
+func (c *Consumer) Offset() uint64 {
+	return c.next.Load()
+}
+
+// Run consumes records and calls handler with each of them in offset order
+// until ctx is done or handler returns an error. When the stream breaks with
+// a retryable error, or is closed by the server, Run reconnects with backoff
+// from the offset after the last delivered record. It returns ctx's error,
+// handler's error, or the first non-retryable error. Run must not be called
+// concurrently on the same consumer.
+func (c *Consumer) Run(ctx context.Context, handler func(*api.Record) error) error {
+	if c.Config.Store != nil {
+		offset, ok, err := c.Config.Store.Load(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			c.next.Store(offset)
+		}
+	}
+
+	backoff := c.Config.Backoff
+
+	for {
+		delivered, err := c.consume(ctx, handler)
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if herr, ok := err.(stopError); ok {
+			return herr.err
+		}
+
+		if err != io.EOF && !retryable(err) {
+			return err
+		}
+
+		if delivered {
+			backoff = c.Config.Backoff
+		}
+
+		c.logger.Warn(
+			"reconnecting",
+			zap.Uint64("offset", c.next.Load()),
+			zap.Duration("backoff", backoff),
+			zap.Error(err),
+		)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		backoff *= 2
+
+		if backoff > c.Config.MaxBackoff {
+			backoff = c.Config.MaxBackoff
+		}
+	}
+}
+
+// Records consumes records in a separate goroutine and delivers them on the
+// returned records channel. Delivery blocks until the record is received, so
+// a slow reader slows down consumption rather than buffering. Both channels
+// are closed once consuming stops; the error channel first receives the
+// error Run returned.
+func (c *Consumer) Records(ctx context.Context) (<-chan *api.Record, <-chan error) {
+	records := make(chan *api.Record)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(records)
+		defer close(errs)
+
+		errs <- c.Run(ctx, func(record *api.Record) error {
+			select {
+			case records <- record:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	return records, errs
+}
+
+// consume opens a ConsumeStream at the next offset and delivers records to
+// handler until the stream fails. After each record is handled the next
+// offset is advanced and, if configured, committed. It returns whether any
+// record was delivered and the error that ended the stream; errors returned
+// by handler or the offset store are wrapped in stopError.
+func (c *Consumer) consume(
+	ctx context.Context,
+	handler func(*api.Record) error,
+) (delivered bool, err error) {
+
+	stream, err := c.client.ConsumeStream(ctx, &api.ConsumeRequest{
+		Offset: c.next.Load(),
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	for {
+		res, err := stream.Recv()
+
+		if err != nil {
+			return delivered, err
+		}
+
+		if err = handler(res.Record); err != nil {
+			return delivered, stopError{err: err}
+		}
+
+		delivered = true
+		c.next.Store(res.Record.Offset + 1)
+
+		if c.Config.Store == nil {
+			continue
+		}
+
+		if err = c.Config.Store.Commit(ctx, res.Record.Offset+1); err != nil {
+			return delivered, stopError{err: err}
+		}
+	}
+}
+
+// Error returns the wrapped error's message.
+func (e stopError) Error() string {
+	return e.err.Error()
+}
@@ -0,0 +1,184 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConsumer runs a series of test scenarios against a Consumer backed by
+// an in-process server, covering handlers, reconnects, offset stores, and
+// channel delivery.
+func TestConsumer(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		t *testing.T,
+		client api.LogClient,
+		commitLog *flakyLog,
+	){
+		"handler receives records in order":     testConsumeHandler,
+		"broken stream reconnects after last":   testConsumeReconnect,
+		"offset store resumes and commits":      testConsumeOffsetStore,
+		"records channel delivers until cancel": testConsumeRecords,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			client, commitLog, teardown := setupTest(t)
+			defer teardown()
+			fn(t, client, commitLog)
+		})
+	}
+}
+
+// testConsumeHandler tests that Run calls the handler with every record in
+// offset order and stops with the handler's error.
+func testConsumeHandler(t *testing.T, client api.LogClient, commitLog *flakyLog) {
+	appendRecords(t, commitLog, 3)
+
+	consumer, err := NewConsumer(client, ConsumerConfig{})
+	require.NoError(t, err)
+
+	var got []uint64
+
+	errDone := context.Canceled
+
+	err = consumer.Run(context.Background(), func(record *api.Record) error {
+		got = append(got, record.Offset)
+
+		if len(got) == 3 {
+			return errDone
+		}
+
+		return nil
+	})
+
+	require.Equal(t, errDone, err)
+	require.Equal(t, []uint64{0, 1, 2}, got)
+	require.Equal(t, uint64(2), consumer.Offset())
+}
+
+// testConsumeReconnect tests that a stream broken by a retryable error is
+// reopened from the offset after the last delivered record, so no record is
+// delivered twice or skipped.
+func testConsumeReconnect(t *testing.T, client api.LogClient, commitLog *flakyLog) {
+	appendRecords(t, commitLog, 1)
+
+	consumer, err := NewConsumer(client, ConsumerConfig{
+		Backoff: time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var got []uint64
+
+	err = consumer.Run(ctx, func(record *api.Record) error {
+		got = append(got, record.Offset)
+
+		switch len(got) {
+		case 1:
+			commitLog.failReads(1)
+			appendRecords(t, commitLog, 2)
+		case 3:
+			cancel()
+		}
+
+		return nil
+	})
+
+	require.Equal(t, context.Canceled, err)
+	require.Equal(t, []uint64{0, 1, 2}, got)
+}
+
+// testConsumeOffsetStore tests that the consumer starts from the offset
+// loaded from its store and commits the next offset after each record.
+func testConsumeOffsetStore(t *testing.T, client api.LogClient, commitLog *flakyLog) {
+	appendRecords(t, commitLog, 4)
+
+	store := &offsetStore{offset: 2, ok: true}
+
+	consumer, err := NewConsumer(client, ConsumerConfig{
+		Store: store,
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var got []uint64
+
+	err = consumer.Run(ctx, func(record *api.Record) error {
+		got = append(got, record.Offset)
+
+		if record.Offset == 3 {
+			cancel()
+		}
+
+		return nil
+	})
+
+	require.Equal(t, context.Canceled, err)
+	require.Equal(t, []uint64{2, 3}, got)
+
+	offset, ok, err := store.Load(context.Background())
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, uint64(4), offset)
+}
+
+// testConsumeRecords tests that Records delivers records on a channel and
+// closes it with the context's error once the context is cancelled.
+func testConsumeRecords(t *testing.T, client api.LogClient, commitLog *flakyLog) {
+	appendRecords(t, commitLog, 2)
+
+	consumer, err := NewConsumer(client, ConsumerConfig{Offset: 1})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	records, errs := consumer.Records(ctx)
+
+	record := <-records
+	require.Equal(t, uint64(1), record.Offset)
+
+	cancel()
+
+	for range records {
+	}
+
+	require.Equal(t, context.Canceled, <-errs)
+}
+
+// appendRecords appends n records directly to the commit log.
+func appendRecords(t *testing.T, commitLog *flakyLog, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		_, err := commitLog.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+}
+
+type offsetStore struct {
+	mu     sync.Mutex
+	offset uint64
+	ok     bool
+}
+
+// Load returns the committed offset.
+func (s *offsetStore) Load(ctx context.Context) (uint64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.offset, s.ok, nil
+}
+
+// Commit records the committed offset.
+func (s *offsetStore) Commit(ctx context.Context, offset uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset, s.ok = offset, true
+	return nil
+}
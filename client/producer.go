@@ -0,0 +1,452 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var ErrProducerClosed = errors.New("producer closed")
+
+type ProducerConfig struct {
+	// BatchSize is the number of buffered records that triggers a flush.
+	BatchSize int
+	// BatchBytes is the total size of buffered record values that triggers
+	// a flush.
+	BatchBytes int
+	// Linger is how long the first record of a batch may wait before the
+	// batch is flushed.
+	Linger time.Duration
+	// MaxRetries is how many times a batch is retried after a retryable
+	// error before its records fail.
+	MaxRetries int
+	// Backoff is the delay before the first retry. It doubles with each
+	// retry up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Acks is how the server acknowledges records. With ACKS_NONE, futures
+	// resolve to offset 0 once their batch has been sent, and records the
+	// server fails to append are only logged. With ACKS_ALL, they resolve
+	// once every in-sync replica has replicated the record, or with the
+	// server's ErrorAckTimeout and the record's offset, which is not
+	// retried as the record was appended. The records batched after it
+	// were not, and are sent again.
+	Acks api.Acks
+	// AckTimeout is how long the server waits for replicas to acknowledge
+	// a record with ACKS_ALL. The server's default is used if it is zero.
+	AckTimeout time.Duration
+}
+
+type Producer struct {
+	Config ProducerConfig
+	client api.LogClient
+	logger *zap.Logger
+
+	records chan *pendingRecord
+	flushes chan chan struct{}
+	done    chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+	close  chan struct{}
+}
+
+type pendingRecord struct {
+	record *api.Record
+	future *Future
+}
+
+type Future struct {
+	done   chan struct{}
+	offset uint64
+	err    error
+}
+
+// NewProducer returns a Producer that appends records to the log through
+// client's ProduceStream. Zero values in config are replaced with defaults: a
+// batch size of 100 records, a batch limit of 1MiB, a linger of 10ms, 5
+// retries, and a backoff starting at 100ms capped at 5s.
+func NewProducer(client api.LogClient, config ProducerConfig) (*Producer, error) {
+	if config.BatchSize == 0 {
+		config.BatchSize = 100
+	}
+
+	if config.BatchBytes == 0 {
+		config.BatchBytes = 1 << 20
+	}
+
+	if config.Linger == 0 {
+		config.Linger = 10 * time.Millisecond
+	}
+
+	if config.MaxRetries == 0 {
+		config.MaxRetries = 5
+	}
+
+	if config.Backoff == 0 {
+		config.Backoff = 100 * time.Millisecond
+	}
+
+	if config.MaxBackoff == 0 {
+		config.MaxBackoff = 5 * time.Second
+	}
+
+	p := &Producer{
+		Config:  config,
+		client:  client,
+		logger:  zap.L().Named("producer"),
+		records: make(chan *pendingRecord, config.BatchSize),
+		flushes: make(chan chan struct{}),
+		done:    make(chan struct{}),
+		close:   make(chan struct{}),
+	}
+
+	go p.run()
+
+	return p, nil
+}
+
+// Produce buffers record to be appended to the log and returns a Future that
+// resolves to the record's offset once its batch has been acknowledged. It
+// blocks while the buffer is full, and returns an error if ctx is done first
+// or if the producer is closed.
+func (p *Producer) Produce(ctx context.Context, record *api.Record) (*Future, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return nil, ErrProducerClosed
+	}
+
+	pending := &pendingRecord{
+		record: record,
+		future: &Future{done: make(chan struct{})},
+	}
+
+	select {
+	case p.records <- pending:
+		return pending.future, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Flush sends every record buffered before the call and waits until their
+// futures have resolved. It returns an error if ctx is done first or if the
+// producer is closed.
+func (p *Producer) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+
+	select {
+	case p.flushes <- flushed:
+	case <-p.done:
+		return ErrProducerClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting records, flushes the records already buffered, and
+// waits for their futures to resolve. It is safe to call multiple times.
+func (p *Producer) Close() error {
+	p.mu.Lock()
+
+	if p.closed {
+		p.mu.Unlock()
+		<-p.done
+		return nil
+	}
+
+	p.closed = true
+	close(p.close)
+	p.mu.Unlock()
+
+	<-p.done
+
+	return nil
+}
+
+// run collects buffered records into batches and sends a batch when it
+// reaches BatchSize records or BatchBytes bytes, when its first record has
+// lingered for Linger, when Flush is called, or when the producer is closed.
+func (p *Producer) run() {
+	defer close(p.done)
+
+	var (
+		batch  []*pendingRecord
+		size   int
+		linger *time.Timer
+		expire <-chan time.Time
+	)
+
+	flush := func() {
+		if linger != nil {
+			linger.Stop()
+			linger, expire = nil, nil
+		}
+
+		if len(batch) == 0 {
+			return
+		}
+
+		p.send(batch)
+		batch, size = nil, 0
+	}
+
+	add := func(pending *pendingRecord) {
+		batch = append(batch, pending)
+		size += len(pending.record.Value)
+
+		if len(batch) == 1 {
+			linger = time.NewTimer(p.Config.Linger)
+			expire = linger.C
+		}
+
+		if len(batch) >= p.Config.BatchSize || size >= p.Config.BatchBytes {
+			flush()
+		}
+	}
+
+	drain := func() {
+		for {
+			select {
+			case pending := <-p.records:
+				add(pending)
+			default:
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case pending := <-p.records:
+			add(pending)
+
+		case <-expire:
+			linger, expire = nil, nil
+			flush()
+
+		case flushed := <-p.flushes:
+			drain()
+			flush()
+			close(flushed)
+
+		case <-p.close:
+			drain()
+			flush()
+			return
+		}
+	}
+}
+
+// send appends batch to the log, retrying the records that were not
+// acknowledged when the stream fails with a retryable error. The delay
+// between retries starts at Backoff and doubles up to MaxBackoff, but is at
+// least as long as any retry delay the server asked for. Records
+// still unacknowledged when the retries are exhausted, or after a
+// non-retryable error, resolve with the error.
+func (p *Producer) send(batch []*pendingRecord) {
+	backoff := p.Config.Backoff
+
+	for attempt := 0; ; attempt++ {
+		n, err := p.sendBatch(batch)
+
+		batch = batch[n:]
+
+		if err == nil {
+			return
+		}
+
+		// Only the record the server was waiting on was appended. The
+		// stream ended with it, so the records after it were not, and are
+		// sent again without counting as a retry.
+		if offset, ok := ackTimeout(err); ok && len(batch) > 0 {
+			batch[0].future.resolve(offset, err)
+			batch = batch[1:]
+
+			if len(batch) == 0 {
+				return
+			}
+
+			attempt--
+			continue
+		}
+
+		if !retryable(err) || attempt >= p.Config.MaxRetries {
+			for _, pending := range batch {
+				pending.future.resolve(0, err)
+			}
+			return
+		}
+
+		wait := max(backoff, retryAfter(err))
+
+		p.logger.Warn(
+			"retrying batch",
+			zap.Int("records", len(batch)),
+			zap.Duration("backoff", wait),
+			zap.Error(err),
+		)
+
+		time.Sleep(wait)
+
+		backoff *= 2
+
+		if backoff > p.Config.MaxBackoff {
+			backoff = p.Config.MaxBackoff
+		}
+	}
+}
+
+// sendBatch sends batch over a new ProduceStream, receiving responses while
+// the records are still being sent. It resolves the future of each
+// acknowledged record with its offset and returns how many records were
+// acknowledged, in order, along with any error that ended the stream.
+func (p *Producer) sendBatch(batch []*pendingRecord) (int, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := p.client.ProduceStream(ctx)
+
+	if err != nil {
+		return 0, err
+	}
+
+	sendErr := make(chan error, 1)
+
+	go func() {
+		for _, pending := range batch {
+			if err := stream.Send(&api.ProduceRequest{
+				Record:       pending.record,
+				Acks:         p.Config.Acks,
+				AckTimeoutMs: uint32(p.Config.AckTimeout.Milliseconds()),
+			}); err != nil {
+				sendErr <- err
+				return
+			}
+		}
+		sendErr <- stream.CloseSend()
+	}()
+
+	if p.Config.Acks == api.Acks_ACKS_NONE {
+		return p.forget(stream, batch, sendErr)
+	}
+
+	for i := range batch {
+		res, err := stream.Recv()
+
+		if err != nil {
+			return i, err
+		}
+
+		batch[i].future.resolve(res.Offset, nil)
+	}
+
+	return len(batch), <-sendErr
+}
+
+// forget resolves the futures of a batch sent without acknowledgements once
+// it has been sent, and waits for the server to close the stream before
+// returning, logging the error it closed it with, if any.
+func (p *Producer) forget(
+	stream api.Log_ProduceStreamClient,
+	batch []*pendingRecord,
+	sendErr <-chan error,
+) (int, error) {
+	if err := <-sendErr; err != nil {
+		return 0, err
+	}
+
+	for _, pending := range batch {
+		pending.future.resolve(0, nil)
+	}
+
+	if _, err := stream.Recv(); err != io.EOF {
+		p.logger.Warn(
+			"unacknowledged batch failed",
+			zap.Int("records", len(batch)),
+			zap.Error(err),
+		)
+	}
+
+	return len(batch), nil
+}
+
+// Done returns a channel that is closed once the future has resolved.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Offset waits for the future to resolve and returns the offset assigned to
+// the record, or the error that prevented it from being appended. It returns
+// ctx's error if ctx is done first.
+func (f *Future) Offset(ctx context.Context) (uint64, error) {
+	select {
+	case <-f.done:
+		return f.offset, f.err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// resolve sets the future's offset and error and wakes up its waiters.
+func (f *Future) resolve(offset uint64, err error) {
+	f.offset = offset
+	f.err = err
+	close(f.done)
+}
+
+// retryable reports whether err is a gRPC error worth retrying.
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted:
+		return true
+	default:
+		return false
+	}
+}
+
+// ackTimeout returns the offset of the record err reports the replicas did
+// not acknowledge in time, if err is an ErrorAckTimeout.
+func ackTimeout(err error) (uint64, bool) {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok &&
+			info.Reason == "ACK_TIMEOUT" {
+			offset, err := strconv.ParseUint(info.Metadata["offset"], 10, 64)
+
+			return offset, err == nil
+		}
+	}
+
+	return 0, false
+}
+
+// retryAfter returns how long the server asked the client to wait before
+// retrying, such as when a quota is exceeded, or 0 if err carries no
+// RetryInfo.
+func retryAfter(err error) time.Duration {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			return info.RetryDelay.AsDuration()
+		}
+	}
+
+	return 0
+}
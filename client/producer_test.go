@@ -0,0 +1,400 @@
+package client
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/Gibson-Gichuru/prolog/internal/log"
+	"github.com/Gibson-Gichuru/prolog/internal/server"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// TestProducer runs a series of test scenarios against a Producer backed by
+// an in-process server, covering batching, linger, retries, and closing.
+func TestProducer(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		t *testing.T,
+		client api.LogClient,
+		commitLog *flakyLog,
+	){
+		"produce batches resolves offsets":       testProduceBatch,
+		"linger flushes a partial batch":         testProduceLinger,
+		"retryable errors are retried":           testProduceRetry,
+		"non-retryable errors fail the futures":  testProduceFailure,
+		"close flushes and rejects new records":  testProducerClose,
+		"unacknowledged records are not retried": testProduceNoAcks,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			client, commitLog, teardown := setupTest(t)
+			defer teardown()
+			fn(t, client, commitLog)
+		})
+	}
+}
+
+// setupTest starts an insecure in-process server over a temporary log,
+// configured by fns, and returns a client connected to it, the server's commit log, and a teardown
+// function that stops the server and removes the log.
+func setupTest(t *testing.T, fns ...func(*server.Config)) (
+	client api.LogClient,
+	commitLog *flakyLog,
+	teardown func(),
+) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	dir, err := os.MkdirTemp("", "client_test")
+	require.NoError(t, err)
+
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+
+	commitLog = &flakyLog{Log: clog}
+
+	config := &server.Config{
+		CommitLog:  commitLog,
+		Authorizer: &authorizer{},
+	}
+
+	for _, fn := range fns {
+		fn(config)
+	}
+
+	srv, err := server.NewGRPCServer(config)
+	require.NoError(t, err)
+
+	go srv.Serve(l)
+
+	conn, err := grpc.NewClient(
+		l.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+
+	return api.NewLogClient(conn), commitLog, func() {
+		conn.Close()
+		srv.Stop()
+		clog.Remove()
+	}
+}
+
+// testProduceBatch tests that records produced in batches resolve to
+// sequential offsets and are readable from the log.
+func testProduceBatch(t *testing.T, client api.LogClient, commitLog *flakyLog) {
+	producer, err := NewProducer(client, ProducerConfig{
+		BatchSize: 4,
+		Linger:    time.Hour,
+	})
+	require.NoError(t, err)
+	defer producer.Close()
+
+	ctx := context.Background()
+
+	var futures []*Future
+
+	for i := 0; i < 8; i++ {
+		future, err := producer.Produce(ctx, &api.Record{
+			Value: []byte("hello world"),
+		})
+		require.NoError(t, err)
+		futures = append(futures, future)
+	}
+
+	for i, future := range futures {
+		offset, err := future.Offset(ctx)
+		require.NoError(t, err)
+		require.Equal(t, uint64(i), offset)
+
+		record, err := commitLog.Read(offset)
+		require.NoError(t, err)
+		require.Equal(t, []byte("hello world"), record.Value)
+	}
+}
+
+// testProduceLinger tests that a batch smaller than BatchSize is sent once
+// its first record has lingered for Linger.
+func testProduceLinger(t *testing.T, client api.LogClient, _ *flakyLog) {
+	producer, err := NewProducer(client, ProducerConfig{
+		BatchSize: 100,
+		Linger:    50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer producer.Close()
+
+	future, err := producer.Produce(context.Background(), &api.Record{
+		Value: []byte("hello world"),
+	})
+	require.NoError(t, err)
+
+	select {
+	case <-future.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the linger timeout to flush the batch")
+	}
+
+	offset, err := future.Offset(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), offset)
+}
+
+// testProduceRetry tests that a batch failing with a retryable error is
+// retried and eventually succeeds.
+func testProduceRetry(t *testing.T, client api.LogClient, commitLog *flakyLog) {
+	commitLog.fail(2, codes.Unavailable)
+
+	producer, err := NewProducer(client, ProducerConfig{
+		Backoff: time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer producer.Close()
+
+	ctx := context.Background()
+
+	future, err := producer.Produce(ctx, &api.Record{
+		Value: []byte("hello world"),
+	})
+	require.NoError(t, err)
+	require.NoError(t, producer.Flush(ctx))
+
+	offset, err := future.Offset(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), offset)
+}
+
+// TestRetryAfter tests that the retry delay requested by the server through
+// RetryInfo is read from errors, such as those returned when a quota is
+// exceeded.
+func TestRetryAfter(t *testing.T) {
+	st, err := status.New(codes.ResourceExhausted, "quota exceeded").WithDetails(
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(250 * time.Millisecond)},
+	)
+	require.NoError(t, err)
+
+	require.Equal(t, 250*time.Millisecond, retryAfter(st.Err()))
+	require.Zero(t, retryAfter(status.Error(codes.Unavailable, "unavailable")))
+}
+
+// testProduceFailure tests that a batch failing with a non-retryable error
+// resolves its futures with that error.
+func testProduceFailure(t *testing.T, client api.LogClient, commitLog *flakyLog) {
+	commitLog.fail(1, codes.InvalidArgument)
+
+	producer, err := NewProducer(client, ProducerConfig{})
+	require.NoError(t, err)
+	defer producer.Close()
+
+	ctx := context.Background()
+
+	future, err := producer.Produce(ctx, &api.Record{
+		Value: []byte("hello world"),
+	})
+	require.NoError(t, err)
+	require.NoError(t, producer.Flush(ctx))
+
+	_, err = future.Offset(ctx)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// testProducerClose tests that closing a producer flushes the records it
+// buffered and that producing afterwards fails.
+func testProducerClose(t *testing.T, client api.LogClient, _ *flakyLog) {
+	producer, err := NewProducer(client, ProducerConfig{
+		Linger: time.Hour,
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	future, err := producer.Produce(ctx, &api.Record{
+		Value: []byte("hello world"),
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, producer.Close())
+	require.NoError(t, producer.Close())
+
+	offset, err := future.Offset(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), offset)
+
+	_, err = producer.Produce(ctx, &api.Record{})
+	require.Equal(t, ErrProducerClosed, err)
+}
+
+// testProduceNoAcks tests that records produced without acknowledgements
+// resolve once sent, and that failures to append them are not retried.
+func testProduceNoAcks(t *testing.T, client api.LogClient, commitLog *flakyLog) {
+	producer, err := NewProducer(client, ProducerConfig{
+		Acks: api.Acks_ACKS_NONE,
+	})
+	require.NoError(t, err)
+	defer producer.Close()
+
+	ctx := context.Background()
+
+	produce := func() *Future {
+		future, err := producer.Produce(ctx, &api.Record{
+			Value: []byte("hello world"),
+		})
+		require.NoError(t, err)
+		require.NoError(t, producer.Flush(ctx))
+
+		return future
+	}
+
+	offset, err := produce().Offset(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), offset)
+
+	record, err := commitLog.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello world"), record.Value)
+
+	commitLog.fail(1, codes.Unavailable)
+
+	_, err = produce().Offset(ctx)
+	require.NoError(t, err)
+
+	_, err = commitLog.Read(1)
+	require.Error(t, err)
+}
+
+// TestProduceAckTimeout tests that only the record the replicas did not
+// acknowledge in time fails with the server's ErrorAckTimeout, and that the
+// records batched after it, which were not appended, are sent again.
+func TestProduceAckTimeout(t *testing.T) {
+	client, commitLog, teardown := setupTest(t, func(c *server.Config) {
+		c.Acknowledger = acknowledger{timeout: 1}
+	})
+	defer teardown()
+
+	producer, err := NewProducer(client, ProducerConfig{
+		BatchSize: 3,
+		Linger:    time.Hour,
+		Acks:      api.Acks_ACKS_ALL,
+	})
+	require.NoError(t, err)
+	defer producer.Close()
+
+	ctx := context.Background()
+
+	var futures []*Future
+
+	for range 3 {
+		future, err := producer.Produce(ctx, &api.Record{
+			Value: []byte("hello world"),
+		})
+		require.NoError(t, err)
+		futures = append(futures, future)
+	}
+
+	require.NoError(t, producer.Flush(ctx))
+
+	offset, err := futures[0].Offset(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), offset)
+
+	offset, err = futures[1].Offset(ctx)
+	require.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	require.Equal(t, uint64(1), offset)
+
+	offset, err = futures[2].Offset(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), offset)
+
+	_, err = commitLog.Read(3)
+	require.Error(t, err)
+}
+
+// acknowledger acknowledges every record but the one at offset timeout.
+type acknowledger struct {
+	timeout uint64
+}
+
+func (a acknowledger) WaitReplicated(ctx context.Context, offset uint64) []string {
+	if offset == a.timeout {
+		return []string{"follower"}
+	}
+
+	return nil
+}
+
+type authorizer struct{}
+
+// Authorize permits every request.
+func (a *authorizer) Authorize(subject, object, action string) error {
+	return nil
+}
+
+type flakyLog struct {
+	*log.Log
+	mu           sync.Mutex
+	failures     int
+	code         codes.Code
+	readFailures int
+}
+
+// fail makes the next n appends fail with the given code.
+func (l *flakyLog) fail(n int, code codes.Code) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.failures = n
+	l.code = code
+}
+
+// Append appends the record to the log unless a failure is pending.
+func (l *flakyLog) Append(record *api.Record) (uint64, error) {
+	l.mu.Lock()
+
+	if l.failures > 0 {
+		l.failures--
+		code := l.code
+		l.mu.Unlock()
+		return 0, status.Error(code, "injected failure")
+	}
+
+	l.mu.Unlock()
+
+	return l.Log.Append(record)
+}
+
+// failReads makes the next n reads of existing records fail with
+// Unavailable.
+func (l *flakyLog) failReads(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.readFailures = n
+}
+
+// Read reads the record from the log unless a read failure is pending and
+// the record exists.
+func (l *flakyLog) Read(off uint64) (*api.Record, error) {
+	record, err := l.Log.Read(off)
+
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.readFailures > 0 {
+		l.readFailures--
+		return nil, status.Error(codes.Unavailable, "injected failure")
+	}
+
+	return record, nil
+}
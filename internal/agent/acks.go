@@ -0,0 +1,49 @@
+package agent
+
+import (
+	"context"
+
+	"github.com/Gibson-Gichuru/prolog/internal/discovery"
+)
+
+// WaitReplicated waits until the agent's in-sync replicas have replicated
+// the record at offset of its log, returning the names of those that had
+// not when ctx is done. Every other server in the cluster that is not
+// draining is an in-sync replica. See log.Replicator.WaitReplicated.
+func (a *Agent) WaitReplicated(ctx context.Context, offset uint64) []string {
+	servers, err := a.GetServers()
+
+	if err != nil || a.replicator == nil {
+		return nil
+	}
+
+	var names []string
+
+	for _, server := range servers {
+		if server.Id == a.Config.NodeName ||
+			server.Tags[discovery.TagDraining] == "true" {
+			continue
+		}
+
+		names = append(names, server.Id)
+	}
+
+	local, err := a.peerName()
+
+	if err != nil {
+		return names
+	}
+
+	return a.replicator.WaitReplicated(ctx, local, offset, names)
+}
+
+// peerName returns the name the agent's peers replicate it by: its RPC
+// address with DNS discovery, which only knows servers by address, and its
+// node name otherwise.
+func (a *Agent) peerName() (string, error) {
+	if _, ok := a.membership.(*discovery.DNS); ok {
+		return a.Config.RPCAddr()
+	}
+
+	return a.Config.NodeName, nil
+}
@@ -0,0 +1,114 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/Gibson-Gichuru/prolog/internal/discovery"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestAcksDNS tests that with DNS discovery, where the agents know each
+// other by RPC address, a produce acknowledged by all in-sync replicas waits
+// for the follower, and times out naming it while it does not replicate.
+func TestAcksDNS(t *testing.T) {
+	resolver := &srvResolver{}
+
+	var leaderAddr string
+
+	agents, peerConfig := setupAgents(t, 2, func(i int, c *Config) {
+		addr := fmt.Sprintf("127.0.0.1:%d", c.RPCPort)
+
+		if i == 0 {
+			leaderAddr = addr
+		}
+
+		resolver.add(c.RPCPort)
+
+		c.DiscoveryDNS = discovery.DNSConfig{
+			Name:       "_prolog._tcp.prolog.test",
+			LeaderAddr: leaderAddr,
+			Interval:   50 * time.Millisecond,
+			Resolver:   resolver,
+		}
+	})
+
+	leader, follower := agents[0], agents[1]
+
+	// DNS discovery does not tell the leader when the follower leaves, so
+	// the follower stops replicating before the leader waits for its
+	// streams to end on shutdown.
+	t.Cleanup(func() { require.NoError(t, follower.replicator.Close()) })
+	followerAddr, err := follower.Config.RPCAddr()
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		servers, err := leader.GetServers()
+		return err == nil && len(servers) == 2
+	}, 5*time.Second, 50*time.Millisecond)
+
+	client := client(t, leader, peerConfig)
+	ctx := context.Background()
+
+	res, err := client.Produce(ctx, &api.ProduceRequest{
+		Record:       &api.Record{Value: []byte("hello world")},
+		Acks:         api.Acks_ACKS_ALL,
+		AckTimeoutMs: 5000,
+	})
+	require.NoError(t, err)
+	require.Greater(t, follower.Replicated()[leaderAddr], res.Offset)
+
+	// A follower that stops replicating no longer acknowledges records.
+	require.NoError(t, follower.replicator.Close())
+
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record:       &api.Record{Value: []byte("hello world")},
+		Acks:         api.Acks_ACKS_ALL,
+		AckTimeoutMs: 200,
+	})
+
+	st := status.Convert(err)
+	require.Equal(t, codes.DeadlineExceeded, st.Code())
+	require.Len(t, st.Details(), 1)
+	require.Equal(
+		t,
+		followerAddr,
+		st.Details()[0].(*errdetails.ErrorInfo).Metadata["replicas"],
+	)
+}
+
+// srvResolver lists the servers added to it as SRV records on the loopback
+// address.
+type srvResolver struct {
+	mu      sync.Mutex
+	records []*net.SRV
+}
+
+func (r *srvResolver) add(port int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.records = append(r.records, &net.SRV{Target: "127.0.0.1.", Port: uint16(port)})
+}
+
+func (r *srvResolver) LookupSRV(
+	ctx context.Context,
+	service, proto, name string,
+) (string, []*net.SRV, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return name, append([]*net.SRV(nil), r.records...), nil
+}
+
+func (r *srvResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return []string{host}, nil
+}
@@ -1,22 +1,38 @@
 package agent
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"net"
+	"net/http"
+	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/Gibson-Gichuru/prolog/internal/audit"
 	"github.com/Gibson-Gichuru/prolog/internal/auth"
 	"github.com/Gibson-Gichuru/prolog/internal/discovery"
 	"github.com/Gibson-Gichuru/prolog/internal/log"
 	"github.com/Gibson-Gichuru/prolog/internal/server"
+	"github.com/Gibson-Gichuru/prolog/internal/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 )
 
 type Config struct {
+	// ServerTLSConfig and PeerTLSConfig secure connections from clients and
+	// to the other members. Set them up with config.SetupTLSConfig's Reload
+	// to rotate certificates without a restart, and CRLFile to reject
+	// revoked ones.
 	ServerTLSConfig *tls.Config
 	PeerTLSConfig   *tls.Config
 	DataDir         string
@@ -26,6 +42,75 @@ type Config struct {
 	StartJoinAddrs  []string
 	ACLModelFile    string
 	ACLPolicyFile   string
+	// Authenticators identify the subject of each request, tried in order.
+	// Subjects are the common names of client certificates if empty.
+	Authenticators []server.Authenticator
+	// Topic names the agent's log in access control policies, as the
+	// resource "topics/<Topic>". It defaults to server.DefaultTopic.
+	Topic string
+	// RestoreFrom is the path of a snapshot, as streamed by the Snapshot
+	// RPC, that DataDir is rebuilt from before the agent opens its log,
+	// replacing its existing contents. Every start restores it again, so
+	// unset it once the agent has started. DataDir is used as is if empty.
+	RestoreFrom string
+	// Tracing configures where the agent exports traces and how they are
+	// sampled.
+	Tracing tracing.Config
+	// MetricsAddr is the address the agent serves Prometheus metrics and
+	// its /healthz and /readyz endpoints on. Nothing is served when it is
+	// empty.
+	MetricsAddr string
+	// GatewayAddr is the address the agent serves the log's HTTP/JSON
+	// gateway on. The gateway is not served when it is empty.
+	GatewayAddr string
+	// GatewayOrigins lists the browser origins allowed to open WebSocket
+	// connections to the gateway in addition to its own.
+	GatewayOrigins []string
+	// Quotas limits the rate at which each client subject may produce and
+	// consume, across both the gRPC server and the gateway. List the subject
+	// of PeerTLSConfig's certificate in its Exempt subjects so that
+	// replication from the other members is never slowed down by it.
+	Quotas server.QuotaConfig
+	// AuditDir is the directory of the log the agent's authorization
+	// decisions are written to, as JSON encoded audit.Event records. It must
+	// not be inside DataDir. Decisions are not audited when it is empty.
+	// Subjects allowed to administer the ACLs read it by consuming the
+	// server.AuditTopic. The log is not replicated, so reading it through
+	// an agent returns that agent's decisions only.
+	AuditDir string
+	// AuditFilter selects the decisions written to the audit log: denied
+	// requests only by default, or every decision with audit.FilterAll.
+	AuditFilter audit.Filter
+	// StaticServers lists the cluster's servers, including this one, in place
+	// of discovering them by gossip, for environments where gossip is blocked.
+	StaticServers []discovery.Server
+	// DiscoveryDNS discovers the cluster's servers by polling DNS records in
+	// place of gossip when its Name is set. The agent's NodeName and RPC
+	// address are filled in, and it is the LeaderAddr if it is the Leader.
+	DiscoveryDNS discovery.DNSConfig
+	// GossipEncryptKey is the base64 encoded key encrypting gossip between
+	// members, and GossipKeyringFile the file keeping the keys installed
+	// through the gossip key RPCs. See discovery.Config.
+	GossipEncryptKey  string
+	GossipKeyringFile string
+	// Leader marks this node as the cluster's write leader. It is advertised
+	// to the other members through the leader membership tag.
+	Leader bool
+	// Tags describe the node to the other members, such as its
+	// discovery.TagZone, TagRack, TagRole and TagVersion. They are published
+	// as serf tags alongside rpc_addr and leader, which they cannot override,
+	// and returned by GetServers.
+	Tags map[string]string
+	// Replicas limits how many peers the agent replicates from, spreading
+	// them across zones. See log.Replicator.
+	Replicas int
+	// DrainTimeout is how long a drain waits for the agent's streams to
+	// finish before cancelling them, unless the Drain RPC gives its own. It
+	// defaults to DefaultDrainTimeout.
+	DrainTimeout time.Duration
+	// DrainSignals are the signals, such as syscall.SIGTERM, on which the
+	// agent drains and then shuts down. Signals are not handled if empty.
+	DrainSignals []os.Signal
 }
 
 // RPCAddr returns the address that the agent will expose its RPC server on, in
@@ -41,12 +126,32 @@ func (c Config) RPCAddr() (string, error) {
 	return fmt.Sprintf("%s:%d", host, c.RPCPort), nil
 }
 
+// The replicator is told of members' tags and of changes to them, so that it
+// dials members at their new address and places replicas by zone.
+var (
+	_ discovery.TagsHandler   = (*log.Replicator)(nil)
+	_ discovery.UpdateHandler = (*log.Replicator)(nil)
+)
+
 type Agent struct {
 	Config
 	log        *log.Log
+	auditLog   *log.Log
 	server     *grpc.Server
-	membership *discovery.MemberShip
+	membership discovery.Provider
 	replicator *log.Replicator
+	tracer     *tracing.Tracer
+	health     *health.Server
+	httpServer *http.Server
+	gateway    *http.Server
+	authorizer *auth.Authorizer
+	streams    *server.Streams
+	registry   *discovery.Registry
+
+	draining  atomic.Bool
+	drainOnce sync.Once
+	drained   *api.DrainResponse
+	drainErr  error
 
 	shutdown     bool
 	shutdowns    chan struct{}
@@ -54,8 +159,9 @@ type Agent struct {
 }
 
 // New returns a new Agent with the given configuration. It sets up the agent's
-// logger, log, server, and membership, and returns an error if any of the
-// setup steps fail.
+// logger, tracing, health, log, server, membership, and HTTP endpoints, and
+// returns an error if any of the setup steps fail. The agent only reports
+// itself as serving once every step has succeeded.
 func New(config Config) (*Agent, error) {
 	a := &Agent{
 		Config:    config,
@@ -64,9 +170,12 @@ func New(config Config) (*Agent, error) {
 
 	setups := []func() error{
 		a.setupLogger,
+		a.setupTracing,
+		a.setupHealth,
 		a.setupLog,
 		a.setupServer,
 		a.setupMembership,
+		a.setupHTTP,
 	}
 
 	for _, setup := range setups {
@@ -75,6 +184,12 @@ func New(config Config) (*Agent, error) {
 		}
 	}
 
+	a.setServingStatus(healthpb.HealthCheckResponse_SERVING)
+
+	if len(a.Config.DrainSignals) > 0 {
+		go a.drainOnSignal()
+	}
+
 	return a, nil
 }
 
@@ -92,10 +207,49 @@ func (a *Agent) setupLogger() error {
 	return nil
 }
 
-// setupLog sets up the agent's Log. It creates a new log in the DataDir
-// specified in the agent's Config, using the default log config. It returns an
-// error if the log cannot be created.
+// setupTracing sets up the agent's tracer from the Tracing section of the
+// agent's Config. Spans are tagged with the node name unless a service name is
+// configured. It returns an error if the exporter cannot be created.
+func (a *Agent) setupTracing() error {
+	config := a.Config.Tracing
+
+	if config.ServiceName == "" {
+		config.ServiceName = a.Config.NodeName
+	}
+
+	var err error
+
+	a.tracer, err = tracing.New(config)
+
+	return err
+}
+
+// setupHealth sets up the health server backing the agent's grpc.health.v1
+// service and /readyz endpoint. The agent is reported as NOT_SERVING until it
+// has opened its log and joined the cluster.
+func (a *Agent) setupHealth() error {
+	a.health = health.NewServer()
+	a.setServingStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+	return nil
+}
+
+// setServingStatus sets the serving status of both the overall server and the
+// Log service.
+func (a *Agent) setServingStatus(status healthpb.HealthCheckResponse_ServingStatus) {
+	a.health.SetServingStatus("", status)
+	a.health.SetServingStatus(api.Log_ServiceDesc.ServiceName, status)
+}
+
+// setupLog sets up the agent's Log. It restores the DataDir specified in the
+// agent's Config from the RestoreFrom snapshot if one is configured, creates a
+// new log in it using the default log config, and the audit log in the
+// AuditDir if one is configured. It returns an error if the snapshot cannot be
+// restored or either log cannot be created.
 func (a *Agent) setupLog() error {
+	if err := a.restore(); err != nil {
+		return err
+	}
+
 	var err error
 
 	a.log, err = log.NewLog(
@@ -103,23 +257,86 @@ func (a *Agent) setupLog() error {
 		log.Config{},
 	)
 
+	if err != nil || a.Config.AuditDir == "" {
+		return err
+	}
+
+	a.auditLog, err = log.NewLog(
+		a.Config.AuditDir,
+		log.Config{},
+	)
+
 	return err
 }
 
+// restore rebuilds the agent's DataDir from the RestoreFrom snapshot, if one
+// is configured.
+func (a *Agent) restore() error {
+	if a.Config.RestoreFrom == "" {
+		return nil
+	}
+
+	f, err := os.Open(a.Config.RestoreFrom)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	if err := log.Restore(a.Config.DataDir, f); err != nil {
+		return fmt.Errorf("restore %s: %w", a.Config.RestoreFrom, err)
+	}
+
+	return nil
+}
+
 // setupServer sets up the agent's gRPC server. It creates a new server with a
-// configuration based on the agent's Log and ACL configuration. It then
-// starts listening on the address specified in the agent's Config, and
-// returns an error if any of the setup steps fail.
+// configuration based on the agent's Log and ACL configuration, reloading the
+// ACL policy whenever its file changes. It then starts listening on the
+// address specified in the agent's Config, sets up the HTTP/JSON gateway with
+// the same configuration, and returns an error if any of the setup steps
+// fail.
 func (a *Agent) setupServer() error {
 
-	authorizer := auth.New(
+	authorizer, err := auth.New(
 		a.Config.ACLModelFile,
 		a.Config.ACLPolicyFile,
 	)
 
+	if err != nil {
+		return err
+	}
+
+	authorizer.Watch()
+
+	a.authorizer = authorizer
+	a.streams = &server.Streams{}
+
 	serverConfig := &server.Config{
-		CommitLog:  a.log,
-		Authorizer: authorizer,
+		Topic:            a.Config.Topic,
+		CommitLog:        a.log,
+		Authorizer:       authorizer,
+		Authenticators:   a.Config.Authenticators,
+		Snapshotter:      a.log,
+		Describer:        a.log,
+		GetServerer:      a,
+		PolicyManager:    authorizer,
+		GossipKeyManager: a,
+		Drainer:          a,
+		Streams:          a.streams,
+		Commander:        a,
+		ReplicationState: a,
+		Acknowledger:     a,
+		TracerProvider:   a.tracer,
+		Health:           a.health,
+		AllowedOrigins:   a.Config.GatewayOrigins,
+		Quotas:           server.NewQuotas(a.Config.Quotas),
+	}
+
+	if a.auditLog != nil {
+		serverConfig.Auditor = audit.New(a.auditLog, a.Config.AuditFilter)
+		serverConfig.AuditLog = a.auditLog
 	}
 
 	var opts []grpc.ServerOption
@@ -129,8 +346,6 @@ func (a *Agent) setupServer() error {
 		opts = append(opts, grpc.Creds(creds))
 	}
 
-	var err error
-
 	a.server, err = server.NewGRPCServer(serverConfig, opts...)
 
 	if err != nil {
@@ -155,15 +370,17 @@ func (a *Agent) setupServer() error {
 		}
 	}()
 
-	return err
+	return a.setupGateway(serverConfig)
 }
 
 // setupMembership sets up the agent's membership and replication components.
-// It establishes a gRPC client connection using the provided RPC address and 
-// peer TLS configuration, if available. The function creates a LogClient and 
-// initializes the replicator with the dial options and local server. It also 
-// configures the membership with the agent's node name, bind address, and 
-// starting join addresses, returning any error encountered during the setup process.
+// It establishes a gRPC client connection using the provided RPC address and
+// peer TLS configuration, if available. The function creates a LogClient and
+// initializes the replicator with the dial options and local server. It then
+// discovers the cluster from the agent's StaticServers or DiscoveryDNS if
+// either is configured, and otherwise by Serf gossip with the agent's node
+// name, bind address, and starting join addresses, returning any error
+// encountered during the setup process.
 func (a *Agent) setupMembership() error {
 	rpcAddr, err := a.Config.RPCAddr()
 
@@ -171,7 +388,12 @@ func (a *Agent) setupMembership() error {
 		return err
 	}
 
-	var opts []grpc.DialOption
+	opts := []grpc.DialOption{
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler(
+			otelgrpc.WithTracerProvider(a.tracer),
+			otelgrpc.WithPropagators(tracing.Propagator),
+		)),
+	}
 
 	if a.Config.PeerTLSConfig != nil {
 		opts = append(
@@ -191,27 +413,164 @@ func (a *Agent) setupMembership() error {
 	client := api.NewLogClient(conn)
 
 	a.replicator = &log.Replicator{
-		DialOptions: opts,
-		LocalServer: client,
+		DialOptions:    opts,
+		LocalServer:    client,
+		TracerProvider: a.tracer,
+		Zone:           a.Config.Tags[discovery.TagZone],
+		Replicas:       a.Config.Replicas,
 	}
 
-	a.membership, err = discovery.New(a.replicator, discovery.Config{
-		NodeName: a.Config.NodeName,
-		BindAddr: a.Config.BindAddr,
-		Tags: map[string]string{
-			"rpc_addr": rpcAddr,
-		},
+	a.setupCommands()
+
+	switch {
+	case len(a.Config.StaticServers) > 0:
+		membership, err := discovery.NewStatic(a.replicator, discovery.StaticConfig{
+			NodeName: a.Config.NodeName,
+			Servers:  a.Config.StaticServers,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		a.membership = membership
+
+		return nil
+
+	case a.Config.DiscoveryDNS.Name != "":
+		config := a.Config.DiscoveryDNS
+		config.NodeName = a.Config.NodeName
+		config.RPCAddr = rpcAddr
+
+		if a.Config.Leader && config.LeaderAddr == "" {
+			config.LeaderAddr = rpcAddr
+		}
+
+		membership, err := discovery.NewDNS(a.replicator, config)
+
+		if err != nil {
+			return err
+		}
+
+		a.membership = membership
+
+		return nil
+	}
+
+	tags := make(map[string]string, len(a.Config.Tags)+2)
+
+	for key, value := range a.Config.Tags {
+		tags[key] = value
+	}
+
+	tags["rpc_addr"] = rpcAddr
+
+	if a.Config.Leader {
+		tags["leader"] = "true"
+	}
+
+	membership, err := discovery.New(a.replicator, discovery.Config{
+		NodeName:       a.Config.NodeName,
+		BindAddr:       a.Config.BindAddr,
+		Tags:           tags,
 		StartJoinAddrs: a.Config.StartJoinAddrs,
+		EncryptKey:     a.Config.GossipEncryptKey,
+		KeyringFile:    a.Config.GossipKeyringFile,
+		Registry:       a.registry,
 	})
 
-	return err
+	if err != nil {
+		return err
+	}
+
+	a.membership = membership
+
+	return nil
 
 }
 
-// Shutdown shuts down the agent. It stops the replicator, leaves the cluster,
-// shuts down the gRPC server, and closes the log. It returns an error if any
-// of the shutdown steps fail. Shutdown is safe to call multiple times and will
-// not return an error if the agent is already shut down.
+// GetServers returns the servers in the cluster as seen by the agent's
+// membership. The gRPC server is set up before membership, so it returns an
+// Unavailable error until the agent has joined the cluster.
+func (a *Agent) GetServers() ([]*api.Server, error) {
+	if a.membership == nil {
+		return nil, status.Error(codes.Unavailable, "membership not set up")
+	}
+
+	return a.membership.GetServers()
+}
+
+// InstallKey installs a gossip encryption key across the cluster through
+// the agent's membership.
+func (a *Agent) InstallKey(key string) (*api.GossipKeysResponse, error) {
+	membership, err := a.gossip()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return membership.InstallKey(key)
+}
+
+// UseKey switches the cluster to an installed gossip encryption key.
+func (a *Agent) UseKey(key string) (*api.GossipKeysResponse, error) {
+	membership, err := a.gossip()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return membership.UseKey(key)
+}
+
+// RemoveKey removes a gossip encryption key across the cluster.
+func (a *Agent) RemoveKey(key string) (*api.GossipKeysResponse, error) {
+	membership, err := a.gossip()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return membership.RemoveKey(key)
+}
+
+// ListKeys lists the gossip encryption keys installed across the cluster.
+func (a *Agent) ListKeys() (*api.GossipKeysResponse, error) {
+	membership, err := a.gossip()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return membership.ListKeys()
+}
+
+// gossip returns the agent's Serf membership, which manages the gossip keys.
+// It returns an Unavailable error until the agent has joined the cluster, and
+// a FailedPrecondition error if the agent discovers the cluster without
+// gossip.
+func (a *Agent) gossip() (*discovery.MemberShip, error) {
+	if a.membership == nil {
+		return nil, status.Error(codes.Unavailable, "membership not set up")
+	}
+
+	membership, ok := a.membership.(*discovery.MemberShip)
+
+	if !ok {
+		return nil, status.Error(
+			codes.FailedPrecondition,
+			"cluster is not discovered by gossip",
+		)
+	}
+
+	return membership, nil
+}
+
+// Shutdown shuts down the agent. It reports the agent as NOT_SERVING, stops
+// the replicator, leaves the cluster, shuts down the gRPC server, and closes
+// the log. It returns an error if any of the shutdown steps fail. Shutdown is
+// safe to call multiple times and will not return an error if the agent is
+// already shut down.
 func (a *Agent) Shutdown() error {
 
 	a.shutdownLock.Lock()
@@ -226,13 +585,37 @@ func (a *Agent) Shutdown() error {
 	close(a.shutdowns)
 
 	shutdown := []func() error{
+		func() error {
+			a.health.Shutdown()
+			return nil
+		},
 		a.membership.Leave,
 		a.replicator.Close,
+		func() error {
+			if a.gateway == nil {
+				return nil
+			}
+			return a.gateway.Close()
+		},
 		func() error {
 			a.server.GracefulStop()
 			return nil
 		},
 		a.log.Close,
+		func() error {
+			if a.auditLog == nil {
+				return nil
+			}
+			return a.auditLog.Close()
+		},
+		a.authorizer.Close,
+		func() error {
+			return a.tracer.Shutdown(context.Background())
+		},
+	}
+
+	if a.httpServer != nil {
+		shutdown = append(shutdown, a.httpServer.Close)
 	}
 
 	for _, fn := range shutdown {
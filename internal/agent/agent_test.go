@@ -3,17 +3,33 @@ package agent
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"testing"
 	"time"
 
 	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/Gibson-Gichuru/prolog/internal/audit"
 	"github.com/Gibson-Gichuru/prolog/internal/config"
+	"github.com/Gibson-Gichuru/prolog/internal/discovery"
+	"github.com/Gibson-Gichuru/prolog/internal/log"
+	"github.com/Gibson-Gichuru/prolog/internal/server"
+	"github.com/Gibson-Gichuru/prolog/internal/tracing"
 	"github.com/stretchr/testify/require"
 	"github.com/travisjeffery/go-dynaport"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
 )
 
 func TestAgent(t *testing.T) {
@@ -43,14 +59,21 @@ func TestAgent(t *testing.T) {
 
 	var agents []*Agent
 
+	spans := tracetest.NewInMemoryExporter()
+
 	for i := 0; i < 3; i++ {
-		ports := dynaport.Get(2)
+		ports := dynaport.Get(4)
 		bindAdd := fmt.Sprintf("%s:%d", "127.0.0.1", ports[0])
 		rpcPort := ports[1]
+		metricsAddr := fmt.Sprintf("%s:%d", "127.0.0.1", ports[2])
+		gatewayAddr := fmt.Sprintf("%s:%d", "127.0.0.1", ports[3])
 
 		dataDir, err := os.MkdirTemp("", "agent-test-log")
 		require.NoError(t, err)
 
+		auditDir, err := os.MkdirTemp("", "agent-test-audit")
+		require.NoError(t, err)
+
 		var startJoinAddrs []string
 
 		if i != 0 {
@@ -59,15 +82,26 @@ func TestAgent(t *testing.T) {
 
 		agent, err := New(
 			Config{
-				NodeName:        fmt.Sprintf("%d", i),
-				StartJoinAddrs:  startJoinAddrs,
-				BindAddr:        bindAdd,
-				RPCPort:         rpcPort,
-				DataDir:         dataDir,
-				ServerTLSConfig: serverConfig,
-				PeerTLSConfig:   peerConfig,
-				ACLModelFile:    config.ACLModelFile,
-				ACLPolicyFile:   config.ACLPolicyFile,
+				Leader:      i == 0,
+				MetricsAddr: metricsAddr,
+				GatewayAddr: gatewayAddr,
+				Tracing: tracing.Config{
+					SpanExporter:   spans,
+					SampleProduces: true,
+				},
+				NodeName:         fmt.Sprintf("%d", i),
+				Tags:             map[string]string{discovery.TagZone: fmt.Sprintf("zone-%d", i)},
+				StartJoinAddrs:   startJoinAddrs,
+				BindAddr:         bindAdd,
+				RPCPort:          rpcPort,
+				DataDir:          dataDir,
+				AuditDir:         auditDir,
+				AuditFilter:      audit.FilterAll,
+				GossipEncryptKey: "T9jncgl9mbLus+baTTa7q7nPSUrXwbDi2dhbtqir37s=",
+				ServerTLSConfig:  serverConfig,
+				PeerTLSConfig:    peerConfig,
+				ACLModelFile:     config.ACLModelFile,
+				ACLPolicyFile:    config.ACLPolicyFile,
 			},
 		)
 
@@ -81,6 +115,7 @@ func TestAgent(t *testing.T) {
 			err := agent.Shutdown()
 			require.NoError(t, err)
 			require.NoError(t, os.RemoveAll(agent.Config.DataDir))
+			require.NoError(t, os.RemoveAll(agent.Config.AuditDir))
 		}
 	}()
 
@@ -99,6 +134,10 @@ func TestAgent(t *testing.T) {
 
 	require.NoError(t, err)
 
+	// The produce was audited on the leader.
+	_, err = agents[0].auditLog.Read(0)
+	require.NoError(t, err)
+
 	consumerReponse, err := leaderClient.Consume(
 		context.Background(),
 		&api.ConsumeRequest{
@@ -108,6 +147,8 @@ func TestAgent(t *testing.T) {
 
 	require.NoError(t, err)
 	require.Equal(t, consumerReponse.Record.Value, []byte("hello world"))
+	// The produce's trace context is not stored with the record.
+	require.Empty(t, consumerReponse.Record.TraceContext)
 
 	time.Sleep(3 * time.Second)
 	followerClient := client(t, agents[1], peerConfig)
@@ -121,6 +162,400 @@ func TestAgent(t *testing.T) {
 
 	require.NoError(t, err)
 	require.Equal(t, consumerReponse.Record.Value, []byte("hello world"))
+	require.Empty(t, consumerReponse.Record.TraceContext)
+
+	// A follower that has not replicated the offset redirects to the leader.
+	_, err = followerClient.Consume(
+		context.Background(),
+		&api.ConsumeRequest{MinOffset: 1 << 20},
+	)
+
+	st := status.Convert(err)
+	require.Equal(t, codes.Unavailable, st.Code())
+	require.Len(t, st.Details(), 1)
+
+	leaderAddr, err := agents[0].Config.RPCAddr()
+	require.NoError(t, err)
+
+	info := st.Details()[0].(*errdetails.ErrorInfo)
+	require.Equal(t, "STALE_READ", info.Reason)
+	require.Equal(t, leaderAddr, info.Metadata["leader_addr"])
+
+	// Every server replicates from every other, so the leader's log grows
+	// with the records replicated back to it faster than the followers
+	// replicate it, and they do not acknowledge new records in time.
+	_, err = leaderClient.Produce(
+		context.Background(),
+		&api.ProduceRequest{
+			Record:       &api.Record{Value: []byte("acknowledged")},
+			Acks:         api.Acks_ACKS_ALL,
+			AckTimeoutMs: 200,
+		},
+	)
+
+	st = status.Convert(err)
+	require.Equal(t, codes.DeadlineExceeded, st.Code())
+	require.Len(t, st.Details(), 1)
+
+	info = st.Details()[0].(*errdetails.ErrorInfo)
+	require.Equal(t, "ACK_TIMEOUT", info.Reason)
+	require.Equal(
+		t,
+		agents[1].Config.NodeName+","+agents[2].Config.NodeName,
+		info.Metadata["replicas"],
+	)
+
+	describeResponse, err := followerClient.Describe(
+		context.Background(),
+		&api.DescribeRequest{},
+	)
+	require.NoError(t, err)
+	require.NotZero(t, describeResponse.Replicated[agents[0].Config.NodeName])
+
+	gatewayClient := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: peerConfig},
+	}
+
+	res, err := gatewayClient.Get(fmt.Sprintf(
+		"https://%s/v1/records/%d",
+		agents[1].Config.GatewayAddr,
+		produceResponse.Offset,
+	))
+	require.NoError(t, err)
+
+	gatewayResponse, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	consumerReponse = &api.ConsumeResponse{}
+	require.NoError(t, protojson.Unmarshal(gatewayResponse, consumerReponse))
+	require.Equal(t, consumerReponse.Record.Value, []byte("hello world"))
+
+	serversResponse, err := followerClient.GetServers(
+		context.Background(),
+		&api.GetServersRequest{},
+	)
+
+	require.NoError(t, err)
+	require.Equal(t, 3, len(serversResponse.Servers))
+
+	for _, server := range serversResponse.Servers {
+		rpcAddr, err := agents[0].Config.RPCAddr()
+		require.NoError(t, err)
+
+		require.Equal(t, "zone-"+server.Id, server.Tags[discovery.TagZone])
+
+		if server.Id == agents[0].Config.NodeName {
+			require.True(t, server.IsLeader)
+			require.Equal(t, rpcAddr, server.RpcAddr)
+		} else {
+			require.False(t, server.IsLeader)
+		}
+	}
+
+	for _, agent := range agents {
+		tp := agent.tracer.TracerProvider.(*sdktrace.TracerProvider)
+		require.NoError(t, tp.ForceFlush(context.Background()))
+	}
+
+	requireReplicationTraced(t, spans.GetSpans())
+
+	res, err = http.Get(fmt.Sprintf("http://%s/metrics", agents[0].Config.MetricsAddr))
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	metrics, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	for _, metric := range []string{
+		"prolog_log_segments",
+		"prolog_log_highest_offset",
+		"prolog_log_append_latency_bucket",
+		`prolog_cluster_members{status="alive"} 3`,
+		"prolog_replication_lag_records",
+		"prolog_grpc_io_server_completed_rpcs",
+	} {
+		require.Contains(t, string(metrics), metric)
+	}
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		res, err := http.Get(fmt.Sprintf("http://%s%s", agents[1].Config.MetricsAddr, path))
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	healthResponse, err := healthClient(t, agents[1], peerConfig).Check(
+		context.Background(),
+		&healthpb.HealthCheckRequest{Service: api.Log_ServiceDesc.ServiceName},
+	)
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, healthResponse.Status)
+
+	commandResponse, err := followerClient.Command(
+		context.Background(),
+		&api.CommandRequest{Name: CommandStats, TimeoutSeconds: 3},
+	)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, commandResponse.NumNodes)
+	require.Len(t, commandResponse.Results, 3)
+
+	for _, result := range commandResponse.Results {
+		require.Empty(t, result.Error)
+
+		var stats Stats
+		require.NoError(t, json.Unmarshal(result.Payload, &stats))
+		require.NotZero(t, stats.Segments)
+		require.GreaterOrEqual(t, stats.HighestOffset, produceResponse.Offset)
+	}
+
+	require.NoError(t, agents[2].Shutdown())
+
+	healthResponse, err = agents[2].health.Check(
+		context.Background(),
+		&healthpb.HealthCheckRequest{},
+	)
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, healthResponse.Status)
+
+	// Draining the leader hands its leadership to the remaining agent,
+	// cancels the follower's replication stream, and redirects produces.
+	drainResponse, err := leaderClient.Drain(
+		context.Background(),
+		&api.DrainRequest{TimeoutSeconds: 3},
+	)
+	require.NoError(t, err)
+	require.Equal(t, agents[1].Config.NodeName, drainResponse.Successor)
+	require.NotZero(t, drainResponse.CancelledStreams)
+
+	serversResponse, err = followerClient.GetServers(
+		context.Background(),
+		&api.GetServersRequest{},
+	)
+	require.NoError(t, err)
+
+	for _, server := range serversResponse.Servers {
+		require.Equal(t, server.Id == agents[1].Config.NodeName, server.IsLeader)
+	}
+
+	followerAddr, err := agents[1].Config.RPCAddr()
+	require.NoError(t, err)
+
+	_, err = leaderClient.Produce(
+		context.Background(),
+		&api.ProduceRequest{Record: &api.Record{Value: []byte("drained")}},
+	)
+	st = status.Convert(err)
+	require.Equal(t, codes.Unavailable, st.Code())
+	require.Len(t, st.Details(), 1)
+	require.Equal(
+		t,
+		followerAddr,
+		st.Details()[0].(*errdetails.ErrorInfo).Metadata["redirect_addr"],
+	)
+
+	healthResponse, err = agents[0].health.Check(
+		context.Background(),
+		&healthpb.HealthCheckRequest{},
+	)
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, healthResponse.Status)
+}
+
+// TestReplicationQuotas tests that replication catches up with the leader on
+// followers with a tight default quota, whether the subject the peers
+// replicate as is exempt from it or not.
+func TestReplicationQuotas(t *testing.T) {
+	const records = 5
+
+	quota := server.Quota{ProduceRequestsPerSecond: 1}
+
+	agents, peerConfig := setupAgents(t, 3, func(i int, c *Config) {
+		// Followers only replicate the leader, so its records all go
+		// through the quota.
+		c.Replicas = 1
+
+		switch i {
+		case 1:
+			c.Quotas = server.QuotaConfig{Default: quota}
+		case 2:
+			c.Quotas = server.QuotaConfig{Default: quota, Exempt: []string{"root"}}
+		}
+	})
+
+	leader := client(t, agents[0], peerConfig)
+
+	for i := 0; i < records; i++ {
+		_, err := leader.Produce(context.Background(), &api.ProduceRequest{
+			Record: &api.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err)
+	}
+
+	for _, follower := range agents[1:] {
+		require.Eventually(t, func() bool {
+			replicated := follower.Replicated()[agents[0].Config.NodeName]
+			return replicated >= records
+		}, 15*time.Second, 100*time.Millisecond, "follower %s", follower.Config.NodeName)
+	}
+}
+
+// TestRestoreFrom tests that an agent started with RestoreFrom serves the
+// records of the snapshot it was restored from.
+func TestRestoreFrom(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err := l.Append(&api.Record{Value: []byte(fmt.Sprintf("record %d", i))})
+		require.NoError(t, err)
+	}
+
+	snapshot, err := os.Create(dir + "/snapshot")
+	require.NoError(t, err)
+
+	_, err = l.Snapshot(snapshot)
+	require.NoError(t, err)
+	require.NoError(t, snapshot.Close())
+	require.NoError(t, l.Close())
+
+	agents, peerConfig := setupAgents(t, 1, func(_ int, c *Config) {
+		c.RestoreFrom = snapshot.Name()
+	})
+
+	leader := client(t, agents[0], peerConfig)
+
+	for i := 0; i < 3; i++ {
+		res, err := leader.Consume(context.Background(), &api.ConsumeRequest{
+			Offset: uint64(i),
+		})
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("record %d", i), string(res.Record.Value))
+	}
+}
+
+// requireReplicationTraced checks that the trace started by producing to the
+// leader continues on a follower, where replicating the record and appending
+// it locally are recorded as part of the same trace.
+func requireReplicationTraced(t *testing.T, spans tracetest.SpanStubs) {
+	t.Helper()
+
+	type trace struct {
+		produces   int
+		replicated bool
+	}
+
+	traces := make(map[oteltrace.TraceID]*trace)
+
+	for _, span := range spans {
+		traceID := span.SpanContext.TraceID()
+
+		if traces[traceID] == nil {
+			traces[traceID] = &trace{}
+		}
+
+		switch {
+		case span.Name == "log.v1.Log/Produce" &&
+			span.SpanKind == oteltrace.SpanKindServer:
+			traces[traceID].produces++
+		case span.Name == "Replicator.replicate":
+			traces[traceID].replicated = true
+		}
+	}
+
+	for _, trace := range traces {
+		if trace.replicated && trace.produces >= 2 {
+			return
+		}
+	}
+
+	t.Fatalf("expected a produce trace to continue through replication")
+}
+
+// setupAgents starts count agents, the first of them the leader and every
+// other joining it, and returns them together with the TLS config their
+// peers and the tests' clients use. configure, if not nil, adjusts each
+// agent's Config before it starts. The agents are shut down and their data
+// removed when the test ends.
+func setupAgents(
+	t *testing.T,
+	count int,
+	configure func(i int, c *Config),
+) ([]*Agent, *tls.Config) {
+	t.Helper()
+
+	serverConfig, err := config.SetupTLSConfig(
+		config.TLSConfig{
+			CertFile:      config.ServerCertFile,
+			KeyFile:       config.ServerKeyFile,
+			CAFile:        config.CAFile,
+			Server:        true,
+			ServerAddress: "127.0.0.1",
+		},
+	)
+	require.NoError(t, err)
+
+	peerConfig, err := config.SetupTLSConfig(
+		config.TLSConfig{
+			CertFile:      config.RootCLientCertFile,
+			KeyFile:       config.RootClientKeyFile,
+			CAFile:        config.CAFile,
+			Server:        false,
+			ServerAddress: "127.0.0.1",
+		},
+	)
+	require.NoError(t, err)
+
+	var agents []*Agent
+
+	t.Cleanup(func() {
+		for _, agent := range agents {
+			require.NoError(t, agent.Shutdown())
+			require.NoError(t, os.RemoveAll(agent.Config.DataDir))
+		}
+	})
+
+	for i := 0; i < count; i++ {
+		ports := dynaport.Get(2)
+
+		dataDir, err := os.MkdirTemp("", "agent-test-log")
+		require.NoError(t, err)
+
+		var startJoinAddrs []string
+
+		if i != 0 {
+			startJoinAddrs = append(startJoinAddrs, agents[0].Config.BindAddr)
+		}
+
+		c := Config{
+			Leader:          i == 0,
+			NodeName:        fmt.Sprintf("%d", i),
+			StartJoinAddrs:  startJoinAddrs,
+			BindAddr:        fmt.Sprintf("127.0.0.1:%d", ports[0]),
+			RPCPort:         ports[1],
+			DataDir:         dataDir,
+			ServerTLSConfig: serverConfig,
+			PeerTLSConfig:   peerConfig,
+			ACLModelFile:    config.ACLModelFile,
+			ACLPolicyFile:   config.ACLPolicyFile,
+		}
+
+		if configure != nil {
+			configure(i, &c)
+		}
+
+		agent, err := New(c)
+		require.NoError(t, err)
+
+		agents = append(agents, agent)
+	}
+
+	return agents, peerConfig
 }
 
 func client(t *testing.T, agent *Agent, tlsConfig *tls.Config) api.LogClient {
@@ -143,3 +578,16 @@ func client(t *testing.T, agent *Agent, tlsConfig *tls.Config) api.LogClient {
 
 	return client
 }
+
+func healthClient(t *testing.T, agent *Agent, tlsConfig *tls.Config) healthpb.HealthClient {
+	rpcAddr, err := agent.Config.RPCAddr()
+	require.NoError(t, err)
+
+	conn, err := grpc.NewClient(
+		rpcAddr,
+		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
+	)
+	require.NoError(t, err)
+
+	return healthpb.NewHealthClient(conn)
+}
@@ -0,0 +1,182 @@
+package agent
+
+import (
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/Gibson-Gichuru/prolog/internal/discovery"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The commands every agent answers through the Command RPC.
+const (
+	// CommandReloadACLs reloads the agent's ACL policy from its file.
+	CommandReloadACLs = "reload-acls"
+	// CommandTruncate removes the segments of the agent's log below the
+	// TruncateRequest's offset.
+	CommandTruncate = "truncate"
+	// CommandStats returns the agent's Stats.
+	CommandStats = "stats"
+)
+
+// TruncateRequest is the payload of CommandTruncate.
+type TruncateRequest struct {
+	// Lowest is the lowest offset to keep. Segments holding only records
+	// below it are removed.
+	Lowest uint64 `json:"lowest"`
+}
+
+// TruncateResponse is an agent's result of CommandTruncate.
+type TruncateResponse struct {
+	LowestOffset uint64 `json:"lowest_offset"`
+}
+
+// Stats is an agent's result of CommandStats.
+type Stats struct {
+	LowestOffset  uint64 `json:"lowest_offset"`
+	HighestOffset uint64 `json:"highest_offset"`
+	Segments      int    `json:"segments"`
+	// ReplicationLag is the number of records the agent has yet to
+	// replicate, across every peer.
+	ReplicationLag uint64 `json:"replication_lag"`
+	Streams        int    `json:"streams"`
+	Draining       bool   `json:"draining"`
+}
+
+// setupCommands registers the agent's commands in a registry for the
+// membership to answer.
+func (a *Agent) setupCommands() {
+	a.registry = &discovery.Registry{}
+
+	discovery.HandleQuery(a.registry, CommandReloadACLs,
+		func(struct{}) (struct{}, error) {
+			return struct{}{}, a.authorizer.Reload()
+		},
+	)
+
+	discovery.HandleQuery(a.registry, CommandTruncate,
+		func(req TruncateRequest) (TruncateResponse, error) {
+			highest, err := a.log.HighestOffset()
+
+			if err != nil {
+				return TruncateResponse{}, err
+			}
+
+			if req.Lowest > highest {
+				return TruncateResponse{}, status.Errorf(
+					codes.InvalidArgument,
+					"offset %d is past the log's highest offset %d",
+					req.Lowest,
+					highest,
+				)
+			}
+
+			if err := a.log.Truncate(req.Lowest); err != nil {
+				return TruncateResponse{}, err
+			}
+
+			lowest, err := a.log.LowestOffset()
+
+			return TruncateResponse{LowestOffset: lowest}, err
+		},
+	)
+
+	discovery.HandleQuery(a.registry, CommandStats,
+		func(struct{}) (Stats, error) {
+			return a.stats()
+		},
+	)
+}
+
+// stats returns the agent's Stats.
+func (a *Agent) stats() (Stats, error) {
+	lowest, err := a.log.LowestOffset()
+
+	if err != nil {
+		return Stats{}, err
+	}
+
+	highest, err := a.log.HighestOffset()
+
+	if err != nil {
+		return Stats{}, err
+	}
+
+	stats := Stats{
+		LowestOffset:  lowest,
+		HighestOffset: highest,
+		Segments:      len(a.log.Segments()),
+		Streams:       a.streams.Len(),
+		Draining:      a.draining.Load(),
+	}
+
+	for _, lag := range a.replicator.Lag() {
+		stats.ReplicationLag += lag
+	}
+
+	return stats, nil
+}
+
+// Command runs the named command on every member of the cluster through a
+// Serf query, returning their results by member name. Without Serf
+// discovery the command only runs on the agent itself.
+func (a *Agent) Command(
+	name string,
+	payload []byte,
+	timeout time.Duration,
+) (*api.CommandResponse, error) {
+	if a.membership == nil {
+		return nil, status.Error(codes.Unavailable, "membership not set up")
+	}
+
+	membership, ok := a.membership.(*discovery.MemberShip)
+
+	if !ok {
+		res, err := a.registry.Query(name, payload)
+
+		return &api.CommandResponse{
+			NumNodes: 1,
+			Results: map[string]*api.CommandResult{
+				a.Config.NodeName: commandResult(discovery.QueryResult{
+					Payload: res,
+					Error:   errorString(err),
+				}),
+			},
+		}, nil
+	}
+
+	results, n, err := membership.Query(name, payload, timeout)
+
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	res := &api.CommandResponse{
+		NumNodes: int32(n),
+		Results:  make(map[string]*api.CommandResult, len(results)),
+	}
+
+	for node, result := range results {
+		res.Results[node] = commandResult(result)
+	}
+
+	return res, nil
+}
+
+// commandResult converts a member's answer to a command's query.
+func commandResult(result discovery.QueryResult) *api.CommandResult {
+	return &api.CommandResult{
+		Payload: result.Payload,
+		Error:   result.Error,
+	}
+}
+
+// errorString returns err's message, or an empty string if it is nil.
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
+}
@@ -0,0 +1,55 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCommandTruncate tests that truncating past the end of the log is
+// refused, and that the agent still answers commands afterwards.
+func TestCommandTruncate(t *testing.T) {
+	agents, peerConfig := setupAgents(t, 1, nil)
+	leader := client(t, agents[0], peerConfig)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, err := leader.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err)
+	}
+
+	payload, err := json.Marshal(TruncateRequest{Lowest: 5})
+	require.NoError(t, err)
+
+	res, err := leader.Command(ctx, &api.CommandRequest{
+		Name:           CommandTruncate,
+		Payload:        payload,
+		TimeoutSeconds: 3,
+	})
+	require.NoError(t, err)
+	require.Len(t, res.Results, 1)
+
+	for _, result := range res.Results {
+		require.Contains(t, result.Error, "past the log's highest offset")
+	}
+
+	res, err = leader.Command(ctx, &api.CommandRequest{
+		Name:           CommandStats,
+		TimeoutSeconds: 3,
+	})
+	require.NoError(t, err)
+	require.Len(t, res.Results, 1)
+
+	for _, result := range res.Results {
+		require.Empty(t, result.Error)
+
+		var stats Stats
+		require.NoError(t, json.Unmarshal(result.Payload, &stats))
+		require.Equal(t, uint64(2), stats.HighestOffset)
+	}
+}
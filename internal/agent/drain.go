@@ -0,0 +1,228 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/Gibson-Gichuru/prolog/internal/discovery"
+	"go.uber.org/zap"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// DefaultDrainTimeout is how long a drain waits for the agent's streams to
+// finish when neither the agent's config nor the Drain RPC set a timeout.
+const DefaultDrainTimeout = 30 * time.Second
+
+// handOffPollInterval is how often a draining leader checks whether its
+// successor has taken leadership.
+var handOffPollInterval = 100 * time.Millisecond
+
+// Draining reports whether the agent is draining, and the RPC address of a
+// server to send produces to instead: the leader if it is not the agent,
+// otherwise any server that is not draining.
+func (a *Agent) Draining() (string, bool) {
+	if !a.draining.Load() {
+		return "", false
+	}
+
+	servers, err := a.GetServers()
+
+	if err != nil {
+		return "", true
+	}
+
+	var redirect string
+
+	for _, server := range servers {
+		if server.Id == a.Config.NodeName ||
+			server.Tags[discovery.TagDraining] == "true" {
+			continue
+		}
+
+		if server.IsLeader {
+			return server.RpcAddr, true
+		}
+
+		if redirect == "" {
+			redirect = server.RpcAddr
+		}
+	}
+
+	return redirect, true
+}
+
+// Drain drains the agent before it is decommissioned. It reports the agent
+// as not serving, stops replicating and accepting produces, and marks itself
+// with the draining tag so that clients stop picking it. A leader hands its
+// leadership to another server that is not draining, waiting up to timeout,
+// or the config's DrainTimeout if it is zero, for it to take over. It then
+// waits up to timeout again for the agent's streams to finish, cancels those
+// that have not, and leaves the cluster.
+// The agent keeps serving the remaining requests until it is shut down.
+// Only the first call drains the agent; later calls wait for it to finish
+// and return the same result.
+func (a *Agent) Drain(timeout time.Duration) (*api.DrainResponse, error) {
+	a.drainOnce.Do(func() {
+		a.drained, a.drainErr = a.drain(timeout)
+	})
+
+	return a.drained, a.drainErr
+}
+
+func (a *Agent) drain(timeout time.Duration) (*api.DrainResponse, error) {
+	if timeout <= 0 {
+		timeout = a.Config.DrainTimeout
+	}
+
+	if timeout <= 0 {
+		timeout = DefaultDrainTimeout
+	}
+
+	a.draining.Store(true)
+	a.setServingStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+
+	if err := a.replicator.Close(); err != nil {
+		return nil, err
+	}
+
+	res := &api.DrainResponse{}
+
+	// Only gossiped membership can advertise the drain and hand off
+	// leadership; static and DNS clusters are configured by their operators.
+	if membership, ok := a.membership.(*discovery.MemberShip); ok {
+		if err := membership.SetTag(discovery.TagDraining, "true"); err != nil {
+			return nil, err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		successor, err := a.handOff(ctx, membership)
+		cancel()
+
+		if err != nil {
+			return nil, err
+		}
+
+		res.Successor = successor
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := a.streams.Wait(ctx); err != nil {
+		res.CancelledStreams = uint32(a.streams.Cancel())
+	}
+
+	if err := a.membership.Leave(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// handOff hands the agent's leadership, if it leads, to the first server by
+// name that is not draining, and waits until ctx is done for the server to
+// advertise it. It returns the successor's name once it has, or an empty
+// name if the agent does not lead, no server can take over, or the successor
+// did not advertise leadership in time, leaving the cluster without a leader.
+func (a *Agent) handOff(
+	ctx context.Context,
+	membership *discovery.MemberShip,
+) (string, error) {
+	servers, err := membership.GetServers()
+
+	if err != nil {
+		return "", err
+	}
+
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].Id < servers[j].Id
+	})
+
+	var leader bool
+	var successor string
+
+	for _, server := range servers {
+		if server.Id == a.Config.NodeName {
+			leader = server.IsLeader
+			continue
+		}
+
+		if successor == "" && server.Tags[discovery.TagDraining] != "true" {
+			successor = server.Id
+		}
+	}
+
+	if !leader {
+		return "", nil
+	}
+
+	logger := zap.L().Named("drain")
+
+	if successor == "" {
+		logger.Warn("no server to hand leadership to")
+
+		return "", membership.SetTag("leader", "")
+	}
+
+	if err := membership.HandOff(successor); err != nil {
+		return "", err
+	}
+
+	ticker := time.NewTicker(handOffPollInterval)
+	defer ticker.Stop()
+
+	for {
+		servers, err := membership.GetServers()
+
+		if err != nil {
+			return "", err
+		}
+
+		for _, server := range servers {
+			if server.Id == successor && server.IsLeader {
+				return successor, nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.Warn(
+				"successor did not take leadership",
+				zap.String("successor", successor),
+			)
+
+			return "", nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// drainOnSignal drains and shuts down the agent when it receives one of the
+// config's DrainSignals, unless it is shut down first.
+func (a *Agent) drainOnSignal() {
+	signals := make(chan os.Signal, 1)
+
+	signal.Notify(signals, a.Config.DrainSignals...)
+	defer signal.Stop(signals)
+
+	logger := zap.L().Named("drain")
+
+	select {
+	case <-a.shutdowns:
+		return
+	case sig := <-signals:
+		logger.Info("draining", zap.Stringer("signal", sig))
+	}
+
+	if _, err := a.Drain(0); err != nil {
+		logger.Error("failed to drain", zap.Error(err))
+	}
+
+	if err := a.Shutdown(); err != nil {
+		logger.Error("failed to shut down", zap.Error(err))
+	}
+}
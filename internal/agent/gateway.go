@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/Gibson-Gichuru/prolog/internal/server"
+	"go.uber.org/zap"
+)
+
+// setupGateway serves the log's HTTP/JSON gateway at the GatewayAddr in the
+// agent's Config, backed by the same server config as the gRPC server. When
+// the agent has a ServerTLSConfig the gateway is served over TLS with it, so
+// clients are authorized by the subject of their certificate. Requests are
+// served with a context that is canceled when the agent shuts down, which ends
+// streaming requests, including WebSockets that the server no longer tracks.
+// It does nothing if GatewayAddr is empty.
+func (a *Agent) setupGateway(config *server.Config) error {
+	if a.Config.GatewayAddr == "" {
+		return nil
+	}
+
+	handler, err := server.NewHTTPHandler(config)
+
+	if err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", a.Config.GatewayAddr)
+
+	if err != nil {
+		return err
+	}
+
+	if a.Config.ServerTLSConfig != nil {
+		ln = tls.NewListener(ln, a.Config.ServerTLSConfig)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		<-a.shutdowns
+		cancel()
+	}()
+
+	a.gateway = &http.Server{
+		Handler: handler,
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+
+	go func() {
+		err := a.gateway.Serve(ln)
+
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			zap.L().Named("agent").Error(
+				"gateway failed",
+				zap.Error(err),
+			)
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"errors"
+	"net"
+	"net/http"
+
+	"go.uber.org/zap"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// setupHTTP serves the agent's operational endpoints over HTTP at the
+// MetricsAddr in the agent's Config: Prometheus metrics on /metrics, liveness
+// on /healthz, and readiness on /readyz. It does nothing if MetricsAddr is
+// empty.
+func (a *Agent) setupHTTP() error {
+	if a.Config.MetricsAddr == "" {
+		return nil
+	}
+
+	metrics, err := a.metricsHandler()
+
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	mux.HandleFunc("/healthz", a.handleHealthz)
+	mux.HandleFunc("/readyz", a.handleReadyz)
+
+	ln, err := net.Listen("tcp", a.Config.MetricsAddr)
+
+	if err != nil {
+		return err
+	}
+
+	a.httpServer = &http.Server{Handler: mux}
+
+	go func() {
+		err := a.httpServer.Serve(ln)
+
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			zap.L().Named("agent").Error(
+				"http server failed",
+				zap.Error(err),
+			)
+		}
+	}()
+
+	return nil
+}
+
+// handleHealthz reports that the agent's process is alive. It responds with
+// 200 OK for as long as the HTTP server is running.
+func (a *Agent) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// handleReadyz reports whether the agent is ready to serve requests. It
+// mirrors the status of the agent's gRPC health service, responding with 200
+// OK while it is SERVING and 503 Service Unavailable otherwise.
+func (a *Agent) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	res, err := a.health.Check(
+		r.Context(),
+		&healthpb.HealthCheckRequest{},
+	)
+
+	if err != nil || res.Status != healthpb.HealthCheckResponse_SERVING {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("not ready\n"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ready\n"))
+}
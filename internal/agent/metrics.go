@@ -0,0 +1,156 @@
+package agent
+
+import (
+	"net/http"
+
+	ocprom "contrib.go.opencensus.io/exporter/prometheus"
+	"github.com/Gibson-Gichuru/prolog/internal/discovery"
+	"github.com/Gibson-Gichuru/prolog/internal/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opencensus.io/stats/view"
+)
+
+const metricsNamespace = "prolog"
+
+type collector struct {
+	agent    *Agent
+	segments *prometheus.Desc
+	bytes    *prometheus.Desc
+	lowest   *prometheus.Desc
+	highest  *prometheus.Desc
+	lag      *prometheus.Desc
+	members  *prometheus.Desc
+}
+
+var _ prometheus.Collector = (*collector)(nil)
+
+// metricsHandler returns a handler serving the agent's metrics in the
+// Prometheus text format. It exports the OpenCensus views registered by the
+// gRPC server and the log, along with gauges for the log's segments, offsets
+// and size, the replication lag of each peer, and the number of cluster
+// members by status.
+func (a *Agent) metricsHandler() (http.Handler, error) {
+	if err := view.Register(log.AppendLatencyView); err != nil {
+		return nil, err
+	}
+
+	registry := prometheus.NewRegistry()
+
+	if err := registry.Register(newCollector(a)); err != nil {
+		return nil, err
+	}
+
+	return ocprom.NewExporter(ocprom.Options{
+		Namespace: metricsNamespace,
+		Registry:  registry,
+	})
+}
+
+// newCollector returns a collector reporting the state of the agent's log,
+// replicator and membership each time metrics are scraped.
+func newCollector(a *Agent) *collector {
+	name := func(subsystem, name string) string {
+		return prometheus.BuildFQName(metricsNamespace, subsystem, name)
+	}
+
+	return &collector{
+		agent: a,
+		segments: prometheus.NewDesc(
+			name("log", "segments"),
+			"Number of segments in the log.",
+			nil, nil,
+		),
+		bytes: prometheus.NewDesc(
+			name("log", "bytes"),
+			"Bytes used on disk by the log's stores and indexes.",
+			nil, nil,
+		),
+		lowest: prometheus.NewDesc(
+			name("log", "lowest_offset"),
+			"Lowest offset in the log.",
+			nil, nil,
+		),
+		highest: prometheus.NewDesc(
+			name("log", "highest_offset"),
+			"Highest offset in the log.",
+			nil, nil,
+		),
+		lag: prometheus.NewDesc(
+			name("replication", "lag_records"),
+			"Records held by a peer that have not been replicated locally.",
+			[]string{"peer"}, nil,
+		),
+		members: prometheus.NewDesc(
+			name("cluster", "members"),
+			"Number of cluster members by status.",
+			[]string{"status"}, nil,
+		),
+	}
+}
+
+// Describe sends the descriptors of every metric the collector reports.
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.segments
+	ch <- c.bytes
+	ch <- c.lowest
+	ch <- c.highest
+	ch <- c.lag
+	ch <- c.members
+}
+
+// Collect reads the current state of the agent's log, replicator and
+// membership and sends it as gauge metrics.
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	segments := c.agent.log.Segments()
+
+	var bytes uint64
+
+	for _, s := range segments {
+		bytes += s.StoreBytes + s.IndexBytes
+	}
+
+	gauge := func(desc *prometheus.Desc, value float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(
+			desc,
+			prometheus.GaugeValue,
+			value,
+			labels...,
+		)
+	}
+
+	gauge(c.segments, float64(len(segments)))
+	gauge(c.bytes, float64(bytes))
+
+	if lowest, err := c.agent.log.LowestOffset(); err == nil {
+		gauge(c.lowest, float64(lowest))
+	}
+
+	if highest, err := c.agent.log.HighestOffset(); err == nil {
+		gauge(c.highest, float64(highest))
+	}
+
+	if c.agent.replicator != nil {
+		for peer, lag := range c.agent.replicator.Lag() {
+			gauge(c.lag, float64(lag), peer)
+		}
+	}
+
+	switch membership := c.agent.membership.(type) {
+	case *discovery.MemberShip:
+		statuses := make(map[string]int)
+
+		for _, member := range membership.Members() {
+			statuses[member.Status.String()]++
+		}
+
+		for status, count := range statuses {
+			gauge(c.members, float64(count), status)
+		}
+	case nil:
+	default:
+		// Other providers only know of the servers they consider alive.
+		if servers, err := membership.GetServers(); err == nil {
+			gauge(c.members, float64(len(servers)), "alive")
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package agent
+
+import (
+	"math"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+)
+
+// Staleness reports how far behind the leader's log the agent's log is,
+// and the leader's RPC address, for bounding the staleness of the reads it
+// serves. The leader, and an agent that does not know of one, are never
+// stale, as there is nothing newer to redirect reads to. An agent not
+// replicating from the leader, such as one that has not started to or has
+// been drained, is as stale as can be.
+func (a *Agent) Staleness() (time.Duration, string) {
+	leader, ok := a.leader()
+
+	if !ok || leader.Id == a.Config.NodeName {
+		return 0, ""
+	}
+
+	if a.replicator == nil {
+		return math.MaxInt64, leader.RpcAddr
+	}
+
+	return a.replicator.Staleness(leader.Id), leader.RpcAddr
+}
+
+// Replicated returns how far the agent has replicated each of its peers'
+// logs.
+func (a *Agent) Replicated() map[string]uint64 {
+	if a.replicator == nil {
+		return nil
+	}
+
+	return a.replicator.Replicated()
+}
+
+// LeaderReplicated returns the offset after the last record of the leader's
+// log the agent has replicated, or 0 if it does not replicate the leader.
+func (a *Agent) LeaderReplicated() uint64 {
+	leader, ok := a.leader()
+
+	if !ok {
+		return 0
+	}
+
+	return a.Replicated()[leader.Id]
+}
+
+// leader returns the cluster's leader, if the agent knows of one.
+func (a *Agent) leader() (*api.Server, bool) {
+	servers, err := a.GetServers()
+
+	if err != nil {
+		return nil, false
+	}
+
+	for _, server := range servers {
+		if server.IsLeader {
+			return server, true
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,59 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+)
+
+// TestMinOffset tests that a follower replicating two producers' logs
+// serves reads bounded by a minimum offset of the leader's log only once it
+// has replicated that offset from the leader, however many records of the
+// other producer it holds.
+func TestMinOffset(t *testing.T) {
+	agents, peerConfig := setupAgents(t, 3, nil)
+	leader, producer, follower := agents[0], agents[1], agents[2]
+
+	// Only the follower replicates, so that the leader's and the
+	// producer's logs hold just the records produced to them.
+	require.NoError(t, leader.replicator.Close())
+	require.NoError(t, producer.replicator.Close())
+
+	ctx := context.Background()
+
+	for agent, records := range map[*Agent]int{leader: 2, producer: 10} {
+		client := client(t, agent, peerConfig)
+
+		for i := 0; i < records; i++ {
+			_, err := client.Produce(ctx, &api.ProduceRequest{
+				Record: &api.Record{Value: []byte("hello world")},
+			})
+			require.NoError(t, err)
+		}
+	}
+
+	require.Eventually(t, func() bool {
+		replicated := follower.Replicated()
+
+		return replicated[leader.Config.NodeName] == 2 &&
+			replicated[producer.Config.NodeName] == 10
+	}, 10*time.Second, 100*time.Millisecond)
+
+	followerClient := client(t, follower, peerConfig)
+
+	_, err := followerClient.Consume(ctx, &api.ConsumeRequest{MinOffset: 1})
+	require.NoError(t, err)
+
+	// The follower's log holds an offset 5, but not the leader's record at
+	// offset 5, which has not been produced yet.
+	_, err = followerClient.Consume(ctx, &api.ConsumeRequest{MinOffset: 5})
+
+	st := status.Convert(err)
+	require.Len(t, st.Details(), 1)
+	require.Equal(t, "STALE_READ", st.Details()[0].(*errdetails.ErrorInfo).Reason)
+}
@@ -0,0 +1,82 @@
+package audit
+
+import (
+	"encoding/json"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"go.uber.org/zap"
+)
+
+// Filter selects the authorization decisions a Logger records.
+type Filter int
+
+const (
+	// FilterDenied records only denied requests.
+	FilterDenied Filter = iota
+	// FilterAll records every decision.
+	FilterAll
+)
+
+// Event is an authorization decision.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Subject string    `json:"subject"`
+	Object  string    `json:"object"`
+	Action  string    `json:"action"`
+	Allowed bool      `json:"allowed"`
+	// Peer is the address the request came from.
+	Peer string `json:"peer,omitempty"`
+	// Method is the gRPC method, such as /log.v1.Log/Produce, or the
+	// gateway route, such as POST /v1/records, of the request.
+	Method string `json:"method,omitempty"`
+}
+
+// Sink stores audit records. A *log.Log is a Sink.
+type Sink interface {
+	Append(*api.Record) (uint64, error)
+}
+
+// Logger writes authorization decisions to a Sink as records holding the
+// JSON encoded Event.
+type Logger struct {
+	sink   Sink
+	filter Filter
+	logger *zap.Logger
+}
+
+// New returns a Logger writing the decisions selected by filter to sink.
+func New(sink Sink, filter Filter) *Logger {
+	return &Logger{
+		sink:   sink,
+		filter: filter,
+		logger: zap.L().Named("audit"),
+	}
+}
+
+// Audit records the event if the Logger's filter selects it. The request
+// is not failed if the event cannot be recorded, so errors are logged
+// instead.
+func (l *Logger) Audit(event Event) {
+	if event.Allowed && l.filter != FilterAll {
+		return
+	}
+
+	value, err := json.Marshal(event)
+
+	if err != nil {
+		l.logger.Error("failed to encode audit event", zap.Error(err))
+		return
+	}
+
+	if _, err := l.sink.Append(&api.Record{Value: value}); err != nil {
+		l.logger.Error(
+			"failed to record audit event",
+			zap.Error(err),
+			zap.String("subject", event.Subject),
+			zap.String("object", event.Object),
+			zap.String("action", event.Action),
+			zap.Bool("allowed", event.Allowed),
+		)
+	}
+}
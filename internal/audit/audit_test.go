@@ -0,0 +1,57 @@
+package audit
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Gibson-Gichuru/prolog/internal/log"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLogger tests that the filter selects the decisions written to the
+// log, and that they are written as JSON encoded events.
+func TestLogger(t *testing.T) {
+	allowed := Event{
+		Time:    time.Now().UTC().Truncate(time.Second),
+		Subject: "root",
+		Object:  "topics/default",
+		Action:  "produce",
+		Allowed: true,
+		Peer:    "127.0.0.1:5000",
+		Method:  "/log.v1.Log/Produce",
+	}
+
+	denied := allowed
+	denied.Subject = "nobody"
+	denied.Allowed = false
+
+	for filter, want := range map[Filter][]Event{
+		FilterDenied: {denied},
+		FilterAll:    {allowed, denied},
+	} {
+		l, err := log.NewLog(t.TempDir(), log.Config{})
+		require.NoError(t, err)
+
+		logger := New(l, filter)
+		logger.Audit(allowed)
+		logger.Audit(denied)
+
+		var got []Event
+
+		for off := uint64(0); ; off++ {
+			record, err := l.Read(off)
+
+			if err != nil {
+				break
+			}
+
+			var event Event
+			require.NoError(t, json.Unmarshal(record.Value, &event))
+			got = append(got, event)
+		}
+
+		require.Equal(t, want, got)
+		require.NoError(t, l.Close())
+	}
+}
@@ -2,36 +2,66 @@ package auth
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
 	"github.com/casbin/casbin/v2"
+	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+// ReloadInterval is how often a watching Authorizer checks its policy file
+// for changes.
+var ReloadInterval = 5 * time.Second
+
 type Authorizer struct {
+	model  string
+	policy string
+	logger *zap.Logger
+
+	mu       sync.RWMutex
 	enforcer *casbin.Enforcer
-}
+	// modTime and size describe the policy file as of the last load or
+	// save, so that the watcher only reloads it after it changes.
+	modTime time.Time
+	size    int64
 
-// New creates a new Authorizer instance with a casbin enforcer
-// initialized using the provided model and policy files. It returns
-// a pointer to the created Authorizer.
-func New(model, policy string) *Authorizer {
-	enforcer, err := casbin.NewEnforcer(model, policy)
+	close     chan struct{}
+	closeOnce sync.Once
+}
 
-	if err != nil {
-		panic(err)
+// New creates a new Authorizer with a casbin enforcer initialized from the
+// provided model and policy files. It returns an error if either file cannot
+// be loaded.
+func New(model, policy string) (*Authorizer, error) {
+	a := &Authorizer{
+		model:  model,
+		policy: policy,
+		logger: zap.L().Named("auth"),
+		close:  make(chan struct{}),
 	}
-	return &Authorizer{
-		enforcer: enforcer,
+
+	if err := a.Reload(); err != nil {
+		return nil, err
 	}
+
+	return a, nil
 }
 
 // Authorize checks if the given subject has the given permission to
 // perform the given action on the given object. It returns an error if
 // the subject does not have the specified permission.
 func (a *Authorizer) Authorize(subject, object, action string) error {
+	a.mu.RLock()
+	ok, err := a.enforcer.Enforce(subject, object, action)
+	a.mu.RUnlock()
 
-	if ok, err := a.enforcer.Enforce(subject, object, action); err != nil || !ok {
+	if err != nil || !ok {
 		msg := fmt.Sprintf(
 			"%s not permitted to %s to %s",
 			subject,
@@ -46,3 +76,318 @@ func (a *Authorizer) Authorize(subject, object, action string) error {
 
 	return nil
 }
+
+// Reload loads the model and policy files into a new enforcer and swaps it
+// in, so requests are authorized against either the old or the new policy in
+// full. If the files cannot be loaded the current policy is kept and the
+// error is returned.
+func (a *Authorizer) Reload() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	info, err := os.Stat(a.policy)
+
+	if err != nil {
+		return err
+	}
+
+	enforcer, err := casbin.NewEnforcer(a.model, a.policy)
+
+	if err != nil {
+		return fmt.Errorf("load policy: %w", err)
+	}
+
+	a.enforcer = enforcer
+	a.modTime = info.ModTime()
+	a.size = info.Size()
+
+	return nil
+}
+
+// Watch reloads the policy whenever its file changes, checking every
+// ReloadInterval until the Authorizer is closed. Reload errors are logged
+// and the current policy is kept.
+func (a *Authorizer) Watch() {
+	go func() {
+		ticker := time.NewTicker(ReloadInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-a.close:
+				return
+			case <-ticker.C:
+			}
+
+			if !a.changed() {
+				continue
+			}
+
+			if err := a.Reload(); err != nil {
+				a.logger.Error(
+					"failed to reload policy",
+					zap.String("policy", a.policy),
+					zap.Error(err),
+				)
+				continue
+			}
+
+			a.logger.Info("reloaded policy", zap.String("policy", a.policy))
+		}
+	}()
+}
+
+// Close stops watching the policy file.
+func (a *Authorizer) Close() error {
+	a.closeOnce.Do(func() {
+		close(a.close)
+	})
+
+	return nil
+}
+
+// Policies returns the policies currently enforced, including the roles
+// assigned to subjects.
+func (a *Authorizer) Policies() ([]*api.Policy, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	rules, err := a.enforcer.GetPolicy()
+
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := a.enforcer.GetGroupingPolicy()
+
+	if err != nil {
+		return nil, err
+	}
+
+	policies := make([]*api.Policy, 0, len(rules)+len(roles))
+
+	for _, rule := range rules {
+		if len(rule) != 3 {
+			continue
+		}
+
+		policies = append(policies, &api.Policy{
+			Subject: rule[0],
+			Object:  rule[1],
+			Action:  rule[2],
+		})
+	}
+
+	for _, role := range roles {
+		if len(role) != 2 {
+			continue
+		}
+
+		policies = append(policies, &api.Policy{
+			Subject: role[0],
+			Role:    role[1],
+		})
+	}
+
+	return policies, nil
+}
+
+// AddPolicy allows subject to perform action on object and saves the policy
+// file. It returns false if the policy already existed.
+func (a *Authorizer) AddPolicy(subject, object, action string) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	added, err := a.enforcer.AddPolicy(subject, object, action)
+
+	if err != nil || !added {
+		return false, err
+	}
+
+	if err = a.save(); err != nil {
+		_, _ = a.enforcer.RemovePolicy(subject, object, action)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// RemovePolicy stops allowing subject to perform action on object and saves
+// the policy file. It returns false if there was no such policy.
+func (a *Authorizer) RemovePolicy(subject, object, action string) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	removed, err := a.enforcer.RemovePolicy(subject, object, action)
+
+	if err != nil || !removed {
+		return false, err
+	}
+
+	if err = a.save(); err != nil {
+		_, _ = a.enforcer.AddPolicy(subject, object, action)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// AddRole grants subject the role's policies and saves the policy file. It
+// returns false if the subject already had the role.
+func (a *Authorizer) AddRole(subject, role string) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	added, err := a.enforcer.AddGroupingPolicy(subject, role)
+
+	if err != nil || !added {
+		return false, err
+	}
+
+	if err = a.save(); err != nil {
+		_, _ = a.enforcer.RemoveGroupingPolicy(subject, role)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// RemoveRole takes the role away from subject and saves the policy file. It
+// returns false if the subject did not have the role.
+func (a *Authorizer) RemoveRole(subject, role string) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	removed, err := a.enforcer.RemoveGroupingPolicy(subject, role)
+
+	if err != nil || !removed {
+		return false, err
+	}
+
+	if err = a.save(); err != nil {
+		_, _ = a.enforcer.AddGroupingPolicy(subject, role)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// save writes the enforcer's policy to the policy file in casbin's CSV
+// format. The file's comments, blank lines and remaining rules are kept in
+// place, removed rules are dropped and new rules are appended. The file is
+// replaced atomically so that a concurrent reload never sees it half written.
+// It must be called with the lock held.
+func (a *Authorizer) save() error {
+	var rules []string
+
+	enforced := make(map[string]bool)
+	model := a.enforcer.GetModel()
+
+	for _, sec := range []string{"p", "g"} {
+		for ptype, ast := range model[sec] {
+			for _, rule := range ast.Policy {
+				line := ruleLine(append([]string{ptype}, rule...))
+
+				if !enforced[line] {
+					enforced[line] = true
+					rules = append(rules, line)
+				}
+			}
+		}
+	}
+
+	current, err := os.ReadFile(a.policy)
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var b strings.Builder
+
+	written := make(map[string]bool)
+
+	for _, line := range strings.Split(strings.TrimRight(string(current), "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			rule := ruleLine(strings.Split(trimmed, ","))
+
+			if !enforced[rule] || written[rule] {
+				continue
+			}
+
+			written[rule] = true
+		}
+
+		if len(current) > 0 {
+			fmt.Fprintln(&b, line)
+		}
+	}
+
+	for _, rule := range rules {
+		if !written[rule] {
+			fmt.Fprintln(&b, rule)
+		}
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(a.policy), ".policy-*.csv")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(f.Name())
+
+	if _, err = f.WriteString(b.String()); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	if info, err := os.Stat(a.policy); err == nil {
+		_ = os.Chmod(f.Name(), info.Mode())
+	}
+
+	if err = os.Rename(f.Name(), a.policy); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(a.policy)
+
+	if err != nil {
+		return err
+	}
+
+	a.modTime = info.ModTime()
+	a.size = info.Size()
+
+	return nil
+}
+
+// ruleLine formats the fields of a rule, starting with its policy type, as a
+// line of the policy file.
+func ruleLine(fields []string) string {
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+
+	return strings.Join(fields, ", ")
+}
+
+// changed reports whether the policy file differs from when it was last
+// loaded or saved.
+func (a *Authorizer) changed() bool {
+	info, err := os.Stat(a.policy)
+
+	if err != nil {
+		return false
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return !info.ModTime().Equal(a.modTime) || info.Size() != a.size
+}
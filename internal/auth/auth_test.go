@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// modelFile is the repository's Casbin model, so the tests cover the model the
+// agents are deployed with.
+var modelFile = filepath.Join("..", "..", "utils", "model.conf")
+
+// TestAuthorizer runs a series of test scenarios against an Authorizer
+// loaded from the repository's model and a temporary policy file.
+func TestAuthorizer(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		t *testing.T,
+		authorizer *Authorizer,
+		policy string,
+	){
+		"authorize follows the policy":         testAuthorize,
+		"added and removed policies are saved": testAddRemovePolicy,
+		"policy file changes are reloaded":     testWatch,
+		"invalid policy keeps the current one": testReloadInvalid,
+		"roles and resource patterns":          testRoles,
+		"added and removed roles are saved":    testAddRemoveRole,
+		"saving keeps the file's comments":     testSaveComments,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			authorizer, policy := setupTest(t)
+			defer authorizer.Close()
+			fn(t, authorizer, policy)
+		})
+	}
+}
+
+// TestNewMissingFile tests that New returns an error rather than panicking
+// when the policy cannot be loaded.
+func TestNewMissingFile(t *testing.T) {
+	_, err := New(modelFile, filepath.Join(t.TempDir(), "policy.csv"))
+	require.Error(t, err)
+}
+
+// setupTest writes a policy allowing root to produce to a temporary
+// directory and returns an Authorizer for it along with the path of the
+// policy file.
+func setupTest(t *testing.T) (*Authorizer, string) {
+	t.Helper()
+
+	policy := filepath.Join(t.TempDir(), "policy.csv")
+
+	require.NoError(t, os.WriteFile(policy, []byte("p, root, *, produce"), 0644))
+
+	authorizer, err := New(modelFile, policy)
+	require.NoError(t, err)
+
+	return authorizer, policy
+}
+
+func testAuthorize(t *testing.T, authorizer *Authorizer, _ string) {
+	require.NoError(t, authorizer.Authorize("root", "*", "produce"))
+
+	err := authorizer.Authorize("root", "*", "consume")
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func testAddRemovePolicy(t *testing.T, authorizer *Authorizer, policy string) {
+	added, err := authorizer.AddPolicy("root", "*", "consume")
+	require.NoError(t, err)
+	require.True(t, added)
+
+	added, err = authorizer.AddPolicy("root", "*", "consume")
+	require.NoError(t, err)
+	require.False(t, added)
+
+	require.NoError(t, authorizer.Authorize("root", "*", "consume"))
+
+	// The saved file holds the new policy.
+	saved, err := New(modelFile, policy)
+	require.NoError(t, err)
+	require.NoError(t, saved.Authorize("root", "*", "consume"))
+
+	policies, err := authorizer.Policies()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []*api.Policy{
+		{Subject: "root", Object: "*", Action: "produce"},
+		{Subject: "root", Object: "*", Action: "consume"},
+	}, policies)
+
+	removed, err := authorizer.RemovePolicy("root", "*", "produce")
+	require.NoError(t, err)
+	require.True(t, removed)
+
+	removed, err = authorizer.RemovePolicy("root", "*", "produce")
+	require.NoError(t, err)
+	require.False(t, removed)
+
+	require.Error(t, authorizer.Authorize("root", "*", "produce"))
+
+	saved, err = New(modelFile, policy)
+	require.NoError(t, err)
+	require.Error(t, saved.Authorize("root", "*", "produce"))
+}
+
+func testWatch(t *testing.T, authorizer *Authorizer, policy string) {
+	interval := ReloadInterval
+	ReloadInterval = 10 * time.Millisecond
+	defer func() { ReloadInterval = interval }()
+
+	authorizer.Watch()
+
+	require.NoError(t, os.WriteFile(
+		policy,
+		[]byte("p, root, *, produce\np, nobody, *, consume"),
+		0644,
+	))
+
+	require.Eventually(t, func() bool {
+		return authorizer.Authorize("nobody", "*", "consume") == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func testReloadInvalid(t *testing.T, authorizer *Authorizer, policy string) {
+	require.NoError(t, os.Remove(policy))
+	require.Error(t, authorizer.Reload())
+
+	require.NoError(t, authorizer.Authorize("root", "*", "produce"))
+}
+
+func testRoles(t *testing.T, _ *Authorizer, policy string) {
+	require.NoError(t, os.WriteFile(policy, []byte(`p, admin, *, admin
+p, producer, topics/orders-*, produce
+p, consumer, topics/orders-?, consume
+p, consumer, cluster, describe
+g, alice, producer
+g, bob, consumer
+g, carol, admin
+g, dave, producer
+g, dave, consumer`), 0644))
+
+	authorizer, err := New(modelFile, policy)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		subject, object, action string
+		allowed                 bool
+	}{
+		{"alice", "topics/orders-eu", "produce", true},
+		{"alice", "topics/orders-eu/archive", "produce", true},
+		{"alice", "topics/payments", "produce", false},
+		{"alice", "topics/orders-eu", "consume", false},
+		{"bob", "topics/orders-1", "consume", true},
+		{"bob", "topics/orders-eu", "consume", false},
+		{"bob", "cluster", "describe", true},
+		{"bob", "topics/orders-1", "produce", false},
+		{"carol", "topics/payments", "produce", true},
+		{"carol", "acls", "admin", true},
+		{"dave", "topics/orders-1", "produce", true},
+		{"dave", "topics/orders-1", "consume", true},
+		{"dave", "acls", "admin", false},
+		{"mallory", "topics/orders-1", "consume", false},
+	} {
+		err := authorizer.Authorize(tc.subject, tc.object, tc.action)
+
+		if tc.allowed {
+			require.NoError(t, err, "%s %s %s", tc.subject, tc.action, tc.object)
+		} else {
+			require.Error(t, err, "%s %s %s", tc.subject, tc.action, tc.object)
+		}
+	}
+}
+
+func testAddRemoveRole(t *testing.T, authorizer *Authorizer, policy string) {
+	added, err := authorizer.AddPolicy("consumer", "*", "consume")
+	require.NoError(t, err)
+	require.True(t, added)
+
+	added, err = authorizer.AddRole("alice", "consumer")
+	require.NoError(t, err)
+	require.True(t, added)
+
+	added, err = authorizer.AddRole("alice", "consumer")
+	require.NoError(t, err)
+	require.False(t, added)
+
+	require.NoError(t, authorizer.Authorize("alice", "*", "consume"))
+
+	policies, err := authorizer.Policies()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []*api.Policy{
+		{Subject: "root", Object: "*", Action: "produce"},
+		{Subject: "consumer", Object: "*", Action: "consume"},
+		{Subject: "alice", Role: "consumer"},
+	}, policies)
+
+	saved, err := New(modelFile, policy)
+	require.NoError(t, err)
+	require.NoError(t, saved.Authorize("alice", "*", "consume"))
+
+	removed, err := authorizer.RemoveRole("alice", "consumer")
+	require.NoError(t, err)
+	require.True(t, removed)
+
+	removed, err = authorizer.RemoveRole("alice", "consumer")
+	require.NoError(t, err)
+	require.False(t, removed)
+
+	require.Error(t, authorizer.Authorize("alice", "*", "consume"))
+
+	saved, err = New(modelFile, policy)
+	require.NoError(t, err)
+	require.Error(t, saved.Authorize("alice", "*", "consume"))
+}
+
+func testSaveComments(t *testing.T, _ *Authorizer, policy string) {
+	require.NoError(t, os.WriteFile(policy, []byte(`# admins may do anything
+p, admin, *, admin
+
+# producers
+p, producer, topics/*, produce
+g, root, admin
+`), 0644))
+
+	authorizer, err := New(modelFile, policy)
+	require.NoError(t, err)
+
+	_, err = authorizer.RemovePolicy("producer", "topics/*", "produce")
+	require.NoError(t, err)
+
+	_, err = authorizer.AddRole("alice", "admin")
+	require.NoError(t, err)
+
+	saved, err := os.ReadFile(policy)
+	require.NoError(t, err)
+	require.Equal(t, `# admins may do anything
+p, admin, *, admin
+
+# producers
+g, root, admin
+g, alice, admin
+`, string(saved))
+}
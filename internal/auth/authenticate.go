@@ -0,0 +1,294 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// SubjectSource selects the certificate field a TLSAuthenticator uses as the
+// subject.
+type SubjectSource int
+
+const (
+	// SubjectCommonName uses the certificate's subject common name.
+	SubjectCommonName SubjectSource = iota
+	// SubjectDNSName uses the certificate's first DNS subject alternative
+	// name.
+	SubjectDNSName
+	// SubjectSPIFFEID uses the certificate's SPIFFE ID, the URI subject
+	// alternative name with the spiffe scheme, such as
+	// spiffe://example.org/ns/prod/sa/producer.
+	SubjectSPIFFEID
+)
+
+// TLSAuthenticator authenticates clients by the verified certificate they
+// presented over mutual TLS.
+type TLSAuthenticator struct {
+	Source SubjectSource
+}
+
+// Authenticate returns the subject of the peer's verified client
+// certificate. It reports false if the peer did not connect over TLS with a
+// verified certificate, or if the request carries a bearer token, which takes
+// precedence over the certificate so that a rejected token is not ignored. It
+// returns an error if the certificate lacks the field selected by Source.
+func (a TLSAuthenticator) Authenticate(ctx context.Context) (string, bool, error) {
+	if _, ok := BearerToken(ctx); ok {
+		return "", false, nil
+	}
+
+	p, ok := peer.FromContext(ctx)
+
+	if !ok || p.AuthInfo == nil {
+		return "", false, nil
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+
+	if !ok ||
+		len(tlsInfo.State.VerifiedChains) == 0 ||
+		len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return "", false, nil
+	}
+
+	cert := tlsInfo.State.VerifiedChains[0][0]
+
+	switch a.Source {
+	case SubjectDNSName:
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0], true, nil
+		}
+		return "", false, errors.New("certificate has no DNS name")
+	case SubjectSPIFFEID:
+		for _, uri := range cert.URIs {
+			if uri.Scheme == "spiffe" {
+				return uri.String(), true, nil
+			}
+		}
+		return "", false, errors.New("certificate has no SPIFFE ID")
+	default:
+		return cert.Subject.CommonName, true, nil
+	}
+}
+
+// TokenAuthenticator authenticates clients by static bearer tokens.
+type TokenAuthenticator struct {
+	// subjects maps the SHA-256 hash of each token to its subject, so that
+	// tokens are not kept in memory and lookups do not leak their contents
+	// through timing.
+	subjects map[[sha256.Size]byte]string
+}
+
+// NewTokenAuthenticator returns a TokenAuthenticator for the tokens in the
+// given file. Each line of the file holds a token and the subject it
+// authenticates, separated by a comma, in the same format as the policy
+// file. Blank lines and lines starting with # are ignored.
+func NewTokenAuthenticator(file string) (*TokenAuthenticator, error) {
+	f, err := os.Open(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	a := &TokenAuthenticator{
+		subjects: make(map[[sha256.Size]byte]string),
+	}
+
+	scanner := bufio.NewScanner(f)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		token, subject, ok := strings.Cut(text, ",")
+		token = strings.TrimSpace(token)
+		subject = strings.TrimSpace(subject)
+
+		if !ok || token == "" || subject == "" {
+			return nil, fmt.Errorf("%s:%d: expected token, subject", file, line)
+		}
+
+		a.subjects[sha256.Sum256([]byte(token))] = subject
+	}
+
+	return a, scanner.Err()
+}
+
+// Authenticate returns the subject of the request's bearer token. It reports
+// false if the request has no bearer token or the token is not one of the
+// static tokens, so that other authenticators may accept it.
+func (a *TokenAuthenticator) Authenticate(ctx context.Context) (string, bool, error) {
+	token, ok := BearerToken(ctx)
+
+	if !ok {
+		return "", false, nil
+	}
+
+	subject, ok := a.subjects[sha256.Sum256([]byte(token))]
+
+	return subject, ok, nil
+}
+
+type JWTConfig struct {
+	// KeyFile holds the key verifying tokens: a PEM encoded RSA public key
+	// or certificate for RS256, RS384 and RS512 tokens, or otherwise the
+	// shared secret for HS256, HS384 and HS512 tokens.
+	KeyFile string
+	// Issuer and Audience, if set, must match the token's iss and aud
+	// claims.
+	Issuer   string
+	Audience string
+	// SubjectClaim names the claim holding the subject. It defaults to sub.
+	SubjectClaim string
+}
+
+// JWTAuthenticator authenticates clients by JSON Web Tokens verified with a
+// local key.
+type JWTAuthenticator struct {
+	config JWTConfig
+	key    interface{}
+	parser *jwt.Parser
+}
+
+// NewJWTAuthenticator returns a JWTAuthenticator verifying tokens with the
+// key in config's KeyFile.
+func NewJWTAuthenticator(config JWTConfig) (*JWTAuthenticator, error) {
+	b, err := os.ReadFile(config.KeyFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if config.SubjectClaim == "" {
+		config.SubjectClaim = "sub"
+	}
+
+	a := &JWTAuthenticator{config: config}
+
+	var methods []string
+
+	if block, _ := pem.Decode(b); block != nil {
+		a.key, err = rsaPublicKey(block)
+
+		if err != nil {
+			return nil, err
+		}
+
+		methods = []string{"RS256", "RS384", "RS512"}
+	} else {
+		secret := []byte(strings.TrimSpace(string(b)))
+
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("%s: empty secret", config.KeyFile)
+		}
+
+		a.key = secret
+		methods = []string{"HS256", "HS384", "HS512"}
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+	}
+
+	if config.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(config.Issuer))
+	}
+
+	if config.Audience != "" {
+		opts = append(opts, jwt.WithAudience(config.Audience))
+	}
+
+	a.parser = jwt.NewParser(opts...)
+
+	return a, nil
+}
+
+// Authenticate returns the subject claim of the request's bearer token if
+// it is a valid JWT. It reports false if the request has no bearer token or
+// the token is not a JWT, and returns an error if the token is a JWT that
+// fails verification.
+func (a *JWTAuthenticator) Authenticate(ctx context.Context) (string, bool, error) {
+	token, ok := BearerToken(ctx)
+
+	if !ok || strings.Count(token, ".") != 2 {
+		return "", false, nil
+	}
+
+	claims := jwt.MapClaims{}
+
+	if _, err := a.parser.ParseWithClaims(
+		token,
+		claims,
+		func(*jwt.Token) (interface{}, error) {
+			return a.key, nil
+		},
+	); err != nil {
+		return "", false, err
+	}
+
+	subject, _ := claims[a.config.SubjectClaim].(string)
+
+	if subject == "" {
+		return "", false, fmt.Errorf("token has no %s claim", a.config.SubjectClaim)
+	}
+
+	return subject, true, nil
+}
+
+// BearerToken returns the bearer token in the request's authorization
+// metadata, if any.
+func BearerToken(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+
+	if !ok {
+		return "", false
+	}
+
+	for _, value := range md.Get("authorization") {
+		scheme, token, ok := strings.Cut(value, " ")
+
+		if ok && strings.EqualFold(scheme, "bearer") && token != "" {
+			return strings.TrimSpace(token), true
+		}
+	}
+
+	return "", false
+}
+
+// rsaPublicKey parses the RSA public key in a PEM block holding either the
+// key itself or a certificate.
+func rsaPublicKey(block *pem.Block) (interface{}, error) {
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return cert.PublicKey, nil
+	}
+
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return x509.ParsePKCS1PublicKey(block.Bytes)
+}
@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// TestTLSAuthenticator tests that the subject is taken from the selected
+// field of the verified client certificate, and that peers without one are
+// left to other authenticators.
+func TestTLSAuthenticator(t *testing.T) {
+	spiffeID, err := url.Parse("spiffe://example.org/ns/prod/sa/producer")
+	require.NoError(t, err)
+
+	ctx := tlsContext(&x509.Certificate{
+		Subject:  pkix.Name{CommonName: "root"},
+		DNSNames: []string{"producer.example.org"},
+		URIs:     []*url.URL{spiffeID},
+	})
+
+	for source, want := range map[SubjectSource]string{
+		SubjectCommonName: "root",
+		SubjectDNSName:    "producer.example.org",
+		SubjectSPIFFEID:   "spiffe://example.org/ns/prod/sa/producer",
+	} {
+		subject, ok, err := TLSAuthenticator{Source: source}.Authenticate(ctx)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, want, subject)
+	}
+
+	_, _, err = TLSAuthenticator{Source: SubjectSPIFFEID}.Authenticate(
+		tlsContext(&x509.Certificate{Subject: pkix.Name{CommonName: "root"}}),
+	)
+	require.Error(t, err)
+
+	_, ok, err := TLSAuthenticator{}.Authenticate(
+		peer.NewContext(context.Background(), &peer.Peer{}),
+	)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// Bearer tokens take precedence over the certificate.
+	_, ok, err = TLSAuthenticator{}.Authenticate(metadata.NewIncomingContext(
+		ctx,
+		metadata.Pairs("authorization", "Bearer t0k3n"),
+	))
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+// TestTokenAuthenticator tests that static bearer tokens read from a file
+// map to their subjects and that unknown tokens are left to other
+// authenticators.
+func TestTokenAuthenticator(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tokens.csv")
+	require.NoError(t, os.WriteFile(file, []byte(
+		"# dashboards\ns3cr3t, dashboard\n\nt0k3n, producer\n",
+	), 0600))
+
+	authenticator, err := NewTokenAuthenticator(file)
+	require.NoError(t, err)
+
+	subject, ok, err := authenticator.Authenticate(bearerContext("t0k3n"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "producer", subject)
+
+	_, ok, err = authenticator.Authenticate(bearerContext("unknown"))
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	_, ok, err = authenticator.Authenticate(context.Background())
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, os.WriteFile(file, []byte("no-subject"), 0600))
+	_, err = NewTokenAuthenticator(file)
+	require.Error(t, err)
+}
+
+// TestJWTAuthenticator tests that JWTs signed with an HMAC secret or an RSA
+// key read from disk are verified, and that tokens with an invalid
+// signature, issuer or expiry are rejected.
+func TestJWTAuthenticator(t *testing.T) {
+	dir := t.TempDir()
+
+	secretFile := filepath.Join(dir, "secret")
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	require.NoError(t, os.WriteFile(secretFile, append(secret, '\n'), 0600))
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	publicKey, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+
+	publicKeyFile := filepath.Join(dir, "public.pem")
+	require.NoError(t, os.WriteFile(publicKeyFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: publicKey,
+	}), 0644))
+
+	claims := func(issuer string, expiry time.Duration) jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub": "producer",
+			"iss": issuer,
+			"exp": time.Now().Add(expiry).Unix(),
+		}
+	}
+
+	sign := func(method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		require.NoError(t, err)
+		return token
+	}
+
+	hmac, err := NewJWTAuthenticator(JWTConfig{
+		KeyFile: secretFile,
+		Issuer:  "prolog",
+	})
+	require.NoError(t, err)
+
+	rsaAuthenticator, err := NewJWTAuthenticator(JWTConfig{KeyFile: publicKeyFile})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name          string
+		authenticator *JWTAuthenticator
+		token         string
+		valid         bool
+	}{
+		{
+			name:          "hmac",
+			authenticator: hmac,
+			token:         sign(jwt.SigningMethodHS256, secret, claims("prolog", time.Hour)),
+			valid:         true,
+		},
+		{
+			name:          "rsa",
+			authenticator: rsaAuthenticator,
+			token:         sign(jwt.SigningMethodRS256, rsaKey, claims("prolog", time.Hour)),
+			valid:         true,
+		},
+		{
+			name:          "wrong secret",
+			authenticator: hmac,
+			token:         sign(jwt.SigningMethodHS256, []byte("wrong"), claims("prolog", time.Hour)),
+		},
+		{
+			name:          "wrong issuer",
+			authenticator: hmac,
+			token:         sign(jwt.SigningMethodHS256, secret, claims("other", time.Hour)),
+		},
+		{
+			name:          "expired",
+			authenticator: hmac,
+			token:         sign(jwt.SigningMethodHS256, secret, claims("prolog", -time.Hour)),
+		},
+		{
+			name:          "hmac signed with the rsa public key",
+			authenticator: rsaAuthenticator,
+			token:         sign(jwt.SigningMethodHS256, publicKey, claims("prolog", time.Hour)),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			subject, ok, err := tc.authenticator.Authenticate(bearerContext(tc.token))
+
+			if !tc.valid {
+				require.Error(t, err)
+				require.False(t, ok)
+				return
+			}
+
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, "producer", subject)
+		})
+	}
+
+	// Tokens that are not JWTs are left to other authenticators.
+	_, ok, err := hmac.Authenticate(bearerContext("s3cr3t"))
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+// tlsContext returns a context whose peer connected over TLS with cert as
+// its verified client certificate.
+func tlsContext(cert *x509.Certificate) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{
+			State: tls.ConnectionState{
+				VerifiedChains: [][]*x509.Certificate{{cert}},
+			},
+		},
+	})
+}
+
+// bearerContext returns an incoming context carrying token as a bearer
+// token.
+func bearerContext(token string) context.Context {
+	return metadata.NewIncomingContext(
+		context.Background(),
+		metadata.Pairs("authorization", "Bearer "+token),
+	)
+}
@@ -0,0 +1,265 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	// KeySize is the size of the RSA keys generated for certificates.
+	KeySize = 2048
+	// Expiry is how long issued certificates are valid for.
+	Expiry = 8760 * time.Hour
+	// Subject holds the names besides the common name set on every
+	// certificate.
+	Subject = pkix.Name{
+		Country:            []string{"KE"},
+		Locality:           []string{"NBO"},
+		Province:           []string{"Nairobi"},
+		Organization:       []string{"Prolog Test"},
+		OrganizationalUnit: []string{"CA Services"},
+	}
+)
+
+// Profile selects what an issued certificate may be used for.
+type Profile int
+
+const (
+	// ServerProfile issues certificates for servers, valid for the hosts
+	// they are issued for.
+	ServerProfile Profile = iota
+	// ClientProfile issues certificates authenticating clients by their
+	// common name.
+	ClientProfile
+	// PeerProfile issues certificates for agents, which serve clients and
+	// connect to the other members as clients themselves.
+	PeerProfile
+)
+
+// Request describes a certificate to issue.
+type Request struct {
+	CommonName string
+	// Hosts lists the DNS names, IP addresses and URIs, such as SPIFFE IDs,
+	// the certificate is valid for.
+	Hosts   []string
+	Profile Profile
+}
+
+// Certificate is a PEM encoded certificate and its private key.
+type Certificate struct {
+	Cert []byte
+	Key  []byte
+}
+
+// Write writes the certificate and key to the given files, creating their
+// directories if needed. The key is only readable by its owner.
+func (c *Certificate) Write(certFile, keyFile string) error {
+	for _, f := range []struct {
+		name string
+		data []byte
+		perm os.FileMode
+	}{
+		{certFile, c.Cert, 0644},
+		{keyFile, c.Key, 0600},
+	} {
+		if err := os.MkdirAll(filepath.Dir(f.name), 0755); err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(f.name, f.data, f.perm); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CA is a certificate authority issuing certificates.
+type CA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+	pem  *Certificate
+}
+
+// NewCA returns a CA with a new self-signed certificate for the given common
+// name.
+func NewCA(commonName string) (*CA, error) {
+	key, err := rsa.GenerateKey(rand.Reader, KeySize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := serialNumber()
+
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               subject(commonName),
+		NotBefore:             time.Now().Add(-5 * time.Minute),
+		NotAfter:              time.Now().Add(5 * Expiry),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newCA(der, key)
+}
+
+// LoadCA returns the CA whose PEM encoded certificate and key are in the
+// given files.
+func LoadCA(certFile, keyFile string) (*CA, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := pair.PrivateKey.(crypto.Signer)
+
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported private key", keyFile)
+	}
+
+	ca, err := newCA(pair.Certificate[0], key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !ca.cert.IsCA {
+		return nil, fmt.Errorf("%s: not a CA certificate", certFile)
+	}
+
+	return ca, nil
+}
+
+// Certificate returns the CA's own PEM encoded certificate and key.
+func (ca *CA) Certificate() *Certificate {
+	return ca.pem
+}
+
+// Issue returns a new certificate signed by the CA.
+func (ca *CA) Issue(req Request) (*Certificate, error) {
+	if req.CommonName == "" {
+		return nil, errors.New("certificate needs a common name")
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, KeySize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := serialNumber()
+
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               subject(req.CommonName),
+		NotBefore:             time.Now().Add(-5 * time.Minute),
+		NotAfter:              time.Now().Add(Expiry),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		BasicConstraintsValid: true,
+	}
+
+	switch req.Profile {
+	case ServerProfile:
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	case ClientProfile:
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	case PeerProfile:
+		template.ExtKeyUsage = []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth,
+		}
+	default:
+		return nil, fmt.Errorf("unknown profile %d", req.Profile)
+	}
+
+	for _, host := range req.Hosts {
+		if host == "" {
+			continue
+		}
+
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if uri, err := url.Parse(host); err == nil && uri.Scheme != "" && uri.Host != "" {
+			template.URIs = append(template.URIs, uri)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return encode(der, key), nil
+}
+
+func newCA(der []byte, key crypto.Signer) (*CA, error) {
+	cert, err := x509.ParseCertificate(der)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+
+	if !ok {
+		return nil, errors.New("CA key is not an RSA key")
+	}
+
+	return &CA{cert: cert, key: key, pem: encode(der, rsaKey)}, nil
+}
+
+// encode PEM encodes a certificate and its key in the format written by
+// cfssl.
+func encode(der []byte, key *rsa.PrivateKey) *Certificate {
+	return &Certificate{
+		Cert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		Key: pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		}),
+	}
+}
+
+func subject(commonName string) pkix.Name {
+	name := Subject
+	name.CommonName = commonName
+	return name
+}
+
+// serialNumber returns a random 128 bit serial number.
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
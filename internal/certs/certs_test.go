@@ -0,0 +1,122 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestIssue tests that issued certificates chain to the CA, carry their
+// hosts and are usable only for their profile's purposes.
+func TestIssue(t *testing.T) {
+	KeySize = 1024
+	t.Cleanup(func() { KeySize = 2048 })
+
+	ca, err := NewCA("prolog test CA")
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	caKeyFile := filepath.Join(dir, "ca-key.pem")
+	require.NoError(t, ca.Certificate().Write(caFile, caKeyFile))
+
+	// Certificates issued by a loaded CA verify against the written one.
+	ca, err = LoadCA(caFile, caKeyFile)
+	require.NoError(t, err)
+
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(ca.Certificate().Cert))
+
+	for _, tc := range []struct {
+		name   string
+		req    Request
+		usages []x509.ExtKeyUsage
+		denied []x509.ExtKeyUsage
+	}{
+		{
+			name: "server",
+			req: Request{
+				CommonName: "127.0.0.1",
+				Hosts:      []string{"localhost", "127.0.0.1"},
+				Profile:    ServerProfile,
+			},
+			usages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			denied: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		},
+		{
+			name: "client",
+			req: Request{
+				CommonName: "root",
+				Hosts:      []string{"spiffe://example.org/ns/prod/sa/root"},
+				Profile:    ClientProfile,
+			},
+			usages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			denied: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		},
+		{
+			name: "peer",
+			req: Request{
+				CommonName: "127.0.0.1",
+				Hosts:      []string{"127.0.0.1"},
+				Profile:    PeerProfile,
+			},
+			usages: []x509.ExtKeyUsage{
+				x509.ExtKeyUsageServerAuth,
+				x509.ExtKeyUsageClientAuth,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			issued, err := ca.Issue(tc.req)
+			require.NoError(t, err)
+
+			certFile := filepath.Join(dir, tc.name+".pem")
+			keyFile := filepath.Join(dir, tc.name+"-key.pem")
+			require.NoError(t, issued.Write(certFile, keyFile))
+
+			pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+			require.NoError(t, err)
+
+			cert, err := x509.ParseCertificate(pair.Certificate[0])
+			require.NoError(t, err)
+			require.Equal(t, tc.req.CommonName, cert.Subject.CommonName)
+			require.Equal(t, []string{"Prolog Test"}, cert.Subject.Organization)
+
+			for _, usage := range tc.usages {
+				_, err = cert.Verify(x509.VerifyOptions{
+					Roots:     roots,
+					KeyUsages: []x509.ExtKeyUsage{usage},
+				})
+				require.NoError(t, err)
+			}
+
+			for _, usage := range tc.denied {
+				_, err = cert.Verify(x509.VerifyOptions{
+					Roots:     roots,
+					KeyUsages: []x509.ExtKeyUsage{usage},
+				})
+				require.Error(t, err)
+			}
+
+			switch tc.name {
+			case "server":
+				require.NoError(t, cert.VerifyHostname("localhost"))
+				require.NoError(t, cert.VerifyHostname("127.0.0.1"))
+				require.Error(t, cert.VerifyHostname("example.org"))
+			case "client":
+				require.Len(t, cert.URIs, 1)
+				require.Equal(t, "spiffe", cert.URIs[0].Scheme)
+			}
+		})
+	}
+
+	_, err = ca.Issue(Request{Profile: ClientProfile})
+	require.Error(t, err)
+
+	// Leaf certificates cannot be loaded as a CA.
+	_, err = LoadCA(filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem"))
+	require.Error(t, err)
+}
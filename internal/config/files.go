@@ -2,6 +2,7 @@ package config
 
 var (
 	CAFile               = configFile("ca.pem")
+	CAKeyFile            = configFile("ca-key.pem")
 	ServerCertFile       = configFile("server.pem")
 	ServerKeyFile        = configFile("server-key.pem")
 	ClientCertFile       = configFile("client.pem")
@@ -13,3 +14,11 @@ var (
 	ACLModelFile         = configFile("model.conf")
 	ACLPolicyFile        = configFile("policy.csv")
 )
+
+// ClientFiles returns the certificate and key files of the client
+// certificate for the given common name, such as RootCLientCertFile and
+// RootClientKeyFile for root.
+func ClientFiles(commonName string) (certFile, keyFile string) {
+	return configFile(commonName + "-client.pem"),
+		configFile(commonName + "-client-key.pem")
+}
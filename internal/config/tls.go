@@ -3,59 +3,378 @@ package config
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"os"
+	"sync"
+	"time"
 )
 
+// TLSReloadInterval is the minimum time between checks for changed files by
+// TLS configs set up with Reload.
+var TLSReloadInterval = 10 * time.Second
+
 type TLSConfig struct {
 	CertFile      string
 	KeyFile       string
 	CAFile        string
 	ServerAddress string
 	Server        bool
+	// Reload makes the config pick up changes to the certificate, key, CA
+	// and CRL files without a restart. The files are checked during
+	// handshakes, at most once every TLSReloadInterval.
+	Reload bool
+	// CRLFile, if set, holds a PEM or DER encoded certificate revocation list
+	// signed by the CA. Peer certificates it revokes are rejected.
+	CRLFile string
 }
 
+// SetupTLSConfig returns a tls.Config for the given certificate, key and CA
+// files. Server configs require and verify client certificates signed by the
+// CA, while client configs verify the server's certificate with it. It
+// returns an error if any of the files cannot be loaded.
 func SetupTLSConfig(config TLSConfig) (*tls.Config, error) {
 
-	var err error
+	files := &tlsFiles{config: config}
+
+	if err := files.load(); err != nil {
+		return nil, err
+	}
 
 	tlsConfig := &tls.Config{}
 
-	if config.CertFile != "" && config.KeyFile != "" {
-		tlsConfig.Certificates = make([]tls.Certificate, 1)
+	if files.cert != nil {
+		tlsConfig.Certificates = []tls.Certificate{*files.cert}
+	}
 
-		tlsConfig.Certificates[0], err = tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+	if config.CAFile != "" {
+		if config.Server {
+			tlsConfig.ClientCAs = files.ca
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			tlsConfig.RootCAs = files.ca
+		}
+		tlsConfig.ServerName = config.ServerAddress
+	}
 
-		if err != nil {
-			return nil, err
+	if config.CRLFile != "" {
+		tlsConfig.VerifyPeerCertificate = func(
+			_ [][]byte,
+			chains [][]*x509.Certificate,
+		) error {
+			return files.checkRevoked(chains)
+		}
+	}
+
+	if !config.Reload {
+		return tlsConfig, nil
+	}
+
+	if config.Server {
+		base := tlsConfig.Clone()
+
+		// Each handshake is served with the certificate and CA loaded at
+		// the time.
+		tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			files.reload()
+
+			c := base.Clone()
+			c.Certificates = nil
+
+			if cert := files.certificate(); cert != nil {
+				c.Certificates = []tls.Certificate{*cert}
+			}
+
+			if config.CAFile != "" {
+				c.ClientCAs = files.pool()
+			}
+
+			return c, nil
+		}
+
+		return tlsConfig, nil
+	}
+
+	if files.cert != nil {
+		tlsConfig.Certificates = nil
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			files.reload()
+			return files.certificate(), nil
 		}
 	}
 
 	if config.CAFile != "" {
-		b, err := os.ReadFile(config.CAFile)
+		// Clients cannot swap their root CAs between handshakes, so the
+		// server's certificate is verified against the current CA here
+		// instead of by crypto/tls.
+		tlsConfig.RootCAs = nil
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = nil
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			files.reload()
+			return files.verifyServer(cs)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// tlsFiles holds the certificate, CA and CRL loaded from a TLSConfig's files,
+// reloading them when they change.
+type tlsFiles struct {
+	config TLSConfig
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	ca       *x509.CertPool
+	crl      *x509.RevocationList
+	modTimes map[string]time.Time
+	checked  time.Time
+}
+
+// load reads every configured file, replacing the loaded state only if all
+// of them are valid.
+func (f *tlsFiles) load() error {
+	modTimes := make(map[string]time.Time)
+
+	for _, file := range []string{
+		f.config.CertFile,
+		f.config.KeyFile,
+		f.config.CAFile,
+		f.config.CRLFile,
+	} {
+		if file == "" {
+			continue
+		}
+
+		info, err := os.Stat(file)
+
+		if err != nil {
+			return err
+		}
+
+		modTimes[file] = info.ModTime()
+	}
+
+	var cert *tls.Certificate
+
+	if f.config.CertFile != "" && f.config.KeyFile != "" {
+		c, err := tls.LoadX509KeyPair(f.config.CertFile, f.config.KeyFile)
+
+		if err != nil {
+			return err
+		}
+
+		cert = &c
+	}
+
+	var (
+		ca      *x509.CertPool
+		caCerts []*x509.Certificate
+	)
+
+	if f.config.CAFile != "" {
+		b, err := os.ReadFile(f.config.CAFile)
 
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		ca := x509.NewCertPool()
+		ca = x509.NewCertPool()
 		ok := ca.AppendCertsFromPEM([]byte(b))
 
 		if !ok {
-			return nil, fmt.Errorf(
+			return fmt.Errorf(
 				"failed to parse root certificates from: %q",
-				config.CAFile,
+				f.config.CAFile,
 			)
 		}
 
-		if config.Server {
-			tlsConfig.ClientCAs = ca
-			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
-		} else {
-			tlsConfig.RootCAs = ca
+		caCerts = parseCertificates(b)
+	}
+
+	var crl *x509.RevocationList
+
+	if f.config.CRLFile != "" {
+		var err error
+
+		crl, err = loadCRL(f.config.CRLFile, caCerts)
+
+		if err != nil {
+			return err
 		}
-		tlsConfig.ServerName = config.ServerAddress
 	}
 
-	return tlsConfig, nil
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.cert = cert
+	f.ca = ca
+	f.crl = crl
+	f.modTimes = modTimes
+	f.checked = time.Now()
+
+	return nil
+}
+
+// reload loads the files again if any has changed since they were loaded,
+// checking at most once every TLSReloadInterval. If the new files are
+// invalid, for instance because a certificate has been written but not yet
+// its key, the current state is kept and the files are tried again at the
+// next check.
+func (f *tlsFiles) reload() {
+	f.mu.Lock()
+
+	if time.Since(f.checked) < TLSReloadInterval {
+		f.mu.Unlock()
+		return
+	}
+
+	f.checked = time.Now()
+
+	changed := false
+
+	for file, modTime := range f.modTimes {
+		info, err := os.Stat(file)
+
+		if err != nil || !info.ModTime().Equal(modTime) {
+			changed = true
+			break
+		}
+	}
+
+	f.mu.Unlock()
+
+	if changed {
+		_ = f.load()
+	}
+}
+
+func (f *tlsFiles) certificate() *tls.Certificate {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.cert
+}
+
+func (f *tlsFiles) pool() *x509.CertPool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.ca
+}
+
+// verifyServer verifies the server's certificate chain against the current
+// CA and the ServerAddress, or the name the client connected to if none is
+// configured, and checks it against the CRL.
+func (f *tlsFiles) verifyServer(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server presented no certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	serverName := f.config.ServerAddress
+
+	if serverName == "" {
+		serverName = cs.ServerName
+	}
+
+	chains, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         f.pool(),
+		Intermediates: intermediates,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return f.checkRevoked(chains)
+}
+
+// checkRevoked returns an error if the CRL revokes any certificate in the
+// verified chains.
+func (f *tlsFiles) checkRevoked(chains [][]*x509.Certificate) error {
+	f.mu.RLock()
+	crl := f.crl
+	f.mu.RUnlock()
+
+	if crl == nil {
+		return nil
+	}
+
+	for _, chain := range chains {
+		for _, cert := range chain {
+			for _, revoked := range crl.RevokedCertificateEntries {
+				if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+					return fmt.Errorf(
+						"certificate %s (serial %s) has been revoked",
+						cert.Subject.CommonName,
+						cert.SerialNumber,
+					)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// loadCRL reads a PEM or DER encoded revocation list from file and checks
+// that it is signed by one of the CA certificates, if any are given.
+func loadCRL(file string, caCerts []*x509.Certificate) (*x509.RevocationList, error) {
+	b, err := os.ReadFile(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if block, _ := pem.Decode(b); block != nil {
+		b = block.Bytes
+	}
+
+	crl, err := x509.ParseRevocationList(b)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse revocation list from: %q: %w", file, err)
+	}
+
+	if len(caCerts) == 0 {
+		return crl, nil
+	}
+
+	for _, ca := range caCerts {
+		if crl.CheckSignatureFrom(ca) == nil {
+			return crl, nil
+		}
+	}
+
+	return nil, fmt.Errorf("revocation list %q is not signed by the CA", file)
+}
+
+// parseCertificates returns the certificates in PEM encoded b.
+func parseCertificates(b []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+
+	for {
+		var block *pem.Block
+
+		block, b = pem.Decode(b)
+
+		if block == nil {
+			return certs
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			certs = append(certs, cert)
+		}
+	}
 }
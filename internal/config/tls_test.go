@@ -0,0 +1,339 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestTLSReload tests that configs set up with Reload pick up a rotated
+// certificate and CA on both sides of the connection.
+func TestTLSReload(t *testing.T) {
+	interval := TLSReloadInterval
+	TLSReloadInterval = 0
+	t.Cleanup(func() { TLSReloadInterval = interval })
+
+	dir := t.TempDir()
+
+	ca := newTestCA(t)
+	ca.write(t, dir)
+	ca.issue(t, dir, "server", 1)
+	ca.issue(t, dir, "client", 2)
+
+	serverConfig, err := SetupTLSConfig(TLSConfig{
+		CertFile: filepath.Join(dir, "server.pem"),
+		KeyFile:  filepath.Join(dir, "server-key.pem"),
+		CAFile:   filepath.Join(dir, "ca.pem"),
+		Server:   true,
+		Reload:   true,
+	})
+	require.NoError(t, err)
+
+	clientConfig, err := SetupTLSConfig(TLSConfig{
+		CertFile:      filepath.Join(dir, "client.pem"),
+		KeyFile:       filepath.Join(dir, "client-key.pem"),
+		CAFile:        filepath.Join(dir, "ca.pem"),
+		ServerAddress: "127.0.0.1",
+		Reload:        true,
+	})
+	require.NoError(t, err)
+
+	addr := serveTLS(t, serverConfig)
+
+	require.Equal(t, "client", handshake(t, addr, clientConfig))
+
+	// Rotate to a new CA and certificates. The old client no longer trusts
+	// the server until it reloads, and vice versa.
+	rotated := newTestCA(t)
+	rotated.write(t, dir)
+	rotated.issue(t, dir, "server", 3)
+	rotated.issue(t, dir, "client-2", 4)
+	require.NoError(t, os.Rename(
+		filepath.Join(dir, "client-2.pem"),
+		filepath.Join(dir, "client.pem"),
+	))
+	require.NoError(t, os.Rename(
+		filepath.Join(dir, "client-2-key.pem"),
+		filepath.Join(dir, "client-key.pem"),
+	))
+	touch(t, dir)
+
+	require.Equal(t, "client-2", handshake(t, addr, clientConfig))
+
+	// A half written rotation keeps the current certificate.
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, "server-key.pem"),
+		[]byte("not a key"),
+		0600,
+	))
+	touch(t, dir)
+
+	require.Equal(t, "client-2", handshake(t, addr, clientConfig))
+}
+
+// TestTLSRevocation tests that certificates listed in the CRL are rejected
+// by servers and clients.
+func TestTLSRevocation(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCA(t)
+	ca.write(t, dir)
+	ca.issue(t, dir, "server", 1)
+	ca.issue(t, dir, "client", 2)
+	ca.issue(t, dir, "revoked", 3)
+	ca.revoke(t, dir, 3)
+
+	serverConfig, err := SetupTLSConfig(TLSConfig{
+		CertFile: filepath.Join(dir, "server.pem"),
+		KeyFile:  filepath.Join(dir, "server-key.pem"),
+		CAFile:   filepath.Join(dir, "ca.pem"),
+		CRLFile:  filepath.Join(dir, "crl.pem"),
+		Server:   true,
+	})
+	require.NoError(t, err)
+
+	addr := serveTLS(t, serverConfig)
+
+	for name, valid := range map[string]bool{
+		"client":  true,
+		"revoked": false,
+	} {
+		clientConfig, err := SetupTLSConfig(TLSConfig{
+			CertFile:      filepath.Join(dir, name+".pem"),
+			KeyFile:       filepath.Join(dir, name+"-key.pem"),
+			CAFile:        filepath.Join(dir, "ca.pem"),
+			ServerAddress: "127.0.0.1",
+		})
+		require.NoError(t, err)
+
+		err = dialTLS(addr, clientConfig)
+
+		if valid {
+			require.NoError(t, err, name)
+		} else {
+			require.Error(t, err, name)
+		}
+	}
+
+	// Clients reject revoked servers.
+	ca.revoke(t, dir, 1)
+
+	clientConfig, err := SetupTLSConfig(TLSConfig{
+		CertFile:      filepath.Join(dir, "client.pem"),
+		KeyFile:       filepath.Join(dir, "client-key.pem"),
+		CAFile:        filepath.Join(dir, "ca.pem"),
+		CRLFile:       filepath.Join(dir, "crl.pem"),
+		ServerAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+	require.ErrorContains(t, dialTLS(addr, clientConfig), "revoked")
+
+	// CRLs not signed by the CA are refused.
+	other := newTestCA(t)
+	other.revoke(t, dir, 2)
+
+	_, err = SetupTLSConfig(TLSConfig{
+		CAFile:  filepath.Join(dir, "ca.pem"),
+		CRLFile: filepath.Join(dir, "crl.pem"),
+		Server:  true,
+	})
+	require.Error(t, err)
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{cert: cert, key: key, der: der}
+}
+
+func (ca *testCA) write(t *testing.T, dir string) {
+	t.Helper()
+	writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", ca.der)
+}
+
+// issue writes a certificate for name, valid for client and server use on
+// 127.0.0.1, and its key to dir.
+func (ca *testCA) issue(t *testing.T, dir, name string, serial int64) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth,
+		},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	}, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	writePEM(t, filepath.Join(dir, name+".pem"), "CERTIFICATE", der)
+	writePEM(t, filepath.Join(dir, name+"-key.pem"), "EC PRIVATE KEY", keyDER)
+}
+
+// revoke writes a CRL revoking the given serial numbers to dir.
+func (ca *testCA) revoke(t *testing.T, dir string, serials ...int64) {
+	t.Helper()
+
+	var entries []x509.RevocationListEntry
+
+	for _, serial := range serials {
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   big.NewInt(serial),
+			RevocationTime: time.Now(),
+		})
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(time.Now().UnixNano()),
+		ThisUpdate:                time.Now(),
+		NextUpdate:                time.Now().Add(time.Hour),
+		RevokedCertificateEntries: entries,
+	}, ca.cert, ca.key)
+	require.NoError(t, err)
+
+	writePEM(t, filepath.Join(dir, "crl.pem"), "X509 CRL", der)
+}
+
+func writePEM(t *testing.T, file, typ string, der []byte) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(
+		file,
+		pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}),
+		0600,
+	))
+}
+
+// touch moves the modification times of the files in dir forward, so that
+// rewrites within the file system's timestamp resolution are noticed.
+func touch(t *testing.T, dir string) {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+
+	modTime := time.Now().Add(time.Duration(len(entries)) * time.Minute)
+
+	for _, entry := range entries {
+		require.NoError(t, os.Chtimes(
+			filepath.Join(dir, entry.Name()),
+			modTime,
+			modTime,
+		))
+	}
+}
+
+// serveTLS accepts TLS connections on a local port, writing the common name
+// of each client's certificate back to it.
+func serveTLS(t *testing.T, config *tls.Config) string {
+	t.Helper()
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				tlsConn := conn.(*tls.Conn)
+
+				if err := tlsConn.Handshake(); err != nil {
+					return
+				}
+
+				certs := tlsConn.ConnectionState().PeerCertificates
+				_, _ = conn.Write([]byte(certs[0].Subject.CommonName + "\n"))
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+// handshake connects to addr and returns the common name the server saw.
+func handshake(t *testing.T, addr string, config *tls.Config) string {
+	t.Helper()
+
+	conn, err := tls.Dial("tcp", addr, config)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	b := make([]byte, 64)
+	n, err := conn.Read(b)
+	require.NoError(t, err)
+
+	return string(b[:n-1])
+}
+
+// dialTLS connects to addr and waits for the server to accept the client's
+// certificate.
+func dialTLS(addr string, config *tls.Config) error {
+	conn, err := tls.Dial("tcp", addr, config)
+
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	// With TLS 1.3 the server verifies the client's certificate after the
+	// client's handshake completes, so its verdict arrives on the first
+	// read.
+	_, err = conn.Read(make([]byte, 64))
+
+	return err
+}
@@ -0,0 +1,354 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"go.uber.org/zap"
+)
+
+// Resolver looks up DNS records. A *net.Resolver is a Resolver.
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+type DNSConfig struct {
+	NodeName string
+	// RPCAddr is the local node's RPC address. The node recognizes itself
+	// among the resolved addresses by their port and IP addresses, which
+	// are its own if they are RPCAddr's or one of its interfaces'.
+	RPCAddr string
+	// Name is the DNS name listing the cluster's servers. Names starting with
+	// an underscore, such as _prolog._tcp.prolog.svc.cluster.local, are looked
+	// up as SRV records giving each server's host and port. Other names are
+	// looked up as A and AAAA records, with the servers listening on Port.
+	Name string
+	Port int
+	// LeaderAddr is the RPC address of the cluster's leader, which DNS
+	// records cannot express.
+	LeaderAddr string
+	// Interval is the time between lookups. It defaults to 10 seconds.
+	Interval time.Duration
+	// Resolver looks up the records. It defaults to net.DefaultResolver.
+	Resolver Resolver
+}
+
+// DNS is a Provider discovering servers by polling DNS records, for
+// environments where gossip is blocked. Servers are named by their RPC
+// address, except for the local node. DNS records carry no tags, so servers
+// are joined with the leader tag alone: replicas placed across zones all
+// appear to be in the same zone.
+type DNS struct {
+	DNSConfig
+	handler Handler
+	logger  *zap.Logger
+	// interfaceAddrs returns the addresses of the local interfaces. It
+	// defaults to net.InterfaceAddrs.
+	interfaceAddrs func() ([]net.Addr, error)
+
+	mu    sync.Mutex
+	addrs map[string]bool
+
+	close     chan struct{}
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// NewDNS returns a DNS provider for the config's name. It resolves the name
+// once before returning, failing if it cannot, and then polls it every
+// Interval, joining servers as they appear and leaving them once they are no
+// longer listed. Failed lookups are logged and leave the servers unchanged.
+func NewDNS(handler Handler, config DNSConfig) (*DNS, error) {
+	return newDNS(handler, config, net.InterfaceAddrs)
+}
+
+// newDNS returns a DNS provider recognizing the local node by the addresses
+// interfaceAddrs returns.
+func newDNS(
+	handler Handler,
+	config DNSConfig,
+	interfaceAddrs func() ([]net.Addr, error),
+) (*DNS, error) {
+	if config.Interval == 0 {
+		config.Interval = 10 * time.Second
+	}
+
+	if config.Resolver == nil {
+		config.Resolver = net.DefaultResolver
+	}
+
+	d := &DNS{
+		DNSConfig:      config,
+		handler:        handler,
+		logger:         zap.L().Named("dns"),
+		interfaceAddrs: interfaceAddrs,
+		addrs:          make(map[string]bool),
+		close:          make(chan struct{}),
+		closed:         make(chan struct{}),
+	}
+
+	if err := d.refresh(); err != nil {
+		return nil, err
+	}
+
+	go d.poll()
+
+	return d, nil
+}
+
+// GetServers returns the local node and the servers found by the last
+// lookup.
+func (d *DNS) GetServers() ([]*api.Server, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	servers := []*api.Server{{
+		Id:       d.NodeName,
+		RpcAddr:  d.RPCAddr,
+		IsLeader: d.RPCAddr == d.LeaderAddr,
+	}}
+
+	addrs := make([]string, 0, len(d.addrs))
+
+	for addr := range d.addrs {
+		addrs = append(addrs, addr)
+	}
+
+	sort.Strings(addrs)
+
+	for _, addr := range addrs {
+		servers = append(servers, &api.Server{
+			Id:       addr,
+			RpcAddr:  addr,
+			IsLeader: addr == d.LeaderAddr,
+		})
+	}
+
+	return servers, nil
+}
+
+// Leave stops polling.
+func (d *DNS) Leave() error {
+	d.closeOnce.Do(func() {
+		close(d.close)
+	})
+
+	<-d.closed
+
+	return nil
+}
+
+func (d *DNS) poll() {
+	defer close(d.closed)
+
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.close:
+			return
+		case <-ticker.C:
+			if err := d.refresh(); err != nil {
+				d.logger.Error(
+					"failed to resolve servers",
+					zap.Error(err),
+					zap.String("name", d.Name),
+				)
+			}
+		}
+	}
+}
+
+// refresh resolves the servers, joining new ones and leaving those no longer
+// listed.
+func (d *DNS) refresh() error {
+	ctx, cancel := context.WithTimeout(context.Background(), d.Interval)
+	defer cancel()
+
+	resolved, err := d.resolve(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for addr := range resolved {
+		if d.addrs[addr] {
+			continue
+		}
+
+		var tags map[string]string
+
+		if addr == d.LeaderAddr {
+			tags = map[string]string{"leader": "true"}
+		}
+
+		if err := join(d.handler, addr, addr, tags); err != nil {
+			d.logger.Error(
+				"failed to join",
+				zap.Error(err),
+				zap.String("rpc_addr", addr),
+			)
+			continue
+		}
+
+		d.addrs[addr] = true
+	}
+
+	for addr := range d.addrs {
+		if resolved[addr] {
+			continue
+		}
+
+		if err := d.handler.Leave(addr); err != nil {
+			d.logger.Error(
+				"failed to leave",
+				zap.Error(err),
+				zap.String("rpc_addr", addr),
+			)
+		}
+
+		delete(d.addrs, addr)
+	}
+
+	return nil
+}
+
+// resolve returns the RPC addresses listed by the DNS name, other than the
+// local node's.
+func (d *DNS) resolve(ctx context.Context) (map[string]bool, error) {
+	var addrs []string
+
+	if strings.HasPrefix(d.Name, "_") {
+		_, records, err := d.Resolver.LookupSRV(ctx, "", "", d.Name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, record := range records {
+			addrs = append(addrs, net.JoinHostPort(
+				strings.TrimSuffix(record.Target, "."),
+				strconv.Itoa(int(record.Port)),
+			))
+		}
+	} else {
+		hosts, err := d.Resolver.LookupHost(ctx, d.Name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, host := range hosts {
+			addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(d.Port)))
+		}
+	}
+
+	local, port, err := d.local(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := make(map[string]bool, len(addrs))
+
+	for _, addr := range addrs {
+		host, p, err := net.SplitHostPort(addr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		self := false
+
+		if p == port {
+			ips, err := d.lookupIPs(ctx, host)
+
+			if err != nil {
+				return nil, err
+			}
+
+			for _, ip := range ips {
+				self = self || local[ip]
+			}
+		}
+
+		if !self {
+			resolved[addr] = true
+		}
+	}
+
+	return resolved, nil
+}
+
+// local returns the IP addresses of the local node, those of RPCAddr's host
+// and of the local interfaces, and its RPC port.
+func (d *DNS) local(ctx context.Context) (map[string]bool, string, error) {
+	host, port, err := net.SplitHostPort(d.RPCAddr)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	local := make(map[string]bool)
+
+	if host != "" {
+		ips, err := d.lookupIPs(ctx, host)
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		for _, ip := range ips {
+			local[ip] = true
+		}
+	}
+
+	addrs, err := d.interfaceAddrs()
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			local[ipNet.IP.String()] = true
+		}
+	}
+
+	return local, port, nil
+}
+
+// lookupIPs returns the IP addresses of host, which may be an IP address
+// itself, in their canonical form.
+func (d *DNS) lookupIPs(ctx context.Context, host string) ([]string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{ip.String()}, nil
+	}
+
+	hosts, err := d.Resolver.LookupHost(ctx, host)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make([]string, 0, len(hosts))
+
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			ips = append(ips, ip.String())
+		}
+	}
+
+	return ips, nil
+}
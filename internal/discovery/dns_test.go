@@ -0,0 +1,211 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// resolver is a Resolver answering from records that tests can change.
+type resolver struct {
+	mu    sync.Mutex
+	srv   []*net.SRV
+	hosts []string
+	// names answers host lookups of other names than the one polled.
+	names map[string][]string
+	err   error
+}
+
+func (r *resolver) LookupSRV(
+	ctx context.Context,
+	service, proto, name string,
+) (string, []*net.SRV, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return name, r.srv, r.err
+}
+
+func (r *resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if addrs, ok := r.names[host]; ok {
+		return addrs, nil
+	}
+
+	return r.hosts, r.err
+}
+
+// interfaceAddrs returns a function listing ips as the local interfaces'
+// addresses.
+func interfaceAddrs(ips ...string) func() ([]net.Addr, error) {
+	return func() ([]net.Addr, error) {
+		addrs := make([]net.Addr, 0, len(ips))
+
+		for _, ip := range ips {
+			addrs = append(addrs, &net.IPNet{IP: net.ParseIP(ip)})
+		}
+
+		return addrs, nil
+	}
+}
+
+func (r *resolver) set(fn func(r *resolver)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fn(r)
+}
+
+// TestDNS tests that the DNS provider joins the servers listed by SRV
+// records, leaves those no longer listed, and keeps its servers when lookups
+// fail.
+func TestDNS(t *testing.T) {
+	h := &handler{
+		joins:  make(chan map[string]string, 3),
+		leaves: make(chan string, 3),
+	}
+
+	r := &resolver{
+		srv: []*net.SRV{
+			{Target: "127.0.0.1.", Port: 8400},
+			{Target: "127.0.0.1.", Port: 8401},
+			{Target: "127.0.0.1.", Port: 8402},
+		},
+	}
+
+	d, err := NewDNS(h, DNSConfig{
+		NodeName:   "0",
+		RPCAddr:    "127.0.0.1:8400",
+		Name:       "_prolog._tcp.prolog.local",
+		LeaderAddr: "127.0.0.1:8401",
+		Interval:   50 * time.Millisecond,
+		Resolver:   r,
+	})
+	require.NoError(t, err)
+	defer d.Leave()
+
+	servers, err := d.GetServers()
+	require.NoError(t, err)
+	require.Equal(t, []*api.Server{
+		{Id: "0", RpcAddr: "127.0.0.1:8400"},
+		{Id: "127.0.0.1:8401", RpcAddr: "127.0.0.1:8401", IsLeader: true},
+		{Id: "127.0.0.1:8402", RpcAddr: "127.0.0.1:8402"},
+	}, servers)
+	require.Len(t, h.joins, 2)
+
+	r.set(func(r *resolver) {
+		r.err = errors.New("lookup failed")
+	})
+
+	time.Sleep(200 * time.Millisecond)
+
+	servers, err = d.GetServers()
+	require.NoError(t, err)
+	require.Len(t, servers, 3)
+	require.Len(t, h.leaves, 0)
+
+	r.set(func(r *resolver) {
+		r.err = nil
+		r.srv = r.srv[:2]
+	})
+
+	require.Eventually(t, func() bool {
+		return len(h.leaves) == 1
+	}, 3*time.Second, 50*time.Millisecond)
+	require.Equal(t, "127.0.0.1:8402", <-h.leaves)
+
+	servers, err = d.GetServers()
+	require.NoError(t, err)
+	require.Len(t, servers, 2)
+}
+
+// TestDNSHosts tests that names without an underscore are looked up as
+// A and AAAA records, and that the first lookup must succeed.
+func TestDNSHosts(t *testing.T) {
+	h := &handler{
+		joins: make(chan map[string]string, 3),
+	}
+
+	r := &resolver{
+		hosts: []string{"10.0.0.1", "10.0.0.2", "::1"},
+	}
+
+	config := DNSConfig{
+		NodeName: "0",
+		RPCAddr:  "10.0.0.1:8400",
+		Name:     "prolog.local",
+		Port:     8400,
+		Resolver: r,
+	}
+
+	d, err := newDNS(h, config, interfaceAddrs())
+	require.NoError(t, err)
+	require.NoError(t, d.Leave())
+
+	require.Len(t, h.joins, 2)
+	require.ElementsMatch(t, []string{"10.0.0.2:8400", "[::1]:8400"}, []string{
+		(<-h.joins)["addr"],
+		(<-h.joins)["addr"],
+	})
+
+	r.set(func(r *resolver) {
+		r.err = errors.New("lookup failed")
+	})
+
+	_, err = NewDNS(h, config)
+	require.Error(t, err)
+}
+
+// TestDNSSelf tests that the DNS provider recognizes the local node when it
+// is listed by a host name resolving to one of its interfaces' addresses,
+// and joins the leader with the leader tag.
+func TestDNSSelf(t *testing.T) {
+	h := &tagsHandler{
+		handler: &handler{joins: make(chan map[string]string, 3)},
+		tags:    make(chan map[string]string, 3),
+	}
+
+	r := &resolver{
+		srv: []*net.SRV{
+			{Target: "node-0.prolog.local.", Port: 8400},
+			{Target: "node-1.prolog.local.", Port: 8400},
+			{Target: "node-2.prolog.local.", Port: 8400},
+		},
+		names: map[string][]string{
+			"node-0.prolog.local": {"10.0.0.1"},
+			"node-1.prolog.local": {"10.0.0.2"},
+			"node-2.prolog.local": {"10.0.0.3"},
+		},
+	}
+
+	d, err := newDNS(h, DNSConfig{
+		NodeName:   "0",
+		RPCAddr:    "0.0.0.0:8400",
+		Name:       "_prolog._tcp.prolog.local",
+		LeaderAddr: "node-1.prolog.local:8400",
+		Resolver:   r,
+	}, interfaceAddrs("127.0.0.1", "10.0.0.1"))
+	require.NoError(t, err)
+	require.NoError(t, d.Leave())
+
+	require.Len(t, h.joins, 2)
+
+	joined := make(map[string]map[string]string)
+
+	for range 2 {
+		joined[(<-h.joins)["addr"]] = <-h.tags
+	}
+
+	require.Equal(t, map[string]map[string]string{
+		"node-1.prolog.local:8400": {"leader": "true"},
+		"node-2.prolog.local:8400": nil,
+	}, joined)
+}
@@ -0,0 +1,139 @@
+package discovery
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/hashicorp/memberlist"
+	"github.com/hashicorp/serf/serf"
+)
+
+// setupKeyring sets up gossip encryption in the serf config from the
+// membership's KeyringFile and EncryptKey. Keys in an existing keyring file
+// take precedence, the first being the primary key, as the file records
+// rotations made with the KeyManager. Otherwise the keyring holds just the
+// EncryptKey, and is written to the KeyringFile if one is configured.
+func (m *MemberShip) setupKeyring(config *serf.Config) error {
+	var keys []string
+
+	if m.KeyringFile != "" {
+		b, err := os.ReadFile(m.KeyringFile)
+
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(b, &keys); err != nil {
+				return fmt.Errorf("%s: %w", m.KeyringFile, err)
+			}
+
+			if len(keys) == 0 {
+				return fmt.Errorf("%s: keyring is empty", m.KeyringFile)
+			}
+		case !errors.Is(err, os.ErrNotExist):
+			return err
+		}
+
+		config.KeyringFile = m.KeyringFile
+	}
+
+	if len(keys) == 0 {
+		if m.EncryptKey == "" {
+			return nil
+		}
+
+		keys = []string{m.EncryptKey}
+
+		if m.KeyringFile != "" {
+			b, err := json.Marshal(keys)
+
+			if err != nil {
+				return err
+			}
+
+			if err := os.WriteFile(m.KeyringFile, b, 0600); err != nil {
+				return err
+			}
+		}
+	}
+
+	decoded := make([][]byte, len(keys))
+
+	for i, key := range keys {
+		b, err := base64.StdEncoding.DecodeString(key)
+
+		if err != nil {
+			return fmt.Errorf("invalid gossip key: %w", err)
+		}
+
+		decoded[i] = b
+	}
+
+	keyring, err := memberlist.NewKeyring(decoded, decoded[0])
+
+	if err != nil {
+		return err
+	}
+
+	config.MemberlistConfig.Keyring = keyring
+
+	return nil
+}
+
+// InstallKey installs the base64 encoded gossip encryption key on every
+// member, without using it to encrypt messages until UseKey is called. The
+// response holds the messages from members that failed.
+func (m *MemberShip) InstallKey(key string) (*api.GossipKeysResponse, error) {
+	return keysResponse(m.serf.KeyManager().InstallKey(key))
+}
+
+// UseKey makes the installed key the primary key encrypting gossip on every
+// member.
+func (m *MemberShip) UseKey(key string) (*api.GossipKeysResponse, error) {
+	return keysResponse(m.serf.KeyManager().UseKey(key))
+}
+
+// RemoveKey removes the key from every member. The primary key cannot be
+// removed.
+func (m *MemberShip) RemoveKey(key string) (*api.GossipKeysResponse, error) {
+	return keysResponse(m.serf.KeyManager().RemoveKey(key))
+}
+
+// ListKeys returns the keys installed on the members and how many members
+// have each installed.
+func (m *MemberShip) ListKeys() (*api.GossipKeysResponse, error) {
+	return keysResponse(m.serf.KeyManager().ListKeys())
+}
+
+// keysResponse converts a KeyManager response. Failures reported by some
+// members are returned in the response's messages rather than as an error,
+// so that callers can see which members need attention.
+func keysResponse(resp *serf.KeyResponse, err error) (*api.GossipKeysResponse, error) {
+	if resp == nil || resp.NumResp == 0 && resp.NumErr == 0 {
+		if err == nil {
+			err = errors.New("no members responded")
+		}
+		return nil, err
+	}
+
+	res := &api.GossipKeysResponse{
+		NumNodes:     int32(resp.NumNodes),
+		NumResponses: int32(resp.NumResp),
+		NumErrors:    int32(resp.NumErr),
+		Messages:     resp.Messages,
+		Keys:         make(map[string]int32, len(resp.Keys)),
+		PrimaryKeys:  make(map[string]int32, len(resp.PrimaryKeys)),
+	}
+
+	for key, n := range resp.Keys {
+		res.Keys[key] = int32(n)
+	}
+
+	for key, n := range resp.PrimaryKeys {
+		res.PrimaryKeys[key] = int32(n)
+	}
+
+	return res, nil
+}
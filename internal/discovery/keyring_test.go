@@ -0,0 +1,182 @@
+package discovery
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// TestEncryption tests that only members with the cluster's gossip key can
+// join.
+func TestEncryption(t *testing.T) {
+	dir := t.TempDir()
+	key1, key2 := newKey(t), newKey(t)
+
+	m, handler, err := setupMemberWithConfig(t, nil, func(c *Config) {
+		c.EncryptKey = key1
+		c.KeyringFile = filepath.Join(dir, "0.keyring")
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{key1}, readKeyring(t, filepath.Join(dir, "0.keyring")))
+
+	for name, key := range map[string]string{
+		"unencrypted": "",
+		"wrong key":   key2,
+	} {
+		_, _, err = setupMemberWithConfig(t, m, func(c *Config) {
+			c.EncryptKey = key
+		})
+		require.Error(t, err, name)
+	}
+
+	_, _, err = setupMemberWithConfig(t, m, func(c *Config) {
+		c.EncryptKey = key1
+	})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(handler.joins) == 1
+	}, 3*time.Second, 250*time.Millisecond)
+}
+
+// TestKeyRotation tests that keys installed, used and removed with the
+// KeyManager change every member's keyring and are saved to the keyring
+// files. Each step runs on a new cluster, as memberlist's keyring does not
+// synchronize back to back changes on the same member.
+func TestKeyRotation(t *testing.T) {
+	key1, key2 := newKey(t), newKey(t)
+
+	for _, tc := range []struct {
+		name    string
+		keyring []string
+		rotate  func(*MemberShip) (*api.GossipKeysResponse, error)
+		keys    map[string]int32
+		primary map[string]int32
+	}{
+		{
+			name:    "install",
+			keyring: []string{key1},
+			rotate: func(m *MemberShip) (*api.GossipKeysResponse, error) {
+				return m.InstallKey(key2)
+			},
+			keys:    map[string]int32{key1: 2, key2: 2},
+			primary: map[string]int32{key1: 2},
+		},
+		{
+			name:    "use",
+			keyring: []string{key1, key2},
+			rotate: func(m *MemberShip) (*api.GossipKeysResponse, error) {
+				return m.UseKey(key2)
+			},
+			keys:    map[string]int32{key1: 2, key2: 2},
+			primary: map[string]int32{key2: 2},
+		},
+		{
+			name:    "remove",
+			keyring: []string{key1, key2},
+			rotate: func(m *MemberShip) (*api.GossipKeysResponse, error) {
+				// The primary key cannot be removed.
+				res, err := m.RemoveKey(key1)
+				require.NoError(t, err)
+				require.EqualValues(t, 2, res.NumErrors)
+				require.Len(t, res.Messages, 2)
+
+				return m.RemoveKey(key2)
+			},
+			keys:    map[string]int32{key1: 2},
+			primary: map[string]int32{key1: 2},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			var (
+				m     []*MemberShip
+				first *handler
+				files []string
+			)
+
+			for i := 0; i < 2; i++ {
+				file := filepath.Join(dir, fmt.Sprintf("%d.keyring", i))
+				writeKeyring(t, file, tc.keyring)
+				files = append(files, file)
+
+				var (
+					h   *handler
+					err error
+				)
+
+				// The keyring file takes precedence over the key, so that
+				// restarted members keep using rotated keys.
+				m, h, err = setupMemberWithConfig(t, m, func(c *Config) {
+					c.EncryptKey = newKey(t)
+					c.KeyringFile = file
+				})
+				require.NoError(t, err)
+
+				if i == 0 {
+					first = h
+				}
+			}
+
+			require.Eventually(t, func() bool {
+				return len(first.joins) == 1
+			}, 3*time.Second, 250*time.Millisecond)
+
+			res, err := tc.rotate(m[1])
+			require.NoError(t, err)
+			require.EqualValues(t, 0, res.NumErrors)
+			require.EqualValues(t, 2, res.NumResponses)
+
+			res, err = m[0].ListKeys()
+			require.NoError(t, err)
+			require.Equal(t, tc.keys, res.Keys)
+			require.Equal(t, tc.primary, res.PrimaryKeys)
+
+			for _, file := range files {
+				keyring := readKeyring(t, file)
+				require.Len(t, keyring, len(tc.keys))
+				require.Contains(t, tc.primary, keyring[0])
+			}
+		})
+	}
+}
+
+// newKey returns a new base64 encoded gossip encryption key.
+func newKey(t *testing.T) string {
+	t.Helper()
+
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func writeKeyring(t *testing.T, file string, keys []string) {
+	t.Helper()
+
+	b, err := json.Marshal(keys)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(file, b, 0600))
+}
+
+func readKeyring(t *testing.T, file string) []string {
+	t.Helper()
+
+	b, err := os.ReadFile(file)
+	require.NoError(t, err)
+
+	var keys []string
+	require.NoError(t, json.Unmarshal(b, &keys))
+
+	return keys
+}
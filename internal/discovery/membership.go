@@ -1,8 +1,11 @@
 package discovery
 
 import (
+	"fmt"
 	"net"
+	"sync"
 
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
 	"github.com/hashicorp/serf/serf"
 	"go.uber.org/zap"
 )
@@ -12,19 +15,89 @@ type Handler interface {
 	Leave(name string) error
 }
 
+// TagsHandler is a Handler that is also given the tags of joining members,
+// such as their zone, so that it can treat members differently by placement.
+// Providers call JoinWithTags in place of Join on handlers implementing it.
+type TagsHandler interface {
+	Handler
+	JoinWithTags(name, addr string, tags map[string]string) error
+}
+
+// UpdateHandler is a Handler that is told when the tags of a member that has
+// joined change, such as its rpc_addr or zone. MemberShip calls Update in
+// place of leaving and joining the member again on handlers implementing it.
+type UpdateHandler interface {
+	Handler
+	Update(name, addr string, tags map[string]string) error
+}
+
+// Tags describing where a node runs and what it runs, set from the agent's
+// config and published to the other members.
+const (
+	TagZone    = "zone"
+	TagRack    = "rack"
+	TagRole    = "role"
+	TagVersion = "version"
+	// TagDraining is set to "true" on nodes that are draining before they
+	// leave, so that clients stop sending them requests.
+	TagDraining = "draining"
+)
+
+// handOffEvent is the serf user event naming the member a leader hands its
+// leadership to.
+const handOffEvent = "prolog-hand-off"
+
+// Provider discovers the members of the cluster, calling its Handler's Join
+// and Leave as members are found and lost. MemberShip discovers them by Serf
+// gossip, Static from a fixed list, and DNS by polling DNS records.
+type Provider interface {
+	// GetServers returns the cluster's servers as currently known.
+	GetServers() ([]*api.Server, error)
+	// Leave stops the local node's discovery of the cluster.
+	Leave() error
+}
+
+var (
+	_ Provider = (*MemberShip)(nil)
+	_ Provider = (*Static)(nil)
+	_ Provider = (*DNS)(nil)
+)
+
 type Config struct {
 	NodeName       string
 	BindAddr       string
 	Tags           map[string]string
 	StartJoinAddrs []string
+	// EncryptKey is the base64 encoded 16, 24 or 32 byte AES key encrypting
+	// gossip between members. Members without the key cannot join. Gossip is
+	// not encrypted if it and KeyringFile are empty.
+	EncryptKey string
+	// KeyringFile holds the gossip encryption keys as a JSON array of base64
+	// encoded keys, the first being the primary key. It is created with the
+	// EncryptKey if it does not exist, and rewritten as keys are installed,
+	// used and removed, so that rotations survive restarts.
+	KeyringFile string
+	// RequiredTags lists the tags every member must carry, in addition to
+	// rpc_addr. Members without them are refused when joining and are not
+	// passed to the Handler.
+	RequiredTags []string
+	// Registry handles the user events and queries sent across the cluster.
+	// A new one is used if nil.
+	Registry *Registry
 }
 
 type MemberShip struct {
 	Config
+	// tagsMu guards Tags, which change as the node drains and leadership is
+	// handed off.
+	tagsMu  sync.Mutex
 	handler Handler
 	serf    *serf.Serf
 	events  chan serf.Event
 	logger  *zap.Logger
+	// members holds the rpc_addr of the members that have joined the
+	// handler, by name. It is only used by the event handler.
+	members map[string]string
 }
 
 // New returns a new MemberShip for the given handler and config. It sets up a
@@ -36,8 +109,15 @@ func New(hander Handler, config Config) (*MemberShip, error) {
 		Config:  config,
 		handler: hander,
 		logger:  zap.L().Named("membership"),
+		members: make(map[string]string),
+	}
+
+	if c.Registry == nil {
+		c.Registry = &Registry{}
 	}
 
+	HandleEvent(c.Registry, handOffEvent, c.takeLeadership)
+
 	if err := c.setupSerf(); err != nil {
 		return nil, err
 	}
@@ -52,6 +132,76 @@ func (m *MemberShip) Members() []serf.Member {
 	return m.serf.Members()
 }
 
+// GetServers returns the alive members of the cluster as api.Server values,
+// using each member's rpc_addr tag as its RPC address. A member is reported
+// as the leader when it carries the leader tag set to "true". Each server
+// carries all of its member's tags.
+func (m *MemberShip) GetServers() ([]*api.Server, error) {
+	var servers []*api.Server
+
+	for _, member := range m.serf.Members() {
+		if member.Status != serf.StatusAlive {
+			continue
+		}
+
+		servers = append(servers, &api.Server{
+			Id:       member.Name,
+			RpcAddr:  member.Tags["rpc_addr"],
+			IsLeader: member.Tags["leader"] == "true",
+			Tags:     member.Tags,
+		})
+	}
+
+	return servers, nil
+}
+
+// SetTag sets the local member's tag to value, or removes it if value is
+// empty, and gossips the change to the cluster.
+func (m *MemberShip) SetTag(key, value string) error {
+	m.tagsMu.Lock()
+	defer m.tagsMu.Unlock()
+
+	tags := make(map[string]string, len(m.Tags)+1)
+
+	for k, v := range m.Tags {
+		tags[k] = v
+	}
+
+	if value == "" {
+		delete(tags, key)
+	} else {
+		tags[key] = value
+	}
+
+	if err := m.serf.SetTags(tags); err != nil {
+		return err
+	}
+
+	m.Tags = tags
+
+	return nil
+}
+
+// HandOff hands the local member's leadership to the named member, removing
+// its own leader tag before asking the member to set its own.
+func (m *MemberShip) HandOff(name string) error {
+	if err := m.SetTag("leader", ""); err != nil {
+		return err
+	}
+
+	return m.SendEvent(handOffEvent, name)
+}
+
+// takeLeadership sets the leader tag of the local member if it is the
+// member named by a hand off.
+func (m *MemberShip) takeLeadership(name string) error {
+	if name != m.NodeName {
+		return nil
+	}
+
+	return m.SetTag("leader", "true")
+}
+
 // Leave gracefully exits the current node from the cluster.
 // It delegates the leave operation to the underlying Serf instance.
 // Returns any error encountered during the leave process.
@@ -83,6 +233,19 @@ func (m *MemberShip) setupSerf() (err error) {
 	config.Tags = m.Tags
 
 	config.NodeName = m.NodeName
+	config.Merge = &memberValidator{m}
+
+	if err := m.validateMember(serf.Member{
+		Name: m.NodeName,
+		Tags: m.Tags,
+	}); err != nil {
+		return err
+	}
+
+	if err := m.setupKeyring(config); err != nil {
+		return err
+	}
+
 	m.serf, err = serf.Create(config)
 
 	if err != nil {
@@ -103,54 +266,167 @@ func (m *MemberShip) setupSerf() (err error) {
 }
 
 // eventHandler listens for events on the serf event channel and calls the
-// corresponding handler methods when a member joins or leaves the cluster.
+// corresponding handler methods when a member joins, changes its tags,
+// leaves, fails or is reaped. User events and queries are passed to the
+// Registry.
 func (m *MemberShip) eventHandler() {
 	for e := range m.events {
 		switch e.EventType() {
-		case serf.EventMemberJoin:
+		case serf.EventMemberJoin, serf.EventMemberUpdate:
 			for _, member := range e.(serf.MemberEvent).Members {
 				if m.isLocal(member) {
 					continue
 				}
 
-				m.handleJoin(member)
+				m.handleMember(member)
 			}
 
-		case serf.EventMemberLeave, serf.EventMemberFailed:
+		case serf.EventMemberLeave, serf.EventMemberFailed, serf.EventMemberReap:
 
 			for _, member := range e.(serf.MemberEvent).Members {
+				// The local member leaving is not passed to the handler,
+				// but events keep being handled until serf shuts down.
 				if m.isLocal(member) {
-					return
+					continue
 				}
 
 				m.handleLeave(member)
 			}
+
+		case serf.EventUser:
+			event := e.(serf.UserEvent)
+
+			if err := m.Registry.Event(event.Name, event.Payload); err != nil {
+				m.logger.Error(
+					"failed to handle event",
+					zap.Error(err),
+					zap.String("event", event.Name),
+				)
+			}
+
+		case serf.EventQuery:
+			// Queries are answered concurrently so that slow handlers do
+			// not hold up membership changes.
+			go m.answer(e.(*serf.Query))
 		}
 
 	}
 }
 
+// validateMember returns an error unless the member carries a valid rpc_addr
+// tag and every tag in the config's RequiredTags.
+func (m *MemberShip) validateMember(member serf.Member) error {
+	if _, _, err := net.SplitHostPort(member.Tags["rpc_addr"]); err != nil {
+		return fmt.Errorf("member %s has an invalid rpc_addr tag: %w", member.Name, err)
+	}
+
+	for _, tag := range m.RequiredTags {
+		if member.Tags[tag] == "" {
+			return fmt.Errorf("member %s is missing the %s tag", member.Name, tag)
+		}
+	}
+
+	return nil
+}
+
+// memberValidator refuses members failing validateMember as they join, so
+// that their join fails and their tags are not gossiped to the cluster.
+type memberValidator struct {
+	membership *MemberShip
+}
+
+func (v *memberValidator) NotifyMerge(members []*serf.Member) error {
+	for _, member := range members {
+		// Members that have left are gossiped without their tags, and the
+		// local member was validated on creation.
+		if member.Status == serf.StatusLeft ||
+			member.Name == v.membership.NodeName {
+			continue
+		}
+
+		if err := v.membership.validateMember(*member); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // isLocal returns true if the given member is the local node, false otherwise.
 func (m *MemberShip) isLocal(member serf.Member) bool {
 	return m.serf.LocalMember().Name == member.Name
 }
 
-// handleJoin calls the Join method of the handler for the given member. If the
-// handler returns an error, it is logged at error level with the given message
-// and the name and rpc address of the given member.
-func (m *MemberShip) handleJoin(member serf.Member) {
-	if err := m.handler.Join(
-		member.Name,
-		member.Tags["rpc_addr"],
-	); err != nil {
-		m.logError(err, "failed to join", member)
+// handleMember passes a member that joined or changed its tags to the
+// handler. A member joins the handler the first time it is valid, and leaves
+// it if it stops being valid. Afterwards, an UpdateHandler is given every
+// change, while other handlers see the member leave and join again when its
+// rpc_addr changes, so that they dial its new address. Errors are logged at
+// error level with the name and rpc address of the member.
+func (m *MemberShip) handleMember(member serf.Member) {
+	addr, joined := m.members[member.Name]
+
+	if err := m.validateMember(member); err != nil {
+		m.logError(err, "refused member", member)
+
+		if joined {
+			m.handleLeave(member)
+		}
+
+		return
+	}
+
+	if h, ok := m.handler.(UpdateHandler); ok && joined {
+		if err := h.Update(
+			member.Name,
+			member.Tags["rpc_addr"],
+			member.Tags,
+		); err != nil {
+			m.logError(err, "failed to update", member)
+			return
+		}
+	} else {
+		if joined && addr != member.Tags["rpc_addr"] {
+			m.handleLeave(member)
+			joined = false
+		}
+
+		if !joined {
+			if err := join(
+				m.handler,
+				member.Name,
+				member.Tags["rpc_addr"],
+				member.Tags,
+			); err != nil {
+				m.logError(err, "failed to join", member)
+				return
+			}
+		}
 	}
+
+	m.members[member.Name] = member.Tags["rpc_addr"]
 }
 
-// handleLeave calls the Leave method of the handler for the given member.
-// If the handler returns an error, it is logged at error level with the given
-// message and the name and rpc address of the given member.
+// join joins the member to the handler, passing its tags along if the
+// handler is a TagsHandler.
+func join(h Handler, name, addr string, tags map[string]string) error {
+	if h, ok := h.(TagsHandler); ok {
+		return h.JoinWithTags(name, addr, tags)
+	}
+
+	return h.Join(name, addr)
+}
+
+// handleLeave calls the Leave method of the handler for the given member, if
+// it had joined the handler. If the handler returns an error, it is logged at
+// error level with the given message and the name and rpc address of the
+// given member.
 func (m *MemberShip) handleLeave(member serf.Member) {
+	if _, ok := m.members[member.Name]; !ok {
+		return
+	}
+
+	delete(m.members, member.Name)
 
 	if err := m.handler.Leave(
 		member.Name,
@@ -37,6 +37,30 @@ func (h *handler) Leave(id string) error {
 	return nil
 }
 
+// tagsHandler is a handler that also records the tags of joining members.
+type tagsHandler struct {
+	*handler
+	tags chan map[string]string
+}
+
+func (h *tagsHandler) JoinWithTags(id, addr string, tags map[string]string) error {
+	h.tags <- tags
+
+	return h.Join(id, addr)
+}
+
+// updateHandler is a handler that also records the updates of members.
+type updateHandler struct {
+	*handler
+	updates chan map[string]string
+}
+
+func (h *updateHandler) Update(id, addr string, tags map[string]string) error {
+	h.updates <- tags
+
+	return nil
+}
+
 // TestMembership exercises the MemberShip type. It creates a cluster of 3 nodes,
 // verifies that each node sees the other two, and then verifies that a node
 // leaving the cluster is properly removed from the other two nodes' membership
@@ -66,10 +90,228 @@ func TestMembership(t *testing.T) {
 	require.Equal(t, fmt.Sprintf("%d", 2), <-handler.leaves)
 }
 
+// TestRequiredTags tests that members without a valid rpc_addr tag or a
+// required tag are refused, so that they are neither listed as members nor
+// passed to the handler.
+func TestRequiredTags(t *testing.T) {
+	required := func(zone string) func(*Config) {
+		return func(c *Config) {
+			c.RequiredTags = []string{"zone"}
+
+			if zone != "" {
+				c.Tags["zone"] = zone
+			}
+		}
+	}
+
+	m, handler, err := setupMemberWithConfig(t, nil, required("a"))
+	require.NoError(t, err)
+
+	// Members check their own tags on creation.
+	_, _, err = setupMemberWithConfig(t, m, func(c *Config) {
+		required("b")(c)
+		c.Tags["rpc_addr"] = "not-an-address"
+	})
+	require.Error(t, err)
+
+	// A member that does not require the tag itself is refused by the
+	// cluster, although its join succeeds, as the cluster sends it its state
+	// before validating the member's.
+	m, _, err = setupMemberWithConfig(t, m, nil)
+	require.NoError(t, err)
+
+	m, _, err = setupMemberWithConfig(t, m, required("b"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(handler.joins) == 1 && len(m[0].Members()) == 2
+	}, 3*time.Second, 250*time.Millisecond)
+
+	require.Equal(t, "2", (<-handler.joins)["id"])
+
+	time.Sleep(time.Second)
+
+	require.Len(t, m[0].Members(), 2)
+	require.Empty(t, handler.joins)
+}
+
+// TestTags tests that members' tags are passed to a TagsHandler as they
+// join and returned with the servers.
+func TestTags(t *testing.T) {
+	port, err := dynaport()
+	require.NoError(t, err)
+
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+
+	h := &tagsHandler{
+		handler: &handler{joins: make(chan map[string]string, 1)},
+		tags:    make(chan map[string]string, 1),
+	}
+
+	first, err := New(h, Config{
+		NodeName: "0",
+		BindAddr: addr,
+		Tags:     map[string]string{"rpc_addr": addr, TagZone: "a"},
+	})
+	require.NoError(t, err)
+
+	m, _, err := setupMemberWithConfig(t, []*MemberShip{first}, func(c *Config) {
+		c.Tags[TagZone] = "b"
+		c.Tags[TagRole] = "replica"
+	})
+	require.NoError(t, err)
+
+	select {
+	case tags := <-h.tags:
+		require.Equal(t, "b", tags[TagZone])
+		require.Equal(t, "replica", tags[TagRole])
+	case <-time.After(3 * time.Second):
+		t.Fatal("member did not join")
+	}
+
+	servers, err := m[1].GetServers()
+	require.NoError(t, err)
+	require.Len(t, servers, 2)
+
+	for _, server := range servers {
+		require.Equal(t, map[string]string{"0": "a", "1": "b"}[server.Id], server.Tags[TagZone])
+	}
+}
+
+// TestMemberUpdate tests that a member changing its rpc_addr leaves and joins
+// a handler again with its new address, that invalid tag changes are
+// refused, and that an UpdateHandler is given every change instead.
+func TestMemberUpdate(t *testing.T) {
+	m, first := setupMember(t, nil)
+	m, _ = setupMember(t, m)
+
+	require.Equal(t, "1", receive(t, first.joins)["id"])
+
+	port, err := dynaport()
+	require.NoError(t, err)
+
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	require.NoError(t, m[1].SetTag("rpc_addr", addr))
+
+	require.Equal(t, "1", receive(t, first.leaves))
+	require.Equal(t, addr, receive(t, first.joins)["addr"])
+
+	// Invalid tags are refused by the cluster like invalid members, so the
+	// member keeps its previous address.
+	require.NoError(t, m[1].SetTag("rpc_addr", "not-an-address"))
+
+	time.Sleep(time.Second)
+
+	require.Empty(t, first.leaves)
+	require.Equal(t, addr, member(t, m[0], "1").Tags["rpc_addr"])
+
+	// With an UpdateHandler, members are updated in place.
+	port, err = dynaport()
+	require.NoError(t, err)
+
+	addr = fmt.Sprintf("127.0.0.1:%d", port)
+
+	h := &updateHandler{
+		handler: &handler{
+			joins:  make(chan map[string]string, 3),
+			leaves: make(chan string, 3),
+		},
+		updates: make(chan map[string]string, 3),
+	}
+
+	leader, err := New(h, Config{
+		NodeName: "0",
+		BindAddr: addr,
+		Tags:     map[string]string{"rpc_addr": addr},
+	})
+	require.NoError(t, err)
+
+	m, _, err = setupMemberWithConfig(t, []*MemberShip{leader}, nil)
+	require.NoError(t, err)
+
+	require.Equal(t, "1", receive(t, h.joins)["id"])
+
+	require.NoError(t, m[1].SetTag(TagZone, "b"))
+	require.Equal(t, "b", receive(t, h.updates)[TagZone])
+
+	require.NoError(t, m[1].SetTag("rpc_addr", m[0].BindAddr))
+	require.Equal(t, m[0].BindAddr, receive(t, h.updates)["rpc_addr"])
+
+	require.Empty(t, h.joins)
+	require.Empty(t, h.leaves)
+}
+
+// TestMemberFailure tests that a member that stops without leaving is
+// detected as failed and leaves the handler.
+func TestMemberFailure(t *testing.T) {
+	m, first := setupMember(t, nil)
+	m, _ = setupMember(t, m)
+
+	require.Equal(t, "1", receive(t, first.joins)["id"])
+
+	require.NoError(t, m[1].serf.Shutdown())
+
+	select {
+	case id := <-first.leaves:
+		require.Equal(t, "1", id)
+	case <-time.After(15 * time.Second):
+		t.Fatal("failed member did not leave")
+	}
+
+	require.Equal(t, serf.StatusFailed, member(t, m[0], "1").Status)
+}
+
+// member returns the named member as seen by m.
+func member(t *testing.T, m *MemberShip, name string) serf.Member {
+	t.Helper()
+
+	for _, member := range m.Members() {
+		if member.Name == name {
+			return member
+		}
+	}
+
+	t.Fatalf("no member %s", name)
+
+	return serf.Member{}
+}
+
+// receive returns the next value sent on ch, failing the test if none is
+// sent within a few seconds.
+func receive[T any](t *testing.T, ch chan T) T {
+	t.Helper()
+
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing received")
+	}
+
+	var v T
+
+	return v
+}
+
 // setupMember returns a new MemberShip and a handler that will be passed to it.
 // It also takes a slice of existing MemberShips and will have the new one join
 // the cluster if not empty. It returns the new MemberShip and the handler.
 func setupMember(t *testing.T, members []*MemberShip) ([]*MemberShip, *handler) {
+	members, h, err := setupMemberWithConfig(t, members, nil)
+
+	require.NoError(t, err)
+
+	return members, h
+}
+
+// setupMemberWithConfig works like setupMember, letting fn change the new
+// member's config before it is created, and returns the error creating it
+// instead of failing the test.
+func setupMemberWithConfig(
+	t *testing.T,
+	members []*MemberShip,
+	fn func(*Config),
+) ([]*MemberShip, *handler, error) {
 
 	id := len(members)
 
@@ -100,13 +342,19 @@ func setupMember(t *testing.T, members []*MemberShip) ([]*MemberShip, *handler)
 		}
 	}
 
+	if fn != nil {
+		fn(&c)
+	}
+
 	m, err := New(h, c)
 
-	require.NoError(t, err)
+	if err != nil {
+		return members, h, err
+	}
 
 	members = append(members, m)
 
-	return members, h
+	return members, h, nil
 
 }
 
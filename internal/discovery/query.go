@@ -0,0 +1,197 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/serf/serf"
+	"go.uber.org/zap"
+)
+
+// Registry holds the handlers of the cluster-wide user events and queries
+// members send each other, by name. Payloads and responses are JSON
+// encoded, so that handlers are registered with their types by HandleEvent
+// and HandleQuery.
+type Registry struct {
+	mu      sync.RWMutex
+	events  map[string]func([]byte) error
+	queries map[string]func([]byte) ([]byte, error)
+}
+
+// HandleEvent registers fn to handle the named user event on every member,
+// with the event's payload decoded as P. Events are not answered, and are
+// delivered at most once to each member.
+func HandleEvent[P any](r *Registry, name string, fn func(P) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.events == nil {
+		r.events = make(map[string]func([]byte) error)
+	}
+
+	r.events[name] = func(payload []byte) error {
+		var p P
+
+		if err := decode(payload, &p); err != nil {
+			return err
+		}
+
+		return fn(p)
+	}
+}
+
+// HandleQuery registers fn to answer the named query on every member, with
+// the query's payload decoded as P and the response encoded from R.
+func HandleQuery[P, R any](r *Registry, name string, fn func(P) (R, error)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.queries == nil {
+		r.queries = make(map[string]func([]byte) ([]byte, error))
+	}
+
+	r.queries[name] = func(payload []byte) ([]byte, error) {
+		var p P
+
+		if err := decode(payload, &p); err != nil {
+			return nil, err
+		}
+
+		res, err := fn(p)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return json.Marshal(res)
+	}
+}
+
+// Event handles the named user event locally.
+func (r *Registry) Event(name string, payload []byte) error {
+	r.mu.RLock()
+	fn, ok := r.events[name]
+	r.mu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("unknown event %q", name)
+	}
+
+	return fn(payload)
+}
+
+// Query answers the named query locally.
+func (r *Registry) Query(name string, payload []byte) ([]byte, error) {
+	r.mu.RLock()
+	fn, ok := r.queries[name]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown query %q", name)
+	}
+
+	return fn(payload)
+}
+
+// decode decodes a JSON payload into v, leaving v unchanged if the payload
+// is empty.
+func decode(payload []byte, v any) error {
+	if len(payload) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(payload, v)
+}
+
+// QueryResult is a member's answer to a query: the JSON encoded response,
+// or the error its handler returned.
+type QueryResult struct {
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// SendEvent sends the named user event with its JSON encoded payload to
+// every member, including the local one.
+func (m *MemberShip) SendEvent(name string, payload any) error {
+	b, err := json.Marshal(payload)
+
+	if err != nil {
+		return err
+	}
+
+	return m.serf.UserEvent(name, b, false)
+}
+
+// Query sends the named query with its JSON encoded payload to every
+// member, including the local one, and collects their answers by member
+// name until every alive member has answered or the timeout passes. Serf's
+// default timeout, which grows with the size of the cluster, is used if
+// timeout is zero. Members that do not answer in time are missing from the
+// results. It also returns how many members were alive when it was sent.
+func (m *MemberShip) Query(
+	name string,
+	payload []byte,
+	timeout time.Duration,
+) (map[string]QueryResult, int, error) {
+	servers, err := m.GetServers()
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resp, err := m.serf.Query(name, payload, &serf.QueryParam{
+		Timeout: timeout,
+	})
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	results := make(map[string]QueryResult, len(servers))
+
+	for r := range resp.ResponseCh() {
+		var result QueryResult
+
+		if err := json.Unmarshal(r.Payload, &result); err != nil {
+			result = QueryResult{Error: fmt.Sprintf("invalid response: %s", err)}
+		}
+
+		results[r.From] = result
+
+		if len(results) == len(servers) {
+			resp.Close()
+		}
+	}
+
+	return results, len(servers), nil
+}
+
+// answer answers a query from the cluster with the registry's handler,
+// responding with the handler's error if it fails.
+func (m *MemberShip) answer(q *serf.Query) {
+	var result QueryResult
+
+	payload, err := m.Registry.Query(q.Name, q.Payload)
+
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Payload = payload
+	}
+
+	b, err := json.Marshal(result)
+
+	if err == nil {
+		err = q.Respond(b)
+	}
+
+	if err != nil {
+		m.logger.Error(
+			"failed to answer query",
+			zap.Error(err),
+			zap.String("query", q.Name),
+		)
+	}
+}
@@ -0,0 +1,83 @@
+package discovery
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestQuery tests that queries are answered by the registered handler of
+// every member with typed payloads and responses, that handler errors and
+// unknown queries are reported per member, and that user events reach every
+// member.
+func TestQuery(t *testing.T) {
+	type echo struct {
+		Value string `json:"value"`
+	}
+
+	events := make(chan string, 2)
+
+	var m []*MemberShip
+
+	for i := 0; i < 2; i++ {
+		registry := &Registry{}
+
+		HandleQuery(registry, "echo", func(req echo) (echo, error) {
+			if req.Value == "" {
+				return echo{}, errors.New("nothing to echo")
+			}
+
+			return req, nil
+		})
+
+		HandleEvent(registry, "note", func(note string) error {
+			events <- note
+			return nil
+		})
+
+		var err error
+
+		m, _, err = setupMemberWithConfig(t, m, func(c *Config) {
+			c.Registry = registry
+		})
+		require.NoError(t, err)
+	}
+
+	require.Eventually(t, func() bool {
+		return len(m[0].Members()) == 2
+	}, 3*time.Second, 250*time.Millisecond)
+
+	results, n, err := m[0].Query("echo", []byte(`{"value":"hello"}`), time.Second)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Len(t, results, 2)
+
+	for _, result := range results {
+		var res echo
+		require.Empty(t, result.Error)
+		require.NoError(t, json.Unmarshal(result.Payload, &res))
+		require.Equal(t, "hello", res.Value)
+	}
+
+	results, _, err = m[1].Query("echo", nil, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "nothing to echo", results["0"].Error)
+
+	results, _, err = m[1].Query("unknown", nil, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, `unknown query "unknown"`, results["1"].Error)
+
+	require.NoError(t, m[0].SendEvent("note", "hello"))
+
+	for i := 0; i < 2; i++ {
+		select {
+		case note := <-events:
+			require.Equal(t, "hello", note)
+		case <-time.After(3 * time.Second):
+			t.Fatal("event was not delivered")
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package discovery
+
+import (
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+)
+
+// Server is a server in a statically configured cluster.
+type Server struct {
+	Name    string
+	RPCAddr string
+	Leader  bool
+	// Tags describe the server, such as its zone, as serf tags do.
+	Tags map[string]string
+}
+
+type StaticConfig struct {
+	NodeName string
+	// Servers lists every server in the cluster, including the local node,
+	// which is recognized by its NodeName.
+	Servers []Server
+}
+
+// Static is a Provider for clusters whose servers are known in advance,
+// for environments where gossip is blocked.
+type Static struct {
+	StaticConfig
+	handler Handler
+}
+
+// NewStatic returns a Static provider for the config's servers, joining
+// every server other than the local node.
+func NewStatic(handler Handler, config StaticConfig) (*Static, error) {
+	s := &Static{
+		StaticConfig: config,
+		handler:      handler,
+	}
+
+	for _, server := range s.Servers {
+		if server.Name == s.NodeName {
+			continue
+		}
+
+		if err := join(
+			s.handler,
+			server.Name,
+			server.RPCAddr,
+			server.tags(),
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// GetServers returns the configured servers.
+func (s *Static) GetServers() ([]*api.Server, error) {
+	servers := make([]*api.Server, 0, len(s.Servers))
+
+	for _, server := range s.Servers {
+		servers = append(servers, &api.Server{
+			Id:       server.Name,
+			RpcAddr:  server.RPCAddr,
+			IsLeader: server.Leader,
+			Tags:     server.Tags,
+		})
+	}
+
+	return servers, nil
+}
+
+// tags returns the server's tags, with the leader tag set for the leader as
+// the leader's serf tags would have it.
+func (s Server) tags() map[string]string {
+	if !s.Leader {
+		return s.Tags
+	}
+
+	tags := make(map[string]string, len(s.Tags)+1)
+
+	for key, value := range s.Tags {
+		tags[key] = value
+	}
+
+	tags["leader"] = "true"
+
+	return tags
+}
+
+// Leave does nothing, as the list of servers does not change.
+func (s *Static) Leave() error {
+	return nil
+}
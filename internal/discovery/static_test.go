@@ -0,0 +1,42 @@
+package discovery
+
+import (
+	"testing"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// TestStatic tests that the static provider joins every configured server
+// other than the local node, and lists them all as the cluster's servers.
+func TestStatic(t *testing.T) {
+	h := &handler{
+		joins:  make(chan map[string]string, 3),
+		leaves: make(chan string, 3),
+	}
+
+	s, err := NewStatic(h, StaticConfig{
+		NodeName: "0",
+		Servers: []Server{
+			{Name: "0", RPCAddr: "127.0.0.1:8400", Leader: true},
+			{Name: "1", RPCAddr: "127.0.0.1:8401", Tags: map[string]string{TagZone: "b"}},
+			{Name: "2", RPCAddr: "127.0.0.1:8402"},
+		},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, h.joins, 2)
+	require.Equal(t, map[string]string{"id": "1", "addr": "127.0.0.1:8401"}, <-h.joins)
+	require.Equal(t, map[string]string{"id": "2", "addr": "127.0.0.1:8402"}, <-h.joins)
+
+	servers, err := s.GetServers()
+	require.NoError(t, err)
+	require.Equal(t, []*api.Server{
+		{Id: "0", RpcAddr: "127.0.0.1:8400", IsLeader: true},
+		{Id: "1", RpcAddr: "127.0.0.1:8401", Tags: map[string]string{TagZone: "b"}},
+		{Id: "2", RpcAddr: "127.0.0.1:8402"},
+	}, servers)
+
+	require.NoError(t, s.Leave())
+	require.Len(t, h.leaves, 0)
+}
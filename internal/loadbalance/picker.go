@@ -0,0 +1,92 @@
+package loadbalance
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+)
+
+type PickerBuilder struct{}
+
+var _ base.PickerBuilder = (*PickerBuilder)(nil)
+
+type Picker struct {
+	leader    balancer.SubConn
+	followers []balancer.SubConn
+	all       []balancer.SubConn
+	current   atomic.Uint64
+}
+
+var _ balancer.Picker = (*Picker)(nil)
+
+func init() {
+	balancer.Register(
+		base.NewBalancerBuilder(Name, &PickerBuilder{}, base.Config{}),
+	)
+}
+
+// Build returns a Picker for the ready SubConns in buildInfo. SubConns whose
+// address carries the is_leader attribute set to true are treated as the
+// leader; all others are followers. A new Picker is built every time the set
+// of ready SubConns changes.
+func (b *PickerBuilder) Build(buildInfo base.PickerBuildInfo) balancer.Picker {
+	p := &Picker{}
+
+	for sc, scInfo := range buildInfo.ReadySCs {
+		p.all = append(p.all, sc)
+
+		isLeader, _ := scInfo.Address.Attributes.Value(
+			isLeaderAttribute,
+		).(bool)
+
+		if isLeader {
+			p.leader = sc
+			continue
+		}
+
+		p.followers = append(p.followers, sc)
+	}
+
+	return p
+}
+
+// Pick selects the SubConn for an RPC. Produce calls go to the leader, or are
+// spread across every server when the cluster has no leader. Consume calls are
+// spread across the followers, falling back to the leader when there are none.
+// All other calls are spread across every server. It returns
+// balancer.ErrNoSubConnAvailable when there is nothing to pick.
+func (p *Picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+
+	var result balancer.PickResult
+
+	switch {
+	case strings.Contains(info.FullMethodName, "Produce") && p.leader != nil:
+		result.SubConn = p.leader
+	case strings.Contains(info.FullMethodName, "Consume") && len(p.followers) > 0:
+		result.SubConn = p.next(p.followers)
+	case strings.Contains(info.FullMethodName, "Consume") && p.leader != nil:
+		result.SubConn = p.leader
+	default:
+		result.SubConn = p.next(p.all)
+	}
+
+	if result.SubConn == nil {
+		return result, balancer.ErrNoSubConnAvailable
+	}
+
+	return result, nil
+}
+
+// next returns the next SubConn from subConns in round-robin order, or nil if
+// subConns is empty.
+func (p *Picker) next(subConns []balancer.SubConn) balancer.SubConn {
+	if len(subConns) == 0 {
+		return nil
+	}
+
+	cur := p.current.Add(1)
+
+	return subConns[cur%uint64(len(subConns))]
+}
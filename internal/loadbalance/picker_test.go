@@ -0,0 +1,133 @@
+package loadbalance
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+// TestPickerNoSubConnAvailable tests that a picker built without any ready
+// SubConns returns balancer.ErrNoSubConnAvailable for every method.
+func TestPickerNoSubConnAvailable(t *testing.T) {
+	picker := (&PickerBuilder{}).Build(base.PickerBuildInfo{})
+
+	for _, method := range []string{
+		"/log.v1.Log/Produce",
+		"/log.v1.Log/Consume",
+		"/log.v1.Log/GetServers",
+	} {
+		info := balancer.PickInfo{
+			FullMethodName: method,
+		}
+
+		result, err := picker.Pick(info)
+		require.Equal(t, balancer.ErrNoSubConnAvailable, err)
+		require.Nil(t, result.SubConn)
+	}
+}
+
+// TestPickerProducesToLeader tests that Produce calls are always sent to the
+// leader.
+func TestPickerProducesToLeader(t *testing.T) {
+	picker, subConns := setupTest(true)
+
+	info := balancer.PickInfo{
+		FullMethodName: "/log.v1.Log/Produce",
+	}
+
+	for i := 0; i < 5; i++ {
+		gotPick, err := picker.Pick(info)
+		require.NoError(t, err)
+		require.Equal(t, subConns[0], gotPick.SubConn)
+	}
+}
+
+// TestPickerConsumesFromFollowers tests that Consume calls are spread across
+// the followers in round-robin order and never sent to the leader.
+func TestPickerConsumesFromFollowers(t *testing.T) {
+	picker, subConns := setupTest(true)
+
+	info := balancer.PickInfo{
+		FullMethodName: "/log.v1.Log/Consume",
+	}
+
+	picks := map[balancer.SubConn]int{}
+
+	for i := 0; i < 4; i++ {
+		pick, err := picker.Pick(info)
+		require.NoError(t, err)
+		picks[pick.SubConn]++
+	}
+
+	require.Equal(t, 0, picks[subConns[0]])
+	require.Equal(t, 2, picks[subConns[1]])
+	require.Equal(t, 2, picks[subConns[2]])
+}
+
+// TestPickerWithoutLeader tests that Produce calls are spread across every
+// server when none of them is the leader.
+func TestPickerWithoutLeader(t *testing.T) {
+	picker, subConns := setupTest(false)
+
+	info := balancer.PickInfo{
+		FullMethodName: "/log.v1.Log/Produce",
+	}
+
+	picks := map[balancer.SubConn]int{}
+
+	for i := 0; i < 3; i++ {
+		pick, err := picker.Pick(info)
+		require.NoError(t, err)
+		picks[pick.SubConn]++
+	}
+
+	for _, sc := range subConns {
+		require.Equal(t, 1, picks[sc])
+	}
+}
+
+// setupTest builds a picker over three SubConns. When withLeader is true the
+// first SubConn is the leader and the others are followers.
+func setupTest(withLeader bool) (*Picker, []*subConn) {
+	var subConns []*subConn
+
+	buildInfo := base.PickerBuildInfo{
+		ReadySCs: make(map[balancer.SubConn]base.SubConnInfo),
+	}
+
+	for i := 0; i < 3; i++ {
+		sc := &subConn{}
+
+		addr := resolver.Address{
+			Attributes: attributes.New(
+				isLeaderAttribute,
+				withLeader && i == 0,
+			),
+		}
+
+		sc.UpdateAddresses([]resolver.Address{addr})
+		buildInfo.ReadySCs[sc] = base.SubConnInfo{Address: addr}
+		subConns = append(subConns, sc)
+	}
+
+	picker := (&PickerBuilder{}).Build(buildInfo).(*Picker)
+
+	return picker, subConns
+}
+
+type subConn struct {
+	balancer.SubConn
+	addrs []resolver.Address
+}
+
+// UpdateAddresses records the addresses used by the SubConn.
+func (s *subConn) UpdateAddresses(addrs []resolver.Address) {
+	s.addrs = addrs
+}
+
+// Connect is a no-op for the test SubConn.
+func (s *subConn) Connect() {}
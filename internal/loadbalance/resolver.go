@@ -0,0 +1,216 @@
+package loadbalance
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+const (
+	Name = "prolog"
+
+	isLeaderAttribute = "is_leader"
+)
+
+// RefreshInterval is how often a resolver polls the cluster for its servers
+// so that connections follow members joining and leaving.
+var RefreshInterval = 10 * time.Second
+
+// ResolveTimeout bounds how long a resolver waits for the cluster's servers.
+var ResolveTimeout = 5 * time.Second
+
+type Builder struct{}
+
+var _ resolver.Builder = (*Builder)(nil)
+
+type Resolver struct {
+	mu            sync.Mutex
+	clientConn    resolver.ClientConn
+	resolverConn  *grpc.ClientConn
+	serviceConfig *serviceconfig.ParseResult
+	logger        *zap.Logger
+	// ctx is cancelled when the resolver is closed.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// resolves counts the resolutions started, and resolved is the latest
+	// one applied, so that a slow resolution does not override a newer one.
+	resolves uint64
+	resolved uint64
+}
+
+var _ resolver.Resolver = (*Resolver)(nil)
+
+func init() {
+	resolver.Register(&Builder{})
+}
+
+// Build creates a Resolver for the given target. The target's endpoint is the
+// address of any server in the cluster; the resolver connects to it with the
+// channel's transport credentials, discovers the cluster's servers through the
+// GetServers RPC, and keeps refreshing them every RefreshInterval. The first
+// resolution runs in the background, so Build does not wait on the cluster.
+// The service config selects the prolog balancer so picks are made by the
+// Picker.
+func (b *Builder) Build(
+	target resolver.Target,
+	cc resolver.ClientConn,
+	opts resolver.BuildOptions,
+) (resolver.Resolver, error) {
+
+	r := &Resolver{
+		clientConn: cc,
+		logger:     zap.L().Named("resolver"),
+	}
+
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+
+	var dialOpts []grpc.DialOption
+
+	if opts.DialCreds != nil {
+		dialOpts = append(
+			dialOpts,
+			grpc.WithTransportCredentials(opts.DialCreds),
+		)
+	}
+
+	r.serviceConfig = r.clientConn.ParseServiceConfig(
+		fmt.Sprintf(`{"loadBalancingConfig":[{"%s":{}}]}`, Name),
+	)
+
+	var err error
+
+	r.resolverConn, err = grpc.NewClient(target.Endpoint(), dialOpts...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r.ResolveNow(resolver.ResolveNowOptions{})
+
+	go r.refresh()
+
+	return r, nil
+}
+
+// Scheme returns the scheme handled by the Builder, so targets of the form
+// prolog:///host:port are resolved by it.
+func (b *Builder) Scheme() string {
+	return Name
+}
+
+// ResolveNow re-resolves the cluster's servers in the background, so that
+// the client connection calling it is not blocked on the GetServers RPC.
+func (r *Resolver) ResolveNow(resolver.ResolveNowOptions) {
+	go r.resolve()
+}
+
+// resolve fetches the cluster's servers and updates the client connection
+// with their addresses, leaving out servers that are draining. Each address
+// carries an attribute telling the Picker whether it belongs to the leader.
+// The servers are fetched without holding the resolver's lock, waiting up to
+// ResolveTimeout, and are only applied if no later call has applied its own.
+// Errors are logged and reported to the client connection.
+func (r *Resolver) resolve() {
+	r.mu.Lock()
+	r.resolves++
+	seq := r.resolves
+	r.mu.Unlock()
+
+	if r.ctx.Err() != nil {
+		return
+	}
+
+	client := api.NewLogClient(r.resolverConn)
+
+	ctx, cancel := context.WithTimeout(r.ctx, ResolveTimeout)
+	defer cancel()
+
+	res, err := client.GetServers(ctx, &api.GetServersRequest{})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.ctx.Err() != nil || seq < r.resolved {
+		return
+	}
+
+	r.resolved = seq
+
+	if err != nil {
+		r.logger.Error(
+			"failed to resolve servers",
+			zap.Error(err),
+		)
+		r.clientConn.ReportError(err)
+		return
+	}
+
+	var addrs []resolver.Address
+
+	for _, server := range res.Servers {
+		// Draining servers are about to leave and refuse produces.
+		if server.Tags["draining"] == "true" {
+			continue
+		}
+
+		addrs = append(addrs, resolver.Address{
+			Addr: server.RpcAddr,
+			Attributes: attributes.New(
+				isLeaderAttribute,
+				server.IsLeader,
+			),
+		})
+	}
+
+	if err = r.clientConn.UpdateState(resolver.State{
+		Addresses:     addrs,
+		ServiceConfig: r.serviceConfig,
+	}); err != nil {
+		r.logger.Error(
+			"failed to update state",
+			zap.Error(err),
+		)
+	}
+}
+
+// Close stops refreshing the cluster's servers, cancels any resolution in
+// progress, and closes the connection used to discover them. Once it
+// returns, no resolution updates the client connection.
+func (r *Resolver) Close() {
+	// Resolutions check the context under the lock before updating the
+	// client connection, so none is left doing so once it is cancelled.
+	r.mu.Lock()
+	r.cancel()
+	r.mu.Unlock()
+
+	if err := r.resolverConn.Close(); err != nil {
+		r.logger.Error(
+			"failed to close conn",
+			zap.Error(err),
+		)
+	}
+}
+
+// refresh re-resolves the cluster's servers every RefreshInterval until the
+// resolver is closed.
+func (r *Resolver) refresh() {
+	ticker := time.NewTicker(RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+			r.resolve()
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package loadbalance
+
+import (
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/Gibson-Gichuru/prolog/internal/auth"
+	"github.com/Gibson-Gichuru/prolog/internal/config"
+	"github.com/Gibson-Gichuru/prolog/internal/server"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// TestResolver tests that the resolver discovers the cluster's servers
+// through the GetServers RPC and updates the client connection with their
+// addresses, marking which one is the leader.
+func TestResolver(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	tlsConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      config.ServerCertFile,
+		KeyFile:       config.ServerKeyFile,
+		CAFile:        config.CAFile,
+		Server:        true,
+		ServerAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+
+	serverCreds := credentials.NewTLS(tlsConfig)
+
+	authorizer, err := auth.New(config.ACLModelFile, config.ACLPolicyFile)
+	require.NoError(t, err)
+
+	srv, err := server.NewGRPCServer(&server.Config{
+		Authorizer:  authorizer,
+		GetServerer: &getServers{},
+	}, grpc.Creds(serverCreds))
+	require.NoError(t, err)
+
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	conn := &clientConn{}
+
+	tlsConfig, err = config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      config.RootCLientCertFile,
+		KeyFile:       config.RootClientKeyFile,
+		CAFile:        config.CAFile,
+		Server:        false,
+		ServerAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+
+	clientCreds := credentials.NewTLS(tlsConfig)
+
+	opts := resolver.BuildOptions{
+		DialCreds: clientCreds,
+	}
+
+	r, err := (&Builder{}).Build(
+		resolver.Target{
+			URL: *mustParseTarget(t, Name+":///"+l.Addr().String()),
+		},
+		conn,
+		opts,
+	)
+	require.NoError(t, err)
+	defer r.Close()
+
+	wantState := resolver.State{
+		Addresses: []resolver.Address{{
+			Addr:       "localhost:9001",
+			Attributes: attributes.New(isLeaderAttribute, true),
+		}, {
+			Addr:       "localhost:9002",
+			Attributes: attributes.New(isLeaderAttribute, false),
+		}},
+	}
+
+	// The first resolution runs in the background.
+	require.Eventually(t, func() bool {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		return len(conn.state.Addresses) != 0
+	}, time.Second, 10*time.Millisecond)
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	require.Equal(t, len(wantState.Addresses), len(conn.state.Addresses))
+
+	for i, want := range wantState.Addresses {
+		require.Equal(t, want.Addr, conn.state.Addresses[i].Addr)
+		require.True(t, want.Attributes.Equal(conn.state.Addresses[i].Attributes))
+	}
+}
+
+// TestResolverTimeout tests that resolving a server that never answers gives
+// up after ResolveTimeout, without blocking closing the resolver.
+func TestResolverTimeout(t *testing.T) {
+	timeout := ResolveTimeout
+	ResolveTimeout = 100 * time.Millisecond
+	defer func() { ResolveTimeout = timeout }()
+
+	// The listener accepts connections but never answers on them.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	start := time.Now()
+
+	r, err := (&Builder{}).Build(
+		resolver.Target{
+			URL: *mustParseTarget(t, Name+":///"+l.Addr().String()),
+		},
+		&clientConn{},
+		resolver.BuildOptions{DialCreds: insecure.NewCredentials()},
+	)
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), time.Second)
+
+	go r.ResolveNow(resolver.ResolveNowOptions{})
+
+	closed := make(chan struct{})
+
+	go func() {
+		r.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("close blocked on a pending resolution")
+	}
+}
+
+// TestResolverBuild tests that building a resolver does not wait for the
+// first resolution of a server that never answers.
+func TestResolverBuild(t *testing.T) {
+	// The listener accepts connections but never answers on them.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	start := time.Now()
+
+	r, err := (&Builder{}).Build(
+		resolver.Target{
+			URL: *mustParseTarget(t, Name+":///"+l.Addr().String()),
+		},
+		&clientConn{},
+		resolver.BuildOptions{DialCreds: insecure.NewCredentials()},
+	)
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), ResolveTimeout/2)
+
+	r.Close()
+}
+
+type getServers struct{}
+
+// GetServers returns a fixed leader and follower for the resolver to
+// discover, and a draining follower it should leave out.
+func (s *getServers) GetServers() ([]*api.Server, error) {
+	return []*api.Server{{
+		Id:       "leader",
+		RpcAddr:  "localhost:9001",
+		IsLeader: true,
+	}, {
+		Id:      "follower",
+		RpcAddr: "localhost:9002",
+	}, {
+		Id:      "draining",
+		RpcAddr: "localhost:9003",
+		Tags:    map[string]string{"draining": "true"},
+	}}, nil
+}
+
+type clientConn struct {
+	resolver.ClientConn
+	mu    sync.Mutex
+	state resolver.State
+}
+
+// UpdateState records the state the resolver pushes to the connection.
+func (c *clientConn) UpdateState(state resolver.State) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state = state
+	return nil
+}
+
+// ReportError ignores resolution errors.
+func (c *clientConn) ReportError(err error) {}
+
+// NewAddress ignores address updates made through the deprecated API.
+func (c *clientConn) NewAddress(addrs []resolver.Address) {}
+
+// ParseServiceConfig returns an empty parse result.
+func (c *clientConn) ParseServiceConfig(config string) *serviceconfig.ParseResult {
+	return nil
+}
+
+// mustParseTarget parses target as a URL, failing the test on error.
+func mustParseTarget(t *testing.T, target string) *url.URL {
+	t.Helper()
+
+	u, err := url.Parse(target)
+	require.NoError(t, err)
+
+	return u
+}
@@ -0,0 +1,136 @@
+package log
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// AckPollInterval is how often a replicator waiting for its peers to
+// acknowledge a record asks them how far they have replicated.
+var AckPollInterval = 10 * time.Millisecond
+
+// Replicated returns, for every server being replicated, the offset after
+// the last record of its log replicated locally.
+func (r *Replicator) Replicated() map[string]uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	replicated := make(map[string]uint64, len(r.progress))
+
+	for name, p := range r.progress {
+		p.mu.Lock()
+		replicated[name] = p.replicated
+		p.mu.Unlock()
+	}
+
+	return replicated
+}
+
+// WaitReplicated waits until the named peers have replicated the record at
+// offset of the local server's log, local being the name the peers know the
+// local server by, and returns nil. If ctx is done first, it returns the
+// names of the peers that had not. Peers are asked how far they have
+// replicated through the Describe RPC every AckPollInterval, and those that
+// have not started replicating from the local server have not replicated
+// the record yet.
+func (r *Replicator) WaitReplicated(
+	ctx context.Context,
+	local string,
+	offset uint64,
+	names []string,
+) []string {
+	clients, err := r.clients(names)
+
+	if err != nil {
+		r.logger.Error("failed to dial replicas", zap.Error(err))
+	}
+
+	ticker := time.NewTicker(AckPollInterval)
+	defer ticker.Stop()
+
+	for {
+		for name, client := range clients {
+			if r.acknowledged(ctx, client, local, offset) {
+				delete(clients, name)
+			}
+		}
+
+		if len(clients) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			waiting := make([]string, 0, len(clients))
+
+			for name := range clients {
+				waiting = append(waiting, name)
+			}
+
+			sort.Strings(waiting)
+
+			return waiting
+		case <-ticker.C:
+		}
+	}
+}
+
+// acknowledged asks a peer how far it has replicated the local server's log,
+// reporting whether it has replicated the record at offset. A peer that
+// cannot be asked has not acknowledged the record yet.
+func (r *Replicator) acknowledged(
+	ctx context.Context,
+	client api.LogClient,
+	local string,
+	offset uint64,
+) bool {
+	res, err := client.Describe(ctx, &api.DescribeRequest{})
+
+	if err != nil {
+		return false
+	}
+
+	return res.Replicated[local] > offset
+}
+
+// clients returns clients for the named peers, dialing those the replicator
+// has not polled yet. Peers the replicator does not know of are left out.
+func (r *Replicator) clients(names []string) (map[string]api.LogClient, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.init()
+
+	clients := make(map[string]api.LogClient, len(names))
+
+	for _, name := range names {
+		p, ok := r.peers[name]
+
+		if !ok || r.closed {
+			continue
+		}
+
+		cc, ok := r.conns[p.addr]
+
+		if !ok {
+			var err error
+
+			cc, err = grpc.NewClient(p.addr, r.DialOptions...)
+
+			if err != nil {
+				return clients, err
+			}
+
+			r.conns[p.addr] = cc
+		}
+
+		clients[name] = api.NewLogClient(cc)
+	}
+
+	return clients, nil
+}
@@ -0,0 +1,116 @@
+package log
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// TestWaitReplicated tests that a replicator waits for its replicas to
+// acknowledge a record, including those that have yet to start replicating
+// from it, returns the replicas that did not acknowledge the record in time,
+// and closes the connections to those that leave.
+func TestWaitReplicated(t *testing.T) {
+	r := &Replicator{
+		DialOptions: []grpc.DialOption{
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		},
+	}
+	defer r.Close()
+
+	r.init()
+
+	peers := map[string]*describer{
+		"1": {replicated: map[string]uint64{"0": 3}},
+		"2": {replicated: map[string]uint64{"1": 3}},
+		"3": {replicated: map[string]uint64{"0": 1}},
+	}
+
+	for name, d := range peers {
+		r.peers[name] = peer{addr: d.serve(t)}
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		peers["2"].set("0", 3)
+		peers["3"].set("0", 3)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	names := []string{"1", "2", "3", "4"}
+
+	require.Nil(t, r.WaitReplicated(ctx, "0", 2, names))
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	require.Equal(t, []string{"1", "2", "3"}, r.WaitReplicated(ctx, "0", 5, names))
+
+	// The connections to peers that leave are closed.
+	addr := r.peers["1"].addr
+	require.NoError(t, r.Leave("1"))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	require.NotContains(t, r.conns, addr)
+	require.Len(t, r.conns, 2)
+}
+
+// describer describes a log that has replicated its peers' logs up to the
+// offsets it is set to.
+type describer struct {
+	api.UnimplementedLogServer
+
+	mu         sync.Mutex
+	replicated map[string]uint64
+}
+
+// serve serves the describer on a local port until the test ends, and
+// returns its address.
+func (d *describer) serve(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := grpc.NewServer()
+	api.RegisterLogServer(srv, d)
+
+	go srv.Serve(l)
+	t.Cleanup(srv.Stop)
+
+	return l.Addr().String()
+}
+
+func (d *describer) set(name string, next uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.replicated[name] = next
+}
+
+func (d *describer) Describe(
+	ctx context.Context,
+	req *api.DescribeRequest,
+) (*api.DescribeResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	replicated := make(map[string]uint64, len(d.replicated))
+
+	for name, next := range d.replicated {
+		replicated[name] = next
+	}
+
+	return &api.DescribeResponse{Replicated: replicated}, nil
+}
@@ -1,6 +1,7 @@
 package log
 
 import (
+	"context"
 	"io"
 	"os"
 	"path"
@@ -8,8 +9,10 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"go.opencensus.io/stats"
 )
 
 type Log struct {
@@ -91,11 +94,21 @@ func (l *Log) setup() error {
 
 // Append adds a new record to the current segment. If the segment is at maximum
 // capacity, it will create a new one at the next offset. It returns the offset of
-// the appended record and any error encountered.
+// the appended record and any error encountered. The time taken is recorded
+// in the AppendLatency measure.
 func (l *Log) Append(record *api.Record) (uint64, error) {
+	start := time.Now()
+
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
+	defer func() {
+		stats.Record(
+			context.Background(),
+			AppendLatency.M(float64(time.Since(start))/float64(time.Millisecond)),
+		)
+	}()
+
 	off, err := l.activeSegment.Append(record)
 
 	if err != nil {
@@ -192,8 +205,30 @@ func (l *Log) HighestOffset() (uint64, error) {
 	return off - 1, nil
 }
 
+// Segments returns a description of every segment in the log, ordered by base
+// offset. Each entry reports the segment's offset range along with the number
+// of bytes used by its store and index.
+func (l *Log) Segments() []*api.Segment {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	segments := make([]*api.Segment, len(l.segments))
+
+	for i, s := range l.segments {
+		segments[i] = &api.Segment{
+			BaseOffset: s.baseOffset,
+			NextOffset: s.nextOffset,
+			StoreBytes: s.store.size,
+			IndexBytes: s.index.size,
+		}
+	}
+
+	return segments
+}
+
 // Truncate removes all segments that have an offset lower than the given lowest.
-// It then sets the log's segments to the remaining segments.
+// It then sets the log's segments to the remaining segments. The active
+// segment is always kept, so the log is never left without one to append to.
 // It returns any error encountered during the removal process.
 func (l *Log) Truncate(lowest uint64) error {
 	l.mu.Lock()
@@ -202,7 +237,7 @@ func (l *Log) Truncate(lowest uint64) error {
 	var segments []*segment
 
 	for _, s := range l.segments {
-		if s.nextOffset <= lowest+1 {
+		if s != l.activeSegment && s.nextOffset <= lowest+1 {
 			if err := s.Remove(); err != nil {
 				return err
 			}
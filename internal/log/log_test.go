@@ -20,6 +20,8 @@ func TestLog(t *testing.T) {
 		"init with existing segments":        testInitExisting,
 		"reader":                             testReader,
 		"truncate":                           testTruncate,
+		"truncate past the end":              testTruncatePastEnd,
+		"segments":                           testSegments,
 	} {
 		t.Run(scenarial, func(t *testing.T) {
 			dir, err := os.MkdirTemp("", "log_test")
@@ -133,3 +135,51 @@ func testTruncate(t *testing.T, log *Log) {
 	_, err = log.Read(0)
 	require.Error(t, err)
 }
+
+// testTruncatePastEnd tests that truncating beyond the highest offset keeps
+// the active segment, so the log can still be described and appended to.
+func testTruncatePastEnd(t *testing.T, log *Log) {
+	append := &api.Record{Value: []byte("hello world")}
+
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(append)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, log.Truncate(5))
+
+	lowest, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), lowest)
+
+	highest, err := log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), highest)
+
+	off, err := log.Append(append)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+}
+
+// testSegments tests that Segments reports every segment in the log with
+// its offset range and the bytes used by its store and index.
+func testSegments(t *testing.T, log *Log) {
+	append := &api.Record{Value: []byte("hello world")}
+
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(append)
+		require.NoError(t, err)
+	}
+
+	segments := log.Segments()
+	require.Equal(t, 2, len(segments))
+
+	require.Equal(t, uint64(0), segments[0].BaseOffset)
+	require.Equal(t, uint64(2), segments[0].NextOffset)
+	require.Equal(t, 2*endWidth, segments[0].IndexBytes)
+	require.True(t, segments[0].StoreBytes >= log.Config.Segment.MaxStoreBytes)
+
+	require.Equal(t, uint64(2), segments[1].BaseOffset)
+	require.Equal(t, uint64(3), segments[1].NextOffset)
+	require.Equal(t, endWidth, segments[1].IndexBytes)
+}
@@ -0,0 +1,23 @@
+package log
+
+import (
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+)
+
+var (
+	AppendLatency = stats.Float64(
+		"prolog/log/append_latency",
+		"Time taken to append a record to the log",
+		stats.UnitMilliseconds,
+	)
+
+	AppendLatencyView = &view.View{
+		Name:        "prolog/log/append_latency",
+		Description: "Distribution of the time taken to append a record to the log",
+		Measure:     AppendLatency,
+		Aggregation: view.Distribution(
+			0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 25, 50, 100,
+		),
+	}
+)
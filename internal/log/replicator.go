@@ -2,21 +2,84 @@ package log
 
 import (
 	"context"
+	"math"
+	"sort"
 	"sync"
+	"time"
 
 	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/Gibson-Gichuru/prolog/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// ProgressInterval is how often a replicator asks each peer for its log's
+// next offset in order to track replication lag.
+var ProgressInterval = 5 * time.Second
+
+// retryInterval is how long a replicator waits before retrying an append its
+// local server rejected for exceeding a quota, if it was not told how long.
+const retryInterval = 100 * time.Millisecond
+
 type Replicator struct {
 	DialOptions []grpc.DialOption
 	LocalServer api.LogClient
-	logger      *zap.Logger
-	mu          sync.Mutex
-	servers     map[string]chan struct{}
-	closed      bool
-	close       chan struct{}
+	// TracerProvider traces the replication of records that carry a trace
+	// context. The global provider is used if nil.
+	TracerProvider trace.TracerProvider
+	// Zone is the local server's zone.
+	Zone string
+	// Replicas limits how many peers are replicated from. The leader is
+	// always replicated from, and the remaining peers are chosen across as
+	// many zones as possible, preferring zones other than the local one, so
+	// that copies of the log are spread across zones. Every peer is
+	// replicated from if it is zero.
+	Replicas int
+	logger   *zap.Logger
+	tracer   trace.Tracer
+	mu       sync.Mutex
+	peers    map[string]peer
+	servers  map[string]chan struct{}
+	progress map[string]*progress
+	// resume holds the progress of the peers no longer replicated from, by
+	// name, so that replicating them again resumes from the last record
+	// replicated instead of replicating their logs again.
+	resume map[string]*progress
+	// conns holds the connections to peers polled for acknowledgements, by
+	// address.
+	conns  map[string]*grpc.ClientConn
+	closed bool
+	close  chan struct{}
+}
+
+// peer is a server known to the replicator, whether or not it is
+// replicated from.
+type peer struct {
+	addr   string
+	zone   string
+	leader bool
+}
+
+type progress struct {
+	// running is held by the goroutine replicating the peer, so that the
+	// next one resumes once the previous one has stopped.
+	running    sync.Mutex
+	mu         sync.Mutex
+	replicated uint64
+	peer       uint64
+	// peerAt is when the peer was last asked for its log's next offset,
+	// and syncedAt the last time at which every record the peer then held
+	// had been replicated.
+	peerAt   time.Time
+	syncedAt time.Time
 }
 
 // init initializes the replicator's logger and server map if they are nil.
@@ -27,23 +90,56 @@ func (r *Replicator) init() {
 		r.logger = zap.L().Named("Replicator")
 	}
 
+	if r.tracer == nil {
+		tp := r.TracerProvider
+
+		if tp == nil {
+			tp = otel.GetTracerProvider()
+		}
+
+		r.tracer = tp.Tracer("github.com/Gibson-Gichuru/prolog/internal/log")
+	}
+
+	if r.peers == nil {
+		r.peers = make(map[string]peer)
+	}
+
 	if r.servers == nil {
 		r.servers = make(map[string]chan struct{})
 	}
 
+	if r.progress == nil {
+		r.progress = make(map[string]*progress)
+	}
+
+	if r.resume == nil {
+		r.resume = make(map[string]*progress)
+	}
+
+	if r.conns == nil {
+		r.conns = make(map[string]*grpc.ClientConn)
+	}
+
 	if r.close == nil {
 		r.close = make(chan struct{})
 	}
 }
 
-// Join adds a server to the replicator's list of servers and starts a 
-// replication process to the given address. It initializes the replicator 
-// if not already initialized and checks if the replicator is closed or 
-// if the server is already present. If neither condition is true, it 
-// creates a new channel for the server and starts a goroutine to handle 
-// replication. Returns nil if the replicator is closed or if the server 
+// Join adds a server to the replicator's list of servers and starts a
+// replication process to the given address, unless the server is not among
+// the Replicas chosen. It initializes the replicator if not already
+// initialized and returns nil if the replicator is closed or if the server
 // is already in the list.
 func (r *Replicator) Join(name, addr string) error {
+	return r.JoinWithTags(name, addr, nil)
+}
+
+// JoinWithTags joins the server like Join, taking its zone and leadership
+// from its tags to choose the peers replicated from.
+func (r *Replicator) JoinWithTags(
+	name, addr string,
+	tags map[string]string,
+) error {
 	r.mu.Lock()
 
 	defer r.mu.Unlock()
@@ -54,38 +150,287 @@ func (r *Replicator) Join(name, addr string) error {
 		return nil
 	}
 
-	if _, ok := r.servers[name]; ok {
+	if _, ok := r.peers[name]; ok {
 		return nil
 	}
 
-	r.servers[name] = make(chan struct{})
+	r.peers[name] = peer{
+		addr:   addr,
+		zone:   tags["zone"],
+		leader: tags["leader"] == "true",
+	}
 
-	go r.replicate(addr, r.servers[name])
+	r.place()
+
+	return nil
+}
+
+// Update records a change to a server's address or tags. A server being
+// replicated from is dialed again if its address changed, resuming from the
+// last record replicated, and the peers
+// replicated from are chosen again if its zone or leadership changed. A
+// server that has not joined is joined.
+func (r *Replicator) Update(
+	name, addr string,
+	tags map[string]string,
+) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.init()
+
+	if r.closed {
+		return nil
+	}
+
+	if p, ok := r.peers[name]; ok && p.addr != addr {
+		r.stop(name)
+		r.disconnect(p.addr)
+	}
+
+	r.peers[name] = peer{
+		addr:   addr,
+		zone:   tags["zone"],
+		leader: tags["leader"] == "true",
+	}
+
+	r.place()
 
 	return nil
 }
 
 // Leave removes a server from the replicator's list of servers and closes the
-// channel used to signal that the server should stop replicating. It returns
-// an error if the server is not found in the list of servers. The function is
-// safe to call multiple times and returns nil after successfully removing the
-// server.
+// channel used to signal that the server should stop replicating, choosing
+// another peer to replicate from in its place if one is available. The
+// function is safe to call multiple times and returns nil after successfully
+// removing the server.
 func (r *Replicator) Leave(name string) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.init()
 
-	if _, ok := r.servers[name]; !ok {
+	p, ok := r.peers[name]
+
+	if !ok {
 		return nil
 	}
 
-	close(r.servers[name])
-	delete(r.servers, name)
+	delete(r.peers, name)
+	r.disconnect(p.addr)
+
+	if r.closed {
+		return nil
+	}
+
+	r.place()
+
 	return nil
 }
 
-// Close shuts down the replicator, marking it as closed and closing the
-// channel used for signaling. It ensures that the replicator is not
+// disconnect closes the connection used to poll the peer at addr for
+// acknowledgements, if any. It must be called with the lock held.
+func (r *Replicator) disconnect(addr string) {
+	if cc, ok := r.conns[addr]; ok {
+		cc.Close()
+		delete(r.conns, addr)
+	}
+}
+
+// Peers returns the names of the servers being replicated from.
+func (r *Replicator) Peers() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.servers))
+
+	for name := range r.servers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// place starts replicating from the chosen peers that are not yet replicated
+// from, resuming from the last record replicated if they were before, and
+// stops replicating from those no longer chosen. It must be called with the
+// lock held.
+func (r *Replicator) place() {
+	chosen := r.choose()
+
+	for name := range r.servers {
+		if !chosen[name] {
+			r.stop(name)
+		}
+	}
+
+	for name := range chosen {
+		if _, ok := r.servers[name]; ok {
+			continue
+		}
+
+		p, ok := r.resume[name]
+
+		if !ok {
+			p = &progress{}
+		}
+
+		delete(r.resume, name)
+
+		r.servers[name] = make(chan struct{})
+		r.progress[name] = p
+
+		go r.replicate(r.peers[name].addr, r.servers[name], r.progress[name])
+	}
+}
+
+// stop stops replicating from the named peer, if it is replicated from,
+// keeping the offset to resume from. It must be called with the lock held.
+func (r *Replicator) stop(name string) {
+	leave, ok := r.servers[name]
+
+	if !ok {
+		return
+	}
+
+	close(leave)
+
+	r.resume[name] = r.progress[name]
+
+	delete(r.servers, name)
+	delete(r.progress, name)
+}
+
+// choose returns the names of the peers to replicate from: every peer if
+// Replicas is zero or not exceeded, and otherwise the leaders followed by
+// one peer from each zone in turn, visiting zones other than the local one
+// first. Peers are taken in name order so that the choice is stable.
+func (r *Replicator) choose() map[string]bool {
+	chosen := make(map[string]bool, len(r.peers))
+
+	names := make([]string, 0, len(r.peers))
+
+	for name := range r.peers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	if r.Replicas <= 0 || len(names) <= r.Replicas {
+		for _, name := range names {
+			chosen[name] = true
+		}
+
+		return chosen
+	}
+
+	zones := make(map[string][]string)
+	var order []string
+
+	for _, name := range names {
+		p := r.peers[name]
+
+		if p.leader {
+			if len(chosen) < r.Replicas {
+				chosen[name] = true
+			}
+			continue
+		}
+
+		if _, ok := zones[p.zone]; !ok {
+			order = append(order, p.zone)
+		}
+
+		zones[p.zone] = append(zones[p.zone], name)
+	}
+
+	sort.Slice(order, func(i, j int) bool {
+		local, other := order[i] == r.Zone, order[j] == r.Zone
+
+		if local != other {
+			return other
+		}
+
+		return order[i] < order[j]
+	})
+
+	for len(chosen) < r.Replicas {
+		added := false
+
+		for _, zone := range order {
+			if len(zones[zone]) == 0 || len(chosen) == r.Replicas {
+				continue
+			}
+
+			chosen[zones[zone][0]] = true
+			zones[zone] = zones[zone][1:]
+			added = true
+		}
+
+		if !added {
+			break
+		}
+	}
+
+	return chosen
+}
+
+// Lag returns, for every server being replicated, how many records its log
+// holds that have not yet been replicated locally. A peer's log size is
+// refreshed every ProgressInterval, so the lag may briefly lag behind.
+func (r *Replicator) Lag() map[string]uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	lag := make(map[string]uint64, len(r.progress))
+
+	for name, p := range r.progress {
+		lag[name] = p.lag()
+	}
+
+	return lag
+}
+
+// Staleness returns how long ago the local log last held every record of
+// the named leader's log, or of the peer furthest behind when leader is
+// empty, as of when the peers were last asked for their logs' next offsets
+// every ProgressInterval. Peers that have not been caught up with yet are as
+// stale as a duration can be, and so is the local log if the leader is not
+// being replicated from or the replicator is closed. It returns zero if
+// leader is empty and no peers are replicated from.
+func (r *Replicator) Staleness(leader string) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+
+	if r.closed {
+		return math.MaxInt64
+	}
+
+	if leader != "" {
+		p, ok := r.progress[leader]
+
+		if !ok {
+			return math.MaxInt64
+		}
+
+		return p.staleness(now)
+	}
+
+	var staleness time.Duration
+
+	for _, p := range r.progress {
+		staleness = max(staleness, p.staleness(now))
+	}
+
+	return staleness
+}
+
+// Close shuts down the replicator, marking it as closed, closing the
+// channel used for signaling and the connections used to poll peers for
+// acknowledgements. It ensures that the replicator is not
 // already closed before proceeding. The function is safe to call
 // multiple times and returns nil after successfully closing.
 func (r *Replicator) Close() error {
@@ -102,15 +447,27 @@ func (r *Replicator) Close() error {
 
 	close(r.close)
 
+	for addr, cc := range r.conns {
+		cc.Close()
+		delete(r.conns, addr)
+	}
+
 	return nil
 }
 
 // replicate establishes a gRPC client connection to the given address and
-// starts a consume stream to receive log records. It listens for log records
-// and produces them to the local server. The function continues running until
-// the replicator is closed or the leave channel is signaled. Any errors
-// encountered during the process are logged with the provided address.
-func (r *Replicator) replicate(addr string, leave chan struct{}) {
+// starts a consume stream to receive log records from the offset after the
+// last record replicated in p, once any previous replication of the peer
+// has stopped. It listens for log records
+// and produces them to the local server, recording the offset after each
+// replicated record in p, and every ProgressInterval asks the peer for its
+// log's next offset. The function continues running until the replicator is
+// closed or the leave channel is signaled. Any errors encountered during the
+// process are logged with the provided address.
+func (r *Replicator) replicate(addr string, leave chan struct{}, p *progress) {
+
+	p.running.Lock()
+	defer p.running.Unlock()
 
 	cc, err := grpc.NewClient(addr, r.DialOptions...)
 
@@ -125,10 +482,14 @@ func (r *Replicator) replicate(addr string, leave chan struct{}) {
 
 	ctx := context.Background()
 
+	p.mu.Lock()
+	offset := p.replicated
+	p.mu.Unlock()
+
 	stream, err := client.ConsumeStream(
 		ctx,
 		&api.ConsumeRequest{
-			Offset: 0,
+			Offset: offset,
 		},
 	)
 
@@ -139,6 +500,11 @@ func (r *Replicator) replicate(addr string, leave chan struct{}) {
 
 	records := make(chan *api.Record)
 
+	ticker := time.NewTicker(ProgressInterval)
+	defer ticker.Stop()
+
+	r.updatePeerProgress(ctx, client, addr, p)
+
 	go func() {
 		for {
 			recv, err := stream.Recv()
@@ -159,24 +525,173 @@ func (r *Replicator) replicate(addr string, leave chan struct{}) {
 		case <-leave:
 			return
 
+		case <-ticker.C:
+			r.updatePeerProgress(ctx, client, addr, p)
+
 		case record := <-records:
 
-			_, err := r.LocalServer.Produce(
-				ctx,
-				&api.ProduceRequest{
-					Record: record,
-				},
-			)
+			err := r.produce(record, addr)
+
+			// A local quota rejecting the append only delays replication.
+			for delay, ok := retryAfter(err); ok; delay, ok = retryAfter(err) {
+				select {
+				case <-r.close:
+					return
+				case <-leave:
+					return
+				case <-time.After(delay):
+				}
+
+				err = r.produce(record, addr)
+			}
 
 			if err != nil {
 				r.logError(err, "failed to produce", addr)
 				return
 			}
+
+			p.setReplicated(record.Offset + 1)
 		}
 	}
 
 }
 
+// produce appends a record replicated from the peer at addr to the local
+// server. If the peer streamed the record with a trace context, the append
+// is traced as a child of the span that originally produced it, and the
+// context reaches the local server in the call's gRPC metadata, so the trace
+// covers the whole replication path.
+func (r *Replicator) produce(record *api.Record, addr string) error {
+
+	ctx := tracing.Propagator.Extract(
+		context.Background(),
+		propagation.MapCarrier(record.TraceContext),
+	)
+
+	ctx, span := r.tracer.Start(
+		ctx,
+		"Replicator.replicate",
+		trace.WithAttributes(
+			attribute.String("peer.addr", addr),
+			attribute.Int64("peer.offset", int64(record.Offset)),
+		),
+	)
+	defer span.End()
+
+	_, err := r.LocalServer.Produce(
+		ctx,
+		&api.ProduceRequest{
+			Record: record,
+		},
+	)
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+
+	return err
+}
+
+// retryAfter reports whether err rejected a request for exceeding a quota,
+// returning how long to wait before retrying it.
+func retryAfter(err error) (time.Duration, bool) {
+	st, ok := status.FromError(err)
+
+	if err == nil || !ok || st.Code() != codes.ResourceExhausted {
+		return 0, false
+	}
+
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			return info.RetryDelay.AsDuration(), true
+		}
+	}
+
+	return retryInterval, true
+}
+
+// updatePeerProgress asks the peer at addr to describe its log and records
+// the next offset of its last segment in p, as of when it was asked. Errors
+// are logged and leave the previous value in place.
+func (r *Replicator) updatePeerProgress(
+	ctx context.Context,
+	client api.LogClient,
+	addr string,
+	p *progress,
+) {
+	at := time.Now()
+
+	res, err := client.Describe(ctx, &api.DescribeRequest{})
+
+	if err != nil {
+		r.logError(err, "failed to describe", addr)
+		return
+	}
+
+	var next uint64
+
+	if len(res.Segments) > 0 {
+		next = res.Segments[len(res.Segments)-1].NextOffset
+	}
+
+	p.setPeer(next, at)
+}
+
+// setReplicated records off as the offset after the last record replicated
+// from the peer.
+func (p *progress) setReplicated(off uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.replicated = off
+
+	if p.peer < off {
+		p.peer = off
+	}
+
+	p.sync()
+}
+
+// setPeer records off as the next offset of the peer's log at time at.
+func (p *progress) setPeer(off uint64, at time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.peer < off {
+		p.peer = off
+	}
+
+	p.peerAt = at
+	p.sync()
+}
+
+// sync records the time the peer was last asked for its log's next offset
+// as the time the replica was in sync with it, once every record up to that
+// offset has been replicated. It must be called with the lock held.
+func (p *progress) sync() {
+	if p.replicated >= p.peer && p.peerAt.After(p.syncedAt) {
+		p.syncedAt = p.peerAt
+	}
+}
+
+// lag returns how many of the peer's records have not been replicated yet.
+func (p *progress) lag() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.peer - p.replicated
+}
+
+// staleness returns how long ago the replica last held every record the
+// peer did, as far as the replica knows.
+func (p *progress) staleness(now time.Time) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return now.Sub(p.syncedAt)
+}
+
 // logError logs the given error at error level with the given message and the
 // name and rpc address of the given member.
 func (r *Replicator) logError(err error, msg, addr string) {
@@ -0,0 +1,314 @@
+package log
+
+import (
+	"context"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestReplicatorPlacement tests that a replicator limited to a number of
+// replicas always replicates from the leader, spreads the other replicas
+// across zones other than its own before using its own, and chooses a
+// replacement when a replicated peer leaves.
+func TestReplicatorPlacement(t *testing.T) {
+	r := &Replicator{Zone: "a", Replicas: 3}
+	defer r.Close()
+
+	for _, peer := range []struct {
+		name string
+		tags map[string]string
+	}{
+		{"a1", map[string]string{"zone": "a"}},
+		{"a2", map[string]string{"zone": "a", "leader": "true"}},
+		{"b1", map[string]string{"zone": "b"}},
+		{"b2", map[string]string{"zone": "b"}},
+		{"c1", map[string]string{"zone": "c"}},
+	} {
+		require.NoError(t, r.JoinWithTags(peer.name, "127.0.0.1:0", peer.tags))
+	}
+
+	require.Equal(t, []string{"a2", "b1", "c1"}, r.Peers())
+
+	// With zone c gone, zones b and a take turns.
+	require.NoError(t, r.Leave("c1"))
+	require.Equal(t, []string{"a1", "a2", "b1"}, r.Peers())
+
+	require.NoError(t, r.Leave("b1"))
+	require.Equal(t, []string{"a1", "a2", "b2"}, r.Peers())
+
+	// Without a limit every peer is replicated from.
+	r.Replicas = 0
+	require.NoError(t, r.Join("d1", "127.0.0.1:0"))
+	require.Equal(t, []string{"a1", "a2", "b2", "d1"}, r.Peers())
+}
+
+// TestReplicatorUpdate tests that updates to a peer's tags choose the peers
+// replicated from again.
+func TestReplicatorUpdate(t *testing.T) {
+	r := &Replicator{Zone: "a", Replicas: 2}
+	defer r.Close()
+
+	for _, name := range []string{"a1", "b1", "c1"} {
+		require.NoError(t, r.JoinWithTags(
+			name,
+			"127.0.0.1:0",
+			map[string]string{"zone": name[:1]},
+		))
+	}
+
+	require.Equal(t, []string{"b1", "c1"}, r.Peers())
+
+	require.NoError(t, r.Update(
+		"a1",
+		"127.0.0.1:1",
+		map[string]string{"zone": "a", "leader": "true"},
+	))
+	require.Equal(t, []string{"a1", "b1"}, r.Peers())
+
+	// Updating an unknown server joins it.
+	r.Replicas = 0
+	require.NoError(t, r.Update("d1", "127.0.0.1:0", nil))
+	require.Equal(t, []string{"a1", "b1", "c1", "d1"}, r.Peers())
+}
+
+// TestReplicatorResume tests that a peer dialed again at a new address is
+// replicated from the last record replicated, instead of from the start of
+// its log.
+func TestReplicatorResume(t *testing.T) {
+	peer := &peerLog{}
+	local := &localLog{}
+
+	for range 3 {
+		peer.append([]byte("hello world"))
+	}
+
+	r := &Replicator{
+		DialOptions: []grpc.DialOption{
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		},
+		LocalServer: local,
+	}
+	defer r.Close()
+
+	require.NoError(t, r.Join("1", peer.serve(t)))
+	require.Eventually(t, func() bool {
+		return local.len() == 3
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, r.Update("1", peer.serve(t), nil))
+
+	peer.append([]byte("hello again"))
+
+	require.Eventually(t, func() bool {
+		return local.len() == 4
+	}, time.Second, 10*time.Millisecond)
+
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, 4, local.len())
+}
+
+// TestReplicatorReplace tests that a peer chosen again after a change of
+// leadership is replicated from the last record replicated.
+func TestReplicatorReplace(t *testing.T) {
+	peers := map[string]*peerLog{"a": {}, "b": {}}
+	local := &localLog{}
+
+	r := &Replicator{
+		DialOptions: []grpc.DialOption{
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		},
+		LocalServer: local,
+		Replicas:    1,
+	}
+	defer r.Close()
+
+	addrs := make(map[string]string)
+
+	for name, peer := range peers {
+		peer.append([]byte("hello world"))
+		peer.append([]byte("hello world"))
+		addrs[name] = peer.serve(t)
+	}
+
+	lead := func(leader string) {
+		for name, addr := range addrs {
+			require.NoError(t, r.Update(name, addr, map[string]string{
+				"leader": strconv.FormatBool(name == leader),
+			}))
+		}
+
+		require.Equal(t, []string{leader}, r.Peers())
+	}
+
+	lead("a")
+	require.Eventually(t, func() bool {
+		return local.len() == 2
+	}, time.Second, 10*time.Millisecond)
+
+	lead("b")
+	require.Eventually(t, func() bool {
+		return local.len() == 4
+	}, time.Second, 10*time.Millisecond)
+
+	lead("a")
+	peers["a"].append([]byte("hello again"))
+
+	require.Eventually(t, func() bool {
+		return local.len() == 5
+	}, time.Second, 10*time.Millisecond)
+
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, 5, local.len())
+}
+
+// peerLog is a peer's log, served for replication.
+type peerLog struct {
+	api.UnimplementedLogServer
+
+	mu      sync.Mutex
+	records []*api.Record
+}
+
+// serve serves the log on a new local port until the test ends, and returns
+// its address.
+func (l *peerLog) serve(t *testing.T) string {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := grpc.NewServer()
+	api.RegisterLogServer(srv, l)
+
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	return lis.Addr().String()
+}
+
+func (l *peerLog) append(value []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.records = append(l.records, &api.Record{
+		Value:  value,
+		Offset: uint64(len(l.records)),
+	})
+}
+
+func (l *peerLog) read(off uint64) *api.Record {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if off >= uint64(len(l.records)) {
+		return nil
+	}
+
+	return l.records[off]
+}
+
+func (l *peerLog) ConsumeStream(
+	req *api.ConsumeRequest,
+	stream api.Log_ConsumeStreamServer,
+) error {
+	for off := req.Offset; ; {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		default:
+		}
+
+		record := l.read(off)
+
+		if record == nil {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+
+		if err := stream.Send(&api.ConsumeResponse{Record: record}); err != nil {
+			return err
+		}
+
+		off++
+	}
+}
+
+func (l *peerLog) Describe(
+	ctx context.Context,
+	req *api.DescribeRequest,
+) (*api.DescribeResponse, error) {
+	return &api.DescribeResponse{}, nil
+}
+
+// localLog is the local server, counting the records replicated to it.
+type localLog struct {
+	api.LogClient
+
+	mu      sync.Mutex
+	records int
+}
+
+func (l *localLog) Produce(
+	ctx context.Context,
+	req *api.ProduceRequest,
+	opts ...grpc.CallOption,
+) (*api.ProduceResponse, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.records++
+
+	return &api.ProduceResponse{}, nil
+}
+
+func (l *localLog) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.records
+}
+
+// TestProgressStaleness tests that a replica is as stale as the time since
+// it last held every record the peer had when asked, and that it stays that
+// stale until it has replicated them.
+func TestProgressStaleness(t *testing.T) {
+	p := &progress{}
+	start := time.Now()
+
+	require.Equal(t, time.Duration(math.MaxInt64), p.staleness(start))
+
+	p.setPeer(3, start)
+	require.Equal(t, time.Duration(math.MaxInt64), p.staleness(start))
+
+	p.setReplicated(3)
+	require.Equal(t, time.Second, p.staleness(start.Add(time.Second)))
+
+	// The peer has appended more records since.
+	p.setPeer(5, start.Add(time.Second))
+	require.Equal(t, 2*time.Second, p.staleness(start.Add(2*time.Second)))
+
+	p.setReplicated(5)
+	require.Equal(t, time.Second, p.staleness(start.Add(2*time.Second)))
+}
+
+// TestReplicatorStaleness tests that a replicator that has not started
+// replicating from the leader, or has been closed, is as stale as can be.
+func TestReplicatorStaleness(t *testing.T) {
+	r := &Replicator{}
+
+	require.Equal(t, time.Duration(0), r.Staleness(""))
+	require.Equal(t, time.Duration(math.MaxInt64), r.Staleness("leader"))
+
+	require.NoError(t, r.Close())
+	require.Equal(t, time.Duration(math.MaxInt64), r.Staleness(""))
+}
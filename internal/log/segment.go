@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"sync"
 
 	api "github.com/Gibson-Gichuru/prolog/api/v1"
 	"google.golang.org/protobuf/proto"
@@ -15,6 +16,11 @@ type segment struct {
 	baseOffset uint64
 	nextOffset uint64
 	config     Config
+	// snapshots counts the snapshots streaming the segment's store, which
+	// closing or removing the segment waits for. Snapshots are only added
+	// under the log's read lock, and segments only closed or removed under
+	// its write lock.
+	snapshots sync.WaitGroup
 }
 
 // newSegment creates a new segment for the log, initializing its store and index.
@@ -137,6 +143,8 @@ func (s *segment) IsMaxed() bool {
 // It returns any error encountered during the removal process.
 func (s *segment) Remove() error {
 
+	s.snapshots.Wait()
+
 	if err := s.store.Close(); err != nil {
 		return err
 	}
@@ -151,9 +159,12 @@ func (s *segment) Remove() error {
 
 // Close flushes the index's memory map, synchronizes the underlying file,
 // truncates it to the correct size, and closes it. It also flushes the buffer
-// and closes the underlying store file. It is safe to call multiple times. It
-// returns any error encountered during the close operation.
+// and closes the underlying store file, once no snapshot is streaming it. It
+// is safe to call multiple times. It returns any error encountered during the
+// close operation.
 func (s *segment) Close() error {
+	s.snapshots.Wait()
+
 	if err := s.index.Close(); err != nil {
 		return err
 	}
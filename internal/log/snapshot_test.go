@@ -0,0 +1,171 @@
+package log
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSnapshot tests that a snapshot taken from a log spanning several
+// segments can be restored into a new directory, that the restored log
+// contains exactly the records present when the snapshot was taken, and that
+// the restored log accepts new appends.
+func TestSnapshot(t *testing.T) {
+	dir, err := os.MkdirTemp("", "snapshot_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	append := &api.Record{Value: []byte("hello world")}
+
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(append)
+		require.NoError(t, err)
+	}
+
+	var buf bytes.Buffer
+
+	off, err := log.Snapshot(&buf)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+
+	_, err = log.Append(append)
+	require.NoError(t, err)
+
+	restoreDir, err := os.MkdirTemp("", "snapshot_restore_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(restoreDir)
+
+	require.NoError(t, Restore(restoreDir, &buf))
+
+	restored, err := NewLog(restoreDir, c)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	off, err = restored.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+
+	off, err = restored.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+
+	for i := uint64(0); i <= off; i++ {
+		read, err := restored.Read(i)
+		require.NoError(t, err)
+		require.Equal(t, append.Value, read.Value)
+		require.Equal(t, i, read.Offset)
+	}
+
+	_, err = restored.Read(off + 1)
+	require.Error(t, err)
+
+	off, err = restored.Append(append)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+}
+
+// TestSnapshotTruncate tests that truncating the log while a snapshot is
+// being streamed waits for the snapshot, which still holds every record.
+func TestSnapshotTruncate(t *testing.T) {
+	dir, err := os.MkdirTemp("", "snapshot_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	append := &api.Record{Value: []byte("hello world")}
+
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(append)
+		require.NoError(t, err)
+	}
+
+	r, w := io.Pipe()
+
+	go func() {
+		_, err := log.Snapshot(w)
+		w.CloseWithError(err)
+	}()
+
+	// The segments are captured before anything is written.
+	var buf bytes.Buffer
+	_, err = io.CopyN(&buf, r, 1)
+	require.NoError(t, err)
+
+	truncated := make(chan error)
+
+	go func() {
+		truncated <- log.Truncate(1)
+	}()
+
+	select {
+	case <-truncated:
+		t.Fatal("truncate did not wait for the snapshot")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	_, err = io.Copy(&buf, r)
+	require.NoError(t, err)
+	require.NoError(t, <-truncated)
+
+	restoreDir, err := os.MkdirTemp("", "snapshot_restore_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(restoreDir)
+
+	require.NoError(t, Restore(restoreDir, &buf))
+
+	restored, err := NewLog(restoreDir, c)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	for i := uint64(0); i < 3; i++ {
+		read, err := restored.Read(i)
+		require.NoError(t, err)
+		require.Equal(t, append.Value, read.Value)
+	}
+}
+
+// TestRestoreRejectsUnexpectedEntries tests that Restore refuses archives
+// containing anything other than segment store and index files.
+func TestRestoreRejectsUnexpectedEntries(t *testing.T) {
+	dir, err := os.MkdirTemp("", "snapshot_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	body := []byte("not a segment")
+	require.NoError(t, tw.WriteHeader(&tar.Header{
+		Name: "../escape.store.txt",
+		Mode: 0644,
+		Size: int64(len(body)),
+	}))
+	_, err = tw.Write(body)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	require.Error(t, Restore(dir, &buf))
+
+	_, err = os.Stat(filepath.Join(filepath.Dir(dir), "escape.store.txt"))
+	require.True(t, os.IsNotExist(err))
+}
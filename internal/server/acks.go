@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultAckTimeout is how long a produce acknowledged by all in-sync
+// replicas waits for them when the request does not set a timeout.
+var DefaultAckTimeout = 5 * time.Second
+
+// Acknowledger waits for the replicas of the server's log to acknowledge
+// the records appended to it.
+type Acknowledger interface {
+	// WaitReplicated waits until every in-sync replica has replicated the
+	// record at offset, returning the names of the replicas that had not
+	// when ctx is done.
+	WaitReplicated(ctx context.Context, offset uint64) []string
+}
+
+// checkAcks returns an InvalidArgument error for an unknown ack level, and
+// Unimplemented for produces acknowledged by all in-sync replicas if the
+// server was not configured with an Acknowledger.
+func (s *grpcServer) checkAcks(req *api.ProduceRequest) error {
+	switch req.Acks {
+	case api.Acks_ACKS_LEADER, api.Acks_ACKS_NONE:
+		return nil
+	case api.Acks_ACKS_ALL:
+		if s.Acknowledger == nil {
+			return status.Error(
+				codes.Unimplemented,
+				"acknowledgement by replicas is not supported",
+			)
+		}
+
+		return nil
+	default:
+		return status.Errorf(codes.InvalidArgument, "invalid acks %d", req.Acks)
+	}
+}
+
+// waitAcks waits for the in-sync replicas to acknowledge the record
+// appended at offset if the request asks for it, for up to the request's
+// AckTimeoutMs, or DefaultAckTimeout if it is zero. It returns an
+// ErrorAckTimeout naming the replicas that had not acknowledged it in time.
+func (s *grpcServer) waitAcks(
+	ctx context.Context,
+	req *api.ProduceRequest,
+	offset uint64,
+) error {
+	if req.Acks != api.Acks_ACKS_ALL {
+		return nil
+	}
+
+	timeout := time.Duration(req.AckTimeoutMs) * time.Millisecond
+
+	if timeout == 0 {
+		timeout = DefaultAckTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if missing := s.Acknowledger.WaitReplicated(ctx, offset); len(missing) > 0 {
+		return api.ErrorAckTimeout{Offset: offset, Replicas: missing}
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestAcks tests that produces acknowledged by all in-sync replicas wait for
+// them within their timeout, failing with the replicas that did not
+// acknowledge the record, and that produces without acknowledgements are not
+// answered on a stream.
+func TestAcks(t *testing.T) {
+	acknowledger := &fakeAcknowledger{}
+
+	client, _, cfg, teardown := setupTest(t, func(c *Config) {
+		c.Acknowledger = acknowledger
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	record := &api.Record{Value: []byte("hello world")}
+
+	res, err := client.Produce(ctx, &api.ProduceRequest{
+		Record:       record,
+		Acks:         api.Acks_ACKS_ALL,
+		AckTimeoutMs: 1000,
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), res.Offset)
+	require.InDelta(t, time.Second, acknowledger.waited(), float64(100*time.Millisecond))
+
+	acknowledger.lag("follower-1")
+
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record:       record,
+		Acks:         api.Acks_ACKS_ALL,
+		AckTimeoutMs: 50,
+	})
+
+	st := status.Convert(err)
+	require.Equal(t, codes.DeadlineExceeded, st.Code())
+	require.Len(t, st.Details(), 1)
+
+	info := st.Details()[0].(*errdetails.ErrorInfo)
+	require.Equal(t, "ACK_TIMEOUT", info.Reason)
+	require.Equal(t, "1", info.Metadata["offset"])
+	require.Equal(t, "follower-1", info.Metadata["replicas"])
+
+	// The record was appended regardless.
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 1})
+	require.NoError(t, err)
+
+	// Produces acknowledged by the leader do not wait for replicas.
+	_, err = client.Produce(ctx, &api.ProduceRequest{Record: record})
+	require.NoError(t, err)
+
+	stream, err := client.ProduceStream(ctx)
+	require.NoError(t, err)
+
+	for range 2 {
+		require.NoError(t, stream.Send(&api.ProduceRequest{
+			Record: record,
+			Acks:   api.Acks_ACKS_NONE,
+		}))
+	}
+
+	require.NoError(t, stream.CloseSend())
+
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 4})
+	require.NoError(t, err)
+
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record: record,
+		Acks:   api.Acks(42),
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	cfg.Acknowledger = nil
+
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record: record,
+		Acks:   api.Acks_ACKS_ALL,
+	})
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+// fakeAcknowledger acknowledges records at once unless a replica is set to
+// lag, in which case it waits for the deadline and reports the replica.
+type fakeAcknowledger struct {
+	mu      sync.Mutex
+	replica string
+	timeout time.Duration
+}
+
+func (a *fakeAcknowledger) lag(replica string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.replica = replica
+}
+
+func (a *fakeAcknowledger) waited() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.timeout
+}
+
+func (a *fakeAcknowledger) WaitReplicated(ctx context.Context, offset uint64) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		a.timeout = time.Until(deadline)
+	}
+
+	if a.replica == "" {
+		return nil
+	}
+
+	<-ctx.Done()
+
+	return []string{a.replica}
+}
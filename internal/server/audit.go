@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/Gibson-Gichuru/prolog/internal/audit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// AuditTopic is the topic consumed to read the node's audit log. Each
+// record's value is an audit.Event encoded as JSON. The audit log is kept per
+// node rather than in the replicated log: records there carry no topic, so
+// every consumer of the data would read the decisions too, and replicating
+// them would be authorized on every peer, auditing the audit records in
+// turn. A consume of the AuditTopic therefore only returns the decisions of
+// the node serving it.
+const AuditTopic = "audit"
+
+// Auditor records the server's authorization decisions.
+type Auditor interface {
+	Audit(audit.Event)
+}
+
+// AuditLog reads the records of the audit log the Auditor appends to.
+type AuditLog interface {
+	Read(uint64) (*api.Record, error)
+}
+
+// consumeAudit reads the audit log's record at the request's offset. Only
+// subjects allowed to administer the ACLs may read the audit log, and since
+// it is not replicated the request's staleness bounds do not apply to it.
+func (s *grpcServer) consumeAudit(ctx context.Context, req *api.ConsumeRequest) (*api.ConsumeResponse, error) {
+
+	if err := s.authorize(ctx, aclObject, adminAction); err != nil {
+		return nil, err
+	}
+
+	if s.AuditLog == nil {
+		return nil, status.Error(codes.Unimplemented, "the audit log is not enabled")
+	}
+
+	record, err := s.AuditLog.Read(req.Offset)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.ConsumeResponse{Record: record}, nil
+}
+
+// authorize checks that the request's subject may perform the action on the
+// object, recording the decision with the config's Auditor.
+func (s *grpcServer) authorize(ctx context.Context, object, action string) error {
+	err := s.Authorizer.Authorize(subject(ctx), object, action)
+
+	if s.Auditor == nil {
+		return err
+	}
+
+	allowed := err == nil
+
+	if stream, ok := ctx.Value(auditStreamContextKey{}).(*auditStream); ok &&
+		!stream.changed(object, action, allowed) {
+		return err
+	}
+
+	event := audit.Event{
+		Time:    time.Now(),
+		Subject: subject(ctx),
+		Object:  object,
+		Action:  action,
+		Allowed: allowed,
+	}
+
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		event.Peer = p.Addr.String()
+	}
+
+	if method, ok := grpc.Method(ctx); ok {
+		event.Method = method
+	} else if method, ok := ctx.Value(methodContextKey{}).(string); ok {
+		event.Method = method
+	}
+
+	s.Auditor.Audit(event)
+
+	return err
+}
+
+// withAuditStream returns a context for the requests of a stream, which
+// authorize the same action for every message. Only the first decision and
+// any that differ from the previous one are audited, so that idle consumers
+// polling for records do not flood the audit log.
+func withAuditStream(ctx context.Context) context.Context {
+	return context.WithValue(ctx, auditStreamContextKey{}, &auditStream{
+		decisions: make(map[[2]string]bool),
+	})
+}
+
+type auditStream struct {
+	mu        sync.Mutex
+	decisions map[[2]string]bool
+}
+
+// changed records the decision, reporting whether it is the first for the
+// object and action or differs from the previous one.
+func (a *auditStream) changed(object, action string, allowed bool) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := [2]string{object, action}
+	previous, ok := a.decisions[key]
+	a.decisions[key] = allowed
+
+	return !ok || previous != allowed
+}
+
+type auditStreamContextKey struct{}
+
+// methodContextKey holds the gateway route, such as POST /v1/records, of
+// requests made through the HTTP gateway.
+type methodContextKey struct{}
+
+// httpAddr is the remote address of a gateway request.
+type httpAddr string
+
+var _ net.Addr = httpAddr("")
+
+func (a httpAddr) Network() string { return "tcp" }
+func (a httpAddr) String() string  { return string(a) }
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/Gibson-Gichuru/prolog/internal/audit"
+	"github.com/Gibson-Gichuru/prolog/internal/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestAudit tests that authorization decisions are audited with the
+// request's subject, peer and method, and that streams only audit changed
+// decisions.
+func TestAudit(t *testing.T) {
+	auditor := &recordingAuditor{}
+
+	root, nobody, _, teardown := setupTest(t, func(c *Config) {
+		c.Auditor = auditor
+	})
+	defer teardown()
+
+	ctx := context.Background()
+
+	_, err := root.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+
+	_, err = nobody.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.Error(t, err)
+
+	events := auditor.all()
+	require.Len(t, events, 2)
+
+	require.Equal(t, "root", events[0].Subject)
+	require.Equal(t, "topics/"+DefaultTopic, events[0].Object)
+	require.Equal(t, produceAction, events[0].Action)
+	require.True(t, events[0].Allowed)
+	require.Equal(t, api.Log_Produce_FullMethodName, events[0].Method)
+	require.NotEmpty(t, events[0].Peer)
+	require.WithinDuration(t, time.Now(), events[0].Time, time.Minute)
+
+	require.Equal(t, "nobody", events[1].Subject)
+	require.Equal(t, consumeAction, events[1].Action)
+	require.False(t, events[1].Allowed)
+	require.Equal(t, api.Log_Consume_FullMethodName, events[1].Method)
+
+	// A consumer waiting for new records authorizes each read, but its
+	// decision is audited once.
+	streamCtx, cancel := context.WithCancel(ctx)
+	stream, err := root.ConsumeStream(streamCtx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+
+	_, err = stream.Recv()
+	require.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	events = auditor.all()
+	require.Len(t, events, 3)
+	require.Equal(t, api.Log_ConsumeStream_FullMethodName, events[2].Method)
+	require.True(t, events[2].Allowed)
+}
+
+// TestHTTPAudit tests that gateway requests are audited with their route.
+func TestHTTPAudit(t *testing.T) {
+	auditor := &recordingAuditor{}
+
+	url, _, nobody, teardown := setupHTTPTest(t, func(c *Config) {
+		c.Auditor = auditor
+	})
+	defer teardown()
+
+	res := produce(t, nobody, url, []byte("hello world"))
+	res.Body.Close()
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	events := auditor.all()
+	require.Len(t, events, 1)
+	require.Equal(t, "nobody", events[0].Subject)
+	require.False(t, events[0].Allowed)
+	require.Equal(t, "POST /v1/records", events[0].Method)
+	require.NotEmpty(t, events[0].Peer)
+}
+
+// TestConsumeAudit tests that administrators read the audit log by consuming
+// the audit topic, and that other subjects and topics are refused.
+func TestConsumeAudit(t *testing.T) {
+	dir, err := os.MkdirTemp("", "audit_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	auditLog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	defer auditLog.Close()
+
+	root, nobody, _, teardown := setupTest(t, func(c *Config) {
+		c.Auditor = audit.New(auditLog, audit.FilterDenied)
+		c.AuditLog = auditLog
+	})
+	defer teardown()
+
+	ctx := context.Background()
+
+	_, err = nobody.Consume(ctx, &api.ConsumeRequest{Topic: AuditTopic})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	res, err := root.Consume(ctx, &api.ConsumeRequest{Topic: AuditTopic})
+	require.NoError(t, err)
+
+	var event audit.Event
+	require.NoError(t, json.Unmarshal(res.Record.Value, &event))
+	require.Equal(t, "nobody", event.Subject)
+	require.Equal(t, aclObject, event.Object)
+	require.Equal(t, adminAction, event.Action)
+	require.False(t, event.Allowed)
+
+	_, err = root.Consume(ctx, &api.ConsumeRequest{Topic: AuditTopic, Offset: 1})
+	require.Equal(
+		t,
+		status.Code(api.ErrorOffsetOutOfRange{}.GRPCStatus().Err()),
+		status.Code(err),
+	)
+
+	_, err = root.Consume(ctx, &api.ConsumeRequest{Topic: "other"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = root.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+
+	res, err = root.Consume(ctx, &api.ConsumeRequest{Topic: DefaultTopic})
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello world"), res.Record.Value)
+}
+
+// recordingAuditor records every event it is given.
+type recordingAuditor struct {
+	mu     sync.Mutex
+	events []audit.Event
+}
+
+func (a *recordingAuditor) Audit(event audit.Event) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.events = append(a.events, event)
+}
+
+func (a *recordingAuditor) all() []audit.Event {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return append([]audit.Event(nil), a.events...)
+}
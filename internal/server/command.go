@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Commander runs commands on every server in the cluster.
+type Commander interface {
+	// Command runs the named command with its JSON encoded payload on every
+	// server, waiting up to timeout for their results, or a default that
+	// grows with the cluster if it is zero.
+	Command(name string, payload []byte, timeout time.Duration) (*api.CommandResponse, error)
+}
+
+// Command runs the request's command on every server in the cluster, such
+// as reloading their ACL policies, and returns each server's result by name.
+// Servers that did not answer within the timeout are missing from the
+// results. Only subjects allowed to administer the cluster may run commands.
+// It returns Unimplemented if the server was not configured with a
+// Commander.
+func (s *grpcServer) Command(ctx context.Context, req *api.CommandRequest) (*api.CommandResponse, error) {
+
+	if err := s.authorize(
+		ctx,
+		clusterObject,
+		adminAction,
+	); err != nil {
+		return nil, err
+	}
+
+	if s.Commander == nil {
+		return nil, status.Error(codes.Unimplemented, "commands are not supported")
+	}
+
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "command requires a name")
+	}
+
+	return s.Commander.Command(
+		req.Name,
+		req.Payload,
+		time.Duration(req.TimeoutSeconds)*time.Second,
+	)
+}
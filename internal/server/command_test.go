@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestCommand tests that commands are run by the Commander for
+// administrators only, and that they require a name.
+func TestCommand(t *testing.T) {
+	commander := &fakeCommander{}
+
+	client, nobody, cfg, teardown := setupTest(t, func(c *Config) {
+		c.Commander = commander
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	req := &api.CommandRequest{
+		Name:           "stats",
+		Payload:        []byte("{}"),
+		TimeoutSeconds: 2,
+	}
+
+	_, err := nobody.Command(ctx, req)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = client.Command(ctx, &api.CommandRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	res, err := client.Command(ctx, req)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, res.NumNodes)
+	require.Equal(t, []byte("{}"), res.Results["0"].Payload)
+	require.Equal(t, 2*time.Second, commander.timeout)
+
+	cfg.Commander = nil
+
+	_, err = client.Command(ctx, req)
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+// fakeCommander answers every command with its payload as the only
+// server's result.
+type fakeCommander struct {
+	timeout time.Duration
+}
+
+func (c *fakeCommander) Command(
+	name string,
+	payload []byte,
+	timeout time.Duration,
+) (*api.CommandResponse, error) {
+	c.timeout = timeout
+
+	return &api.CommandResponse{
+		NumNodes: 1,
+		Results: map[string]*api.CommandResult{
+			"0": {Payload: payload},
+		},
+	}, nil
+}
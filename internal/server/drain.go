@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Drainer drains the server before it is decommissioned.
+type Drainer interface {
+	// Draining reports whether the server is draining, and the RPC address
+	// of a server to send produces to instead, if one is known.
+	Draining() (redirect string, draining bool)
+	// Drain stops the server accepting produces, waits up to timeout for
+	// its streams to finish, and leaves the cluster.
+	Drain(timeout time.Duration) (*api.DrainResponse, error)
+}
+
+// Streams tracks the streams a server is serving, over gRPC and the
+// gateway, so that a draining server can wait for them to finish and cancel
+// those that do not. A nil Streams tracks nothing.
+type Streams struct {
+	mu      sync.Mutex
+	next    uint64
+	cancels map[uint64]context.CancelFunc
+	// changed is closed and replaced whenever a stream finishes.
+	changed chan struct{}
+}
+
+// track registers a stream served with ctx, returning the context to serve
+// it with, which is cancelled if the stream is cancelled, and a function to
+// call once it finishes.
+func (s *Streams) track(ctx context.Context) (context.Context, func()) {
+	if s == nil {
+		return ctx, func() {}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cancels == nil {
+		s.cancels = make(map[uint64]context.CancelFunc)
+		s.changed = make(chan struct{})
+	}
+
+	id := s.next
+	s.next++
+	s.cancels[id] = cancel
+
+	return ctx, func() {
+		cancel()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		delete(s.cancels, id)
+		close(s.changed)
+		s.changed = make(chan struct{})
+	}
+}
+
+// Len returns the number of streams being served.
+func (s *Streams) Len() int {
+	if s == nil {
+		return 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.cancels)
+}
+
+// Wait waits until no streams are being served, returning ctx's error if it
+// is done first.
+func (s *Streams) Wait(ctx context.Context) error {
+	for {
+		if s == nil {
+			return nil
+		}
+
+		s.mu.Lock()
+
+		if len(s.cancels) == 0 {
+			s.mu.Unlock()
+			return nil
+		}
+
+		changed := s.changed
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Cancel cancels the streams being served, returning how many there were.
+func (s *Streams) Cancel() int {
+	if s == nil {
+		return 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, cancel := range s.cancels {
+		cancel()
+	}
+
+	return len(s.cancels)
+}
+
+// Drain drains the server before it is decommissioned: it stops accepting
+// produces, hands off any leadership, waits for its streams to finish for up
+// to the request's timeout, or the Drainer's default if it is zero, and
+// leaves the cluster. It returns once the server has left. Only subjects
+// allowed to administer the cluster may drain servers. It returns
+// Unimplemented if the server was not configured with a Drainer.
+func (s *grpcServer) Drain(ctx context.Context, req *api.DrainRequest) (*api.DrainResponse, error) {
+
+	if err := s.authorize(
+		ctx,
+		clusterObject,
+		adminAction,
+	); err != nil {
+		return nil, err
+	}
+
+	if s.Drainer == nil {
+		return nil, status.Error(codes.Unimplemented, "drain is not supported")
+	}
+
+	return s.Drainer.Drain(time.Duration(req.TimeoutSeconds) * time.Second)
+}
+
+// checkDraining returns an ErrorNodeDraining if the server is draining.
+func (s *grpcServer) checkDraining() error {
+	if s.Drainer == nil {
+		return nil
+	}
+
+	if redirect, draining := s.Drainer.Draining(); draining {
+		return api.ErrorNodeDraining{Redirect: redirect}
+	}
+
+	return nil
+}
+
+// recv receives the next request of a stream, returning early once ctx is
+// done with an ErrorNodeDraining if the server is draining, as the stream
+// was cancelled by the drain.
+func (s *grpcServer) recv(
+	ctx context.Context,
+	stream api.Log_ProduceStreamServer,
+) (*api.ProduceRequest, error) {
+	type result struct {
+		req *api.ProduceRequest
+		err error
+	}
+
+	results := make(chan result, 1)
+
+	go func() {
+		req, err := stream.Recv()
+		results <- result{req, err}
+	}()
+
+	select {
+	case res := <-results:
+		return res.req, res.err
+	case <-ctx.Done():
+		if err := s.checkDraining(); err != nil {
+			return nil, err
+		}
+
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+}
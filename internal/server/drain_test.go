@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestDrain tests that a draining server refuses produces with the address
+// to redirect them to, refuses new produce streams, and that the drain
+// cancels the streams still open when it times out.
+func TestDrain(t *testing.T) {
+	drainer := &fakeDrainer{redirect: "127.0.0.1:8400"}
+
+	client, nobody, _, teardown := setupTest(t, func(c *Config) {
+		c.Streams = &Streams{}
+		c.Drainer = drainer
+		drainer.streams = c.Streams
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	record := &api.ProduceRequest{Record: &api.Record{Value: []byte("hello world")}}
+
+	_, err := client.Produce(ctx, record)
+	require.NoError(t, err)
+
+	produce, err := client.ProduceStream(ctx)
+	require.NoError(t, err)
+	require.NoError(t, produce.Send(record))
+	_, err = produce.Recv()
+	require.NoError(t, err)
+
+	consume, err := client.ConsumeStream(ctx, &api.ConsumeRequest{})
+	require.NoError(t, err)
+	_, err = consume.Recv()
+	require.NoError(t, err)
+
+	_, err = nobody.Drain(ctx, &api.DrainRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	res, err := client.Drain(ctx, &api.DrainRequest{TimeoutSeconds: 1})
+	require.NoError(t, err)
+	require.EqualValues(t, 2, res.CancelledStreams)
+	require.Equal(t, time.Second, drainer.timeout)
+
+	_, err = client.Produce(ctx, record)
+	requireDraining(t, err, drainer.redirect)
+
+	_, err = produce.Recv()
+	requireDraining(t, err, drainer.redirect)
+
+	for {
+		_, err = consume.Recv()
+
+		if err != nil {
+			break
+		}
+	}
+	require.Equal(t, io.EOF, err)
+
+	produce, err = client.ProduceStream(ctx)
+	require.NoError(t, err)
+	_, err = produce.Recv()
+	requireDraining(t, err, drainer.redirect)
+
+	// Consumes are still served.
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+}
+
+// TestDrainProduceStream tests that a produce stream opened before the server
+// began draining cannot append any more records to the log.
+func TestDrainProduceStream(t *testing.T) {
+	drainer := &fakeDrainer{redirect: "127.0.0.1:8400"}
+
+	client, _, _, teardown := setupTest(t, func(c *Config) {
+		c.Streams = &Streams{}
+		c.Drainer = drainer
+		drainer.streams = c.Streams
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	record := &api.ProduceRequest{Record: &api.Record{Value: []byte("hello world")}}
+
+	produce, err := client.ProduceStream(ctx)
+	require.NoError(t, err)
+	require.NoError(t, produce.Send(record))
+	_, err = produce.Recv()
+	require.NoError(t, err)
+
+	// The stream stays open, as the drain has not cancelled it.
+	drainer.mu.Lock()
+	drainer.draining = true
+	drainer.mu.Unlock()
+
+	require.NoError(t, produce.Send(record))
+	_, err = produce.Recv()
+	requireDraining(t, err, drainer.redirect)
+
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 1})
+	require.Error(t, err)
+}
+
+// requireDraining checks that err is an Unavailable error redirecting to
+// redirect.
+func requireDraining(t *testing.T, err error, redirect string) {
+	t.Helper()
+
+	st := status.Convert(err)
+	require.Equal(t, codes.Unavailable, st.Code())
+
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			require.Equal(t, "NODE_DRAINING", info.Reason)
+			require.Equal(t, redirect, info.Metadata["redirect_addr"])
+			return
+		}
+	}
+
+	t.Fatal("error has no ErrorInfo detail")
+}
+
+// fakeDrainer drains by waiting for the server's streams and cancelling
+// those still open when the timeout expires.
+type fakeDrainer struct {
+	redirect string
+	streams  *Streams
+
+	mu       sync.Mutex
+	draining bool
+	timeout  time.Duration
+}
+
+func (d *fakeDrainer) Draining() (string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.redirect, d.draining
+}
+
+func (d *fakeDrainer) Drain(timeout time.Duration) (*api.DrainResponse, error) {
+	d.mu.Lock()
+	d.draining = true
+	d.timeout = timeout
+	d.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	res := &api.DrainResponse{}
+
+	if err := d.streams.Wait(ctx); err != nil {
+		res.CancelledStreams = uint32(d.streams.Cancel())
+	}
+
+	return res, nil
+}
@@ -0,0 +1,415 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// TailPollInterval is how long the tail endpoint waits before checking again
+// for a record that has not been appended yet.
+var TailPollInterval = 50 * time.Millisecond
+
+const maxProduceBodyBytes = 4 << 20
+
+type httpServer struct {
+	*grpcServer
+	upgrader websocket.Upgrader
+	logger   *zap.Logger
+}
+
+// NewHTTPHandler returns an HTTP handler exposing the log as JSON. Requests
+// are served by the same Produce and Consume logic as the gRPC server, so
+// they use the config's CommitLog and Authorizer, with the subject taken from
+// the verified client certificate of the TLS connection. Messages are encoded
+// with the protobuf JSON mapping, so record values are base64 encoded. The
+// handler serves:
+//
+//	POST /v1/records          produce the ProduceRequest in the body
+//	GET  /v1/records/{offset} consume the record at offset, optionally
+//	                          bounded by min_offset and max_staleness_ms
+//	GET  /v1/tail?offset=N    stream records from offset as server-sent events
+//	GET  /v1/ws/tail?offset=N stream records from offset over a WebSocket
+//
+// Errors are returned as JSON with an HTTP status derived from the gRPC code;
+// offsets outside the log map to 404 and authorization failures to 403.
+// Requests count against the config's Quotas like gRPC requests, and those
+// rejected by them respond with 429 and a Retry-After header.
+func NewHTTPHandler(config *Config) (http.Handler, error) {
+	srv, err := newgrpcServer(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	h := &httpServer{
+		grpcServer: srv,
+		logger:     zap.L().Named("http"),
+	}
+
+	if len(config.AllowedOrigins) > 0 {
+		h.upgrader.CheckOrigin = h.checkOrigin
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/records", h.handleProduce)
+	mux.HandleFunc("GET /v1/records/{offset}", h.handleConsume)
+	mux.HandleFunc("GET /v1/tail", h.handleTail)
+	mux.HandleFunc("GET /v1/ws/tail", h.handleWebSocketTail)
+
+	return mux, nil
+}
+
+// handleProduce decodes a ProduceRequest from the request body, appends its
+// record to the log, and responds with the ProduceResponse.
+func (h *httpServer) handleProduce(w http.ResponseWriter, r *http.Request) {
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxProduceBodyBytes))
+
+	if err != nil {
+		h.writeError(w, status.Error(codes.InvalidArgument, err.Error()))
+		return
+	}
+
+	req := &api.ProduceRequest{}
+
+	if err = protojson.Unmarshal(body, req); err != nil || req.Record == nil {
+		h.writeError(w, status.Error(codes.InvalidArgument, "invalid produce request"))
+		return
+	}
+
+	ctx, err := h.httpContext(r)
+
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	res, err := h.limit(
+		ctx,
+		req,
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return h.Produce(ctx, req.(*api.ProduceRequest))
+		},
+	)
+
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeMessage(w, http.StatusOK, res.(*api.ProduceResponse))
+}
+
+// handleConsume reads the record at the offset in the request path and
+// responds with the ConsumeResponse. The topic, min_offset and
+// max_staleness_ms query parameters set the ConsumeRequest's fields of the
+// same names.
+func (h *httpServer) handleConsume(w http.ResponseWriter, r *http.Request) {
+
+	offset, err := strconv.ParseUint(r.PathValue("offset"), 10, 64)
+
+	if err != nil {
+		h.writeError(w, status.Error(codes.InvalidArgument, "invalid offset"))
+		return
+	}
+
+	req := &api.ConsumeRequest{
+		Offset: offset,
+		Topic:  r.URL.Query().Get("topic"),
+	}
+
+	if req.MinOffset, err = uintQuery(r, "min_offset"); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	maxStaleness, err := uintQuery(r, "max_staleness_ms")
+
+	if err != nil || maxStaleness > math.MaxUint32 {
+		h.writeError(w, status.Error(codes.InvalidArgument, "invalid max_staleness_ms"))
+		return
+	}
+
+	req.MaxStalenessMs = uint32(maxStaleness)
+
+	ctx, err := h.httpContext(r)
+
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	res, err := h.limit(
+		ctx,
+		req,
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return h.Consume(ctx, req.(*api.ConsumeRequest))
+		},
+	)
+
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeMessage(w, http.StatusOK, res.(*api.ConsumeResponse))
+}
+
+// handleTail streams records to the client as server-sent events, starting at
+// the offset query parameter, or 0 if it is absent. Like ConsumeStream, it
+// waits for records that have not been appended yet and keeps streaming until
+// the client disconnects. Each event carries the record's offset as its id
+// and the ConsumeResponse as its data.
+func (h *httpServer) handleTail(w http.ResponseWriter, r *http.Request) {
+
+	offset, err := offsetQuery(r)
+
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+
+	if !ok {
+		h.writeError(w, status.Error(codes.Internal, "streaming unsupported"))
+		return
+	}
+
+	ctx, err := h.httpContext(r)
+
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	// Each record read is authorized again, but only changed decisions are
+	// audited.
+	ctx, done := h.Streams.track(withAuditStream(ctx))
+	defer done()
+
+	if err := h.authorize(
+		ctx,
+		h.topicObject(),
+		consumeAction,
+	); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	err = h.tail(ctx, offset, func(res *api.ConsumeResponse) error {
+		b, err := protojson.Marshal(res)
+
+		if err != nil {
+			return err
+		}
+
+		if _, err = fmt.Fprintf(
+			w,
+			"id: %d\ndata: %s\n\n",
+			res.Record.Offset,
+			b,
+		); err != nil {
+			return err
+		}
+
+		flusher.Flush()
+
+		return nil
+	})
+
+	if err != nil && ctx.Err() == nil {
+		h.logger.Error("tail failed", zap.Error(err))
+	}
+}
+
+// limit passes req to handler if the subject in ctx is within the config's
+// Quotas, applying them the same way the gRPC server's interceptors do.
+func (h *httpServer) limit(
+	ctx context.Context,
+	req interface{},
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if h.Quotas == nil {
+		return handler(ctx, req)
+	}
+
+	return h.Quotas.unaryQuotaInterceptor(ctx, req, nil, handler)
+}
+
+// tail reads records from offset onwards and passes each one to send until
+// ctx is done, send fails, or reading fails. Offsets past the end of the log
+// are retried every TailPollInterval. Records are sent no faster than the
+// subject's consume quota allows.
+func (h *httpServer) tail(
+	ctx context.Context,
+	offset uint64,
+	send func(*api.ConsumeResponse) error,
+) error {
+
+	for {
+		res, err := h.Consume(ctx, &api.ConsumeRequest{Offset: offset})
+
+		switch err.(type) {
+		case nil:
+		case api.ErrorOffsetOutOfRange:
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(TailPollInterval):
+				continue
+			}
+		default:
+			return err
+		}
+
+		if delay := h.Quotas.ConsumeDelay(subject(ctx)); delay > 0 {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(delay):
+			}
+		}
+
+		h.Quotas.Consumed(subject(ctx), proto.Size(res.Record))
+
+		if err = send(res); err != nil {
+			return err
+		}
+
+		offset++
+	}
+}
+
+// offsetQuery returns the offset query parameter of r, or 0 if it is absent.
+func offsetQuery(r *http.Request) (uint64, error) {
+	return uintQuery(r, "offset")
+}
+
+// uintQuery parses the named query parameter as an unsigned integer,
+// returning 0 if it is absent.
+func uintQuery(r *http.Request, name string) (uint64, error) {
+	v := r.URL.Query().Get(name)
+
+	if v == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseUint(v, 10, 64)
+
+	if err != nil {
+		return 0, status.Errorf(codes.InvalidArgument, "invalid %s", name)
+	}
+
+	return n, nil
+}
+
+// writeMessage writes m as JSON with the given HTTP status code.
+func (h *httpServer) writeMessage(w http.ResponseWriter, code int, m proto.Message) {
+	b, err := protojson.Marshal(m)
+
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(b)
+}
+
+// writeError writes err as a JSON google.rpc.Status with the HTTP status code
+// matching its gRPC code. Errors carrying RetryInfo set the Retry-After
+// header.
+func (h *httpServer) writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+
+	var outOfRange api.ErrorOffsetOutOfRange
+
+	st, _ := status.FromError(err)
+
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			seconds := math.Ceil(info.RetryDelay.AsDuration().Seconds())
+			w.Header().Set("Retry-After", strconv.Itoa(max(int(seconds), 1)))
+		}
+	}
+
+	switch {
+	case errors.As(err, &outOfRange):
+		code = http.StatusNotFound
+	case st.Code() == codes.PermissionDenied:
+		code = http.StatusForbidden
+	case st.Code() == codes.Unauthenticated:
+		code = http.StatusUnauthorized
+	case st.Code() == codes.InvalidArgument:
+		code = http.StatusBadRequest
+	case st.Code() == codes.ResourceExhausted:
+		code = http.StatusTooManyRequests
+	case st.Code() == codes.Unavailable:
+		code = http.StatusServiceUnavailable
+	case st.Code() == codes.Unimplemented:
+		code = http.StatusNotImplemented
+	case st.Code() == codes.DeadlineExceeded:
+		code = http.StatusGatewayTimeout
+	}
+
+	b, merr := protojson.Marshal(st.Proto())
+
+	if merr != nil {
+		http.Error(w, st.Message(), code)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(b)
+}
+
+// httpContext authenticates the request the same way as gRPC requests,
+// presenting its remote address and TLS connection state as the peer's and
+// its Authorization header as metadata, and returns its context carrying the
+// subject and the route, which is audited as the request's method.
+func (h *httpServer) httpContext(r *http.Request) (context.Context, error) {
+	p := &peer.Peer{Addr: httpAddr(r.RemoteAddr)}
+
+	if r.TLS != nil {
+		p.AuthInfo = credentials.TLSInfo{State: *r.TLS}
+	}
+
+	ctx := peer.NewContext(r.Context(), p)
+	ctx = context.WithValue(ctx, methodContextKey{}, r.Pattern)
+
+	if values := r.Header.Values("Authorization"); len(values) > 0 {
+		ctx = metadata.NewIncomingContext(
+			ctx,
+			metadata.MD{"authorization": values},
+		)
+	}
+
+	return h.authenticate(ctx)
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/Gibson-Gichuru/prolog/internal/config"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// TestHTTPGateway runs a series of test scenarios against the HTTP/JSON
+// gateway, served over mutual TLS from the same config as the gRPC server.
+func TestHTTPGateway(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		t *testing.T,
+		url string,
+		rootClient *http.Client,
+		nobodyClient *http.Client,
+	){
+		"produce/consume a message over http succeeds": testHTTPProduceConsume,
+		"consume past log boundary returns not found":  testHTTPConsumePastBoundary,
+		"unauthorized produce/consume is forbidden":    testHTTPUnauthorized,
+		"tail streams records as they are appended":    testHTTPTail,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			url, rootClient, nobodyClient, teardown := setupHTTPTest(t, nil)
+			defer teardown()
+			fn(t, url, rootClient, nobodyClient)
+		})
+	}
+}
+
+// setupHTTPTest serves the gateway for the config created by setupTest over
+// TLS that requires client certificates, and returns its URL along with
+// clients authenticated as root and nobody. If fn is not nil it is called to
+// modify the config before the gateway is created.
+func setupHTTPTest(t *testing.T, fn func(*Config)) (
+	url string,
+	rootClient *http.Client,
+	nobodyClient *http.Client,
+	teardown func(),
+) {
+	t.Helper()
+
+	_, _, cfg, grpcTeardown := setupTest(t, fn)
+
+	handler, err := NewHTTPHandler(cfg)
+	require.NoError(t, err)
+
+	srv := httptest.NewUnstartedServer(handler)
+
+	srv.TLS, err = config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      config.ServerCertFile,
+		KeyFile:       config.ServerKeyFile,
+		CAFile:        config.CAFile,
+		ServerAddress: "127.0.0.1",
+		Server:        true,
+	})
+	require.NoError(t, err)
+
+	srv.StartTLS()
+
+	newClient := func(crtPath, keyPath string) *http.Client {
+		tlsConfig, err := config.SetupTLSConfig(config.TLSConfig{
+			CertFile:      crtPath,
+			KeyFile:       keyPath,
+			CAFile:        config.CAFile,
+			ServerAddress: "127.0.0.1",
+		})
+		require.NoError(t, err)
+
+		return &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		}
+	}
+
+	rootClient = newClient(config.RootCLientCertFile, config.RootClientKeyFile)
+	nobodyClient = newClient(config.NobodyClientCertFile, config.NobodyClientKeyFile)
+
+	return srv.URL, rootClient, nobodyClient, func() {
+		srv.Close()
+		grpcTeardown()
+	}
+}
+
+// produce sends value to the gateway's produce endpoint and returns the
+// response.
+func produce(t *testing.T, client *http.Client, url string, value []byte) *http.Response {
+	t.Helper()
+
+	body, err := protojson.Marshal(&api.ProduceRequest{
+		Record: &api.Record{Value: value},
+	})
+	require.NoError(t, err)
+
+	res, err := client.Post(
+		url+"/v1/records",
+		"application/json",
+		bytes.NewReader(body),
+	)
+	require.NoError(t, err)
+
+	return res
+}
+
+// testHTTPProduceConsume tests that a record produced over HTTP can be
+// consumed over HTTP at the offset it was assigned.
+func testHTTPProduceConsume(
+	t *testing.T,
+	url string,
+	rootClient *http.Client,
+	_ *http.Client,
+) {
+	want := []byte("hello world")
+
+	res := produce(t, rootClient, url, want)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	produced := &api.ProduceResponse{}
+	require.NoError(t, protojson.Unmarshal(body, produced))
+
+	res, err = rootClient.Get(fmt.Sprintf("%s/v1/records/%d", url, produced.Offset))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	consumed := &api.ConsumeResponse{}
+	require.NoError(t, protojson.Unmarshal(body, consumed))
+	require.Equal(t, want, consumed.Record.Value)
+	require.Equal(t, produced.Offset, consumed.Record.Offset)
+}
+
+// testHTTPConsumePastBoundary tests that consuming an offset outside the log
+// responds with 404 Not Found, and that a malformed offset is a bad request.
+func testHTTPConsumePastBoundary(
+	t *testing.T,
+	url string,
+	rootClient *http.Client,
+	_ *http.Client,
+) {
+	res, err := rootClient.Get(url + "/v1/records/1")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	res, err = rootClient.Get(url + "/v1/records/first")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+// testHTTPUnauthorized tests that a client without permission to produce or
+// consume is refused with 403 Forbidden.
+func testHTTPUnauthorized(
+	t *testing.T,
+	url string,
+	_ *http.Client,
+	nobodyClient *http.Client,
+) {
+	res := produce(t, nobodyClient, url, []byte("hello world"))
+	res.Body.Close()
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	res, err := nobodyClient.Get(url + "/v1/records/0")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	res, err = nobodyClient.Get(url + "/v1/tail")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+}
+
+// testHTTPTail tests that the tail endpoint streams existing records from the
+// requested offset and then records appended while it is connected.
+func testHTTPTail(
+	t *testing.T,
+	url string,
+	rootClient *http.Client,
+	_ *http.Client,
+) {
+	values := [][]byte{
+		[]byte("first message"),
+		[]byte("second message"),
+		[]byte("third message"),
+	}
+
+	res := produce(t, rootClient, url, values[0])
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/v1/tail?offset=0", nil)
+	require.NoError(t, err)
+
+	tail, err := rootClient.Do(req)
+	require.NoError(t, err)
+	defer tail.Body.Close()
+	require.Equal(t, http.StatusOK, tail.StatusCode)
+	require.Equal(t, "text/event-stream", tail.Header.Get("Content-Type"))
+
+	for _, value := range values[1:] {
+		res := produce(t, rootClient, url, value)
+		res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(tail.Body)
+
+	for i, value := range values {
+		var id, data string
+
+		for scanner.Scan() && scanner.Text() != "" {
+			line := scanner.Text()
+
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+		require.NoError(t, scanner.Err())
+		require.Equal(t, fmt.Sprint(i), id)
+
+		consumed := &api.ConsumeResponse{}
+		require.NoError(t, protojson.Unmarshal([]byte(data), consumed))
+		require.Equal(t, value, consumed.Record.Value)
+		require.Equal(t, uint64(i), consumed.Record.Offset)
+	}
+}
@@ -0,0 +1,339 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// RetryAfterKey is the trailer metadata key carrying how many milliseconds a
+// client should wait before retrying a request rejected by its quota.
+const RetryAfterKey = "retry-after-ms"
+
+// Quota limits the rate at which a subject may produce and consume records.
+// Each limit is a token bucket refilled at the given rate that holds up to
+// one second's worth of tokens. A limit of zero is unlimited.
+type Quota struct {
+	ProduceBytesPerSecond    float64
+	ProduceRequestsPerSecond float64
+	ConsumeBytesPerSecond    float64
+}
+
+// QuotaConfig configures the quota of each subject. Subjects without an
+// entry in Subjects get the Default quota, and subjects listed in Exempt are
+// not limited at all.
+type QuotaConfig struct {
+	Default  Quota
+	Subjects map[string]Quota
+	// Exempt lists the subjects that are never limited, such as the one the
+	// cluster's servers replicate each other's logs as.
+	Exempt []string
+}
+
+// Quotas enforces the quotas of a QuotaConfig, keeping a set of token
+// buckets per subject. A single Quotas should be shared by every server
+// serving the same log so that a subject's quota applies across all of them.
+type Quotas struct {
+	config QuotaConfig
+
+	mu       sync.Mutex
+	limiters map[string]*limiters
+}
+
+type limiters struct {
+	produceBytes    *bucket
+	produceRequests *bucket
+	consumeBytes    *bucket
+}
+
+// NewQuotas returns Quotas enforcing the given config.
+func NewQuotas(config QuotaConfig) *Quotas {
+	return &Quotas{
+		config:   config,
+		limiters: make(map[string]*limiters),
+	}
+}
+
+// ProduceDelay takes a request and size bytes from subject's produce quota.
+// If the quota cannot cover them, nothing is taken and it returns how long
+// the subject must wait before it can. It returns 0 when the produce is
+// allowed, or if q is nil.
+func (q *Quotas) ProduceDelay(subject string, size int) time.Duration {
+	if q == nil {
+		return 0
+	}
+
+	l := q.get(subject)
+	now := time.Now()
+
+	delay := max(
+		l.produceRequests.delay(1, now),
+		l.produceBytes.delay(size, now),
+	)
+
+	if delay > 0 {
+		return delay
+	}
+
+	l.produceRequests.take(1, now)
+	l.produceBytes.take(size, now)
+
+	return 0
+}
+
+// ConsumeDelay returns how long subject must wait before its consume quota
+// has bytes available again, or 0 if it can consume now or q is nil.
+func (q *Quotas) ConsumeDelay(subject string) time.Duration {
+	if q == nil {
+		return 0
+	}
+
+	return q.get(subject).consumeBytes.delay(1, time.Now())
+}
+
+// Consumed takes size bytes from subject's consume quota. Since the size of
+// a record is only known once it has been read, the quota may go into debt,
+// which later consumes must wait to pay off.
+func (q *Quotas) Consumed(subject string, size int) {
+	if q == nil {
+		return
+	}
+
+	q.get(subject).consumeBytes.take(size, time.Now())
+}
+
+// get returns subject's token buckets, creating them from the subject's
+// quota the first time the subject is seen.
+func (q *Quotas) get(subject string) *limiters {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	l, ok := q.limiters[subject]
+
+	if ok {
+		return l
+	}
+
+	quota, ok := q.config.Subjects[subject]
+
+	if !ok {
+		quota = q.config.Default
+	}
+
+	if slices.Contains(q.config.Exempt, subject) {
+		quota = Quota{}
+	}
+
+	l = &limiters{
+		produceBytes:    newBucket(quota.ProduceBytesPerSecond),
+		produceRequests: newBucket(quota.ProduceRequestsPerSecond),
+		consumeBytes:    newBucket(quota.ConsumeBytesPerSecond),
+	}
+
+	q.limiters[subject] = l
+
+	return l
+}
+
+// bucket is a token bucket refilled at rate tokens per second that holds up
+// to one second's worth of tokens. Its tokens can go negative, so that
+// charges made after the fact are paid off before more are allowed. A nil
+// bucket is unlimited.
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// newBucket returns a full bucket refilled at perSecond, or nil if perSecond
+// is not positive.
+func newBucket(perSecond float64) *bucket {
+	if perSecond <= 0 {
+		return nil
+	}
+
+	return &bucket{
+		rate:   perSecond,
+		tokens: perSecond,
+		last:   time.Now(),
+	}
+}
+
+// delay returns how long until the bucket holds n tokens. Requests for more
+// tokens than the bucket can hold only wait for it to be full, so that they
+// can still be served.
+func (b *bucket) delay(n int, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+
+	need := min(float64(n), b.rate)
+
+	if b.tokens >= need {
+		return 0
+	}
+
+	return time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+}
+
+// take removes n tokens from the bucket, going into debt if it holds fewer.
+func (b *bucket) take(n int, now time.Time) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	b.tokens -= float64(n)
+}
+
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = min(b.tokens+elapsed*b.rate, b.rate)
+		b.last = now
+	}
+}
+
+// quotaExceeded returns a ResourceExhausted error telling the client to
+// retry after delay, both as RetryInfo details and in the RetryAfterKey
+// metadata.
+func quotaExceeded(delay time.Duration) (metadata.MD, error) {
+	st := status.New(codes.ResourceExhausted, "quota exceeded")
+
+	if d, err := st.WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(delay),
+	}); err == nil {
+		st = d
+	}
+
+	md := metadata.Pairs(
+		RetryAfterKey,
+		strconv.FormatInt(max(delay.Milliseconds(), 1), 10),
+	)
+
+	return md, st.Err()
+}
+
+// unaryQuotaInterceptor rejects Produce and Consume requests from subjects
+// that have exceeded their quota with a ResourceExhausted error. The bytes
+// of each consumed record are charged to the subject's consume quota.
+func (q *Quotas) unaryQuotaInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+
+	var delay time.Duration
+
+	switch req := req.(type) {
+	case *api.ProduceRequest:
+		delay = q.ProduceDelay(subject(ctx), proto.Size(req.Record))
+	case *api.ConsumeRequest:
+		delay = q.ConsumeDelay(subject(ctx))
+	}
+
+	if delay > 0 {
+		md, err := quotaExceeded(delay)
+		_ = grpc.SetTrailer(ctx, md)
+		return nil, err
+	}
+
+	res, err := handler(ctx, req)
+
+	if res, ok := res.(*api.ConsumeResponse); ok && err == nil {
+		q.Consumed(subject(ctx), proto.Size(res.Record))
+	}
+
+	return res, err
+}
+
+// streamQuotaInterceptor applies quotas to streaming RPCs. A ProduceStream
+// is ended with a ResourceExhausted error when a record would exceed the
+// subject's produce quota, after acknowledging the records produced before
+// it. A ConsumeStream is slowed down to the subject's consume quota instead
+// of being failed.
+func (q *Quotas) streamQuotaInterceptor(
+	srv interface{},
+	stream grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	return handler(srv, &quotaStream{
+		ServerStream: stream,
+		quotas:       q,
+		subject:      subject(stream.Context()),
+	})
+}
+
+type quotaStream struct {
+	grpc.ServerStream
+	quotas  *Quotas
+	subject string
+}
+
+// RecvMsg receives the next message, rejecting it if it is a produce that
+// exceeds the subject's quota.
+func (s *quotaStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	req, ok := m.(*api.ProduceRequest)
+
+	if !ok {
+		return nil
+	}
+
+	if delay := s.quotas.ProduceDelay(s.subject, proto.Size(req.Record)); delay > 0 {
+		md, err := quotaExceeded(delay)
+		s.SetTrailer(md)
+		return err
+	}
+
+	return nil
+}
+
+// SendMsg sends m, first waiting until the subject's consume quota has
+// bytes available if m is a consumed record.
+func (s *quotaStream) SendMsg(m interface{}) error {
+	res, ok := m.(*api.ConsumeResponse)
+
+	if !ok {
+		return s.ServerStream.SendMsg(m)
+	}
+
+	if delay := s.quotas.ConsumeDelay(s.subject); delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-s.Context().Done():
+			return s.Context().Err()
+		case <-timer.C:
+		}
+	}
+
+	s.quotas.Consumed(s.subject, proto.Size(res.Record))
+
+	return s.ServerStream.SendMsg(m)
+}
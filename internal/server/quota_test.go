@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// TestQuotas tests that each subject gets its own token buckets, sized from
+// its quota or the default one, and that consumes may go into debt.
+func TestQuotas(t *testing.T) {
+	q := NewQuotas(QuotaConfig{
+		Default: Quota{
+			ProduceRequestsPerSecond: 2,
+			ConsumeBytesPerSecond:    100,
+		},
+		Subjects: map[string]Quota{
+			"bulk": {ProduceBytesPerSecond: 10},
+			"peer": {ProduceBytesPerSecond: 10},
+		},
+		Exempt: []string{"peer"},
+	})
+
+	require.Zero(t, q.ProduceDelay("root", 1<<20))
+	require.Zero(t, q.ProduceDelay("root", 1<<20))
+	require.Positive(t, q.ProduceDelay("root", 1))
+
+	// Subjects are limited independently.
+	require.Zero(t, q.ProduceDelay("nobody", 1))
+
+	// A rejected produce takes nothing from the bucket.
+	require.Zero(t, q.ProduceDelay("bulk", 6))
+	require.Positive(t, q.ProduceDelay("bulk", 6))
+	require.Zero(t, q.ProduceDelay("bulk", 4))
+
+	// Exempt subjects are not limited, even with a quota of their own.
+	for i := 0; i < 10; i++ {
+		require.Zero(t, q.ProduceDelay("peer", 1<<20))
+	}
+
+	require.Zero(t, q.ConsumeDelay("root"))
+	q.Consumed("root", 150)
+	delay := q.ConsumeDelay("root")
+	require.Greater(t, delay, 400*time.Millisecond)
+	require.LessOrEqual(t, delay, 510*time.Millisecond)
+
+	var unlimited *Quotas
+	require.Zero(t, unlimited.ProduceDelay("root", 1<<20))
+	require.Zero(t, unlimited.ConsumeDelay("root"))
+}
+
+// TestQuotaInterceptors tests that produces over a subject's quota are
+// rejected with ResourceExhausted and told when to retry, while consume
+// streams are slowed down to the quota instead of failing.
+func TestQuotaInterceptors(t *testing.T) {
+	rootClient, nobodyClient, _, teardown := setupTest(t, func(c *Config) {
+		c.Quotas = NewQuotas(QuotaConfig{
+			Default: Quota{
+				ProduceRequestsPerSecond: 1,
+				ConsumeBytesPerSecond:    10,
+			},
+		})
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	record := &api.Record{Value: []byte("hello world")}
+
+	_, err := rootClient.Produce(ctx, &api.ProduceRequest{Record: record})
+	require.NoError(t, err)
+
+	var trailer metadata.MD
+
+	_, err = rootClient.Produce(
+		ctx,
+		&api.ProduceRequest{Record: record},
+		grpc.Trailer(&trailer),
+	)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	retryAfter, err2 := strconv.Atoi(trailer.Get(RetryAfterKey)[0])
+	require.NoError(t, err2)
+	require.Positive(t, retryAfter)
+
+	details := status.Convert(err).Details()
+	require.Len(t, details, 1)
+	require.Positive(t, details[0].(*errdetails.RetryInfo).RetryDelay.AsDuration())
+
+	// Quotas are only applied to authorized requests' subjects, so the
+	// unauthorized client is still refused for its permissions.
+	_, err = nobodyClient.Produce(ctx, &api.ProduceRequest{Record: record})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	time.Sleep(time.Second)
+
+	_, err = rootClient.Produce(ctx, &api.ProduceRequest{Record: record})
+	require.NoError(t, err)
+
+	stream, err := rootClient.ConsumeStream(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+
+	start := time.Now()
+
+	for i := uint64(0); i < 2; i++ {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, i, res.Record.Offset)
+	}
+
+	// The first record is larger than the consume quota's bucket, so the
+	// second one waits for the debt to be paid off.
+	require.Greater(t, time.Since(start), 300*time.Millisecond)
+}
+
+// TestHTTPQuota tests that the gateway applies the same quotas, responding
+// with 429 Too Many Requests and a Retry-After header.
+func TestHTTPQuota(t *testing.T) {
+	url, rootClient, _, teardown := setupHTTPTest(t, func(c *Config) {
+		c.Quotas = NewQuotas(QuotaConfig{
+			Default: Quota{ProduceRequestsPerSecond: 1},
+		})
+	})
+	defer teardown()
+
+	res := produce(t, rootClient, url, []byte("hello world"))
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	res = produce(t, rootClient, url, []byte("hello world"))
+	res.Body.Close()
+	require.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	require.Equal(t, "1", res.Header.Get("Retry-After"))
+}
@@ -2,14 +2,20 @@ package server
 
 import (
 	"context"
-	"strings"
+	"encoding/base64"
+	"io"
 	"time"
 
 	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/Gibson-Gichuru/prolog/internal/auth"
+	"github.com/Gibson-Gichuru/prolog/internal/tracing"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	"go.opencensus.io/plugin/ocgrpc"
 	"go.opencensus.io/stats/view"
-	"go.opencensus.io/trace"
+	octrace "go.opencensus.io/trace"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
@@ -18,24 +24,89 @@ import (
 	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthgrpc "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
+// DefaultTopic is the topic a server's log is served as when its Config does
+// not name one.
+const DefaultTopic = "default"
+
 const (
-	objectWildcard = "*"
+	clusterObject  = "cluster"
+	aclObject      = "acls"
 	produceAction  = "produce"
 	consumeAction  = "consume"
+	adminAction    = "admin"
+	describeAction = "describe"
 )
 
 type Authorizer interface {
 	Authorize(subject, object, action string) error
 }
 
+// Authenticator identifies the subject making a request from the peer and
+// metadata in its context. It reports false if the request does not carry
+// credentials it handles, and returns an error if it rejects them.
+type Authenticator interface {
+	Authenticate(ctx context.Context) (subject string, ok bool, err error)
+}
+
 type Config struct {
+	// Topic names the log served, which is authorized as the resource
+	// "topics/<Topic>". It defaults to DefaultTopic.
+	Topic      string
 	CommitLog  CommitLog
 	Authorizer Authorizer
+	// Authenticators identify the subject of each request, tried in order.
+	// Subjects are the common names of client certificates if empty.
+	Authenticators []Authenticator
+	Snapshotter    Snapshotter
+	Describer      Describer
+	GetServerer    GetServerer
+	// PolicyManager serves the admin RPCs that manage access control
+	// policies. They are unimplemented if nil.
+	PolicyManager PolicyManager
+	// TracerProvider traces requests. The global provider is used if nil.
+	TracerProvider trace.TracerProvider
+	// Health reports the server's serving status through the standard
+	// grpc.health.v1 service. A server that is always serving is used if nil.
+	Health *health.Server
+	// AllowedOrigins lists the browser origins, such as
+	// "https://dashboard.example.com", allowed to open WebSocket connections
+	// to the HTTP gateway. Only same-origin connections are allowed if empty.
+	AllowedOrigins []string
+	// Quotas limits the rate at which each subject may produce and consume.
+	// Subjects are not limited if nil.
+	Quotas *Quotas
+	// GossipKeyManager serves the admin RPCs that rotate the cluster's
+	// gossip encryption keys. They are unimplemented if nil.
+	GossipKeyManager GossipKeyManager
+	// Auditor records every authorization decision. Decisions are not
+	// recorded if nil.
+	Auditor Auditor
+	// AuditLog serves consumes of the AuditTopic, which read the audit
+	// trail recorded by the Auditor. They are unimplemented if nil.
+	AuditLog AuditLog
+	// Drainer drains the server before it is decommissioned, rejecting
+	// produces while it does. Produces are always accepted and the Drain RPC
+	// is unimplemented if nil.
+	Drainer Drainer
+	// Streams tracks the streams being served, so that a draining server can
+	// wait for them. Streams are not tracked if nil.
+	Streams *Streams
+	// Commander serves the admin RPC running commands across the cluster.
+	// It is unimplemented if nil.
+	Commander Commander
+	// ReplicationState tells consumes bounding their staleness whether the
+	// log is up to date enough to serve them. Bounded consumes are
+	// unimplemented if nil.
+	ReplicationState ReplicationState
+	// Acknowledger waits for replicas to acknowledge produces asking for
+	// all in-sync replicas. Such produces are unimplemented if nil.
+	Acknowledger Acknowledger
 }
 
 type CommitLog interface {
@@ -43,11 +114,41 @@ type CommitLog interface {
 	Read(uint64) (*api.Record, error)
 }
 
+type Snapshotter interface {
+	Snapshot(io.Writer) (uint64, error)
+}
+
+type Describer interface {
+	LowestOffset() (uint64, error)
+	HighestOffset() (uint64, error)
+	Segments() []*api.Segment
+}
+
+type GetServerer interface {
+	GetServers() ([]*api.Server, error)
+}
+
+type PolicyManager interface {
+	AddPolicy(subject, object, action string) (bool, error)
+	RemovePolicy(subject, object, action string) (bool, error)
+	AddRole(subject, role string) (bool, error)
+	RemoveRole(subject, role string) (bool, error)
+	Policies() ([]*api.Policy, error)
+}
+
+type GossipKeyManager interface {
+	InstallKey(key string) (*api.GossipKeysResponse, error)
+	UseKey(key string) (*api.GossipKeysResponse, error)
+	RemoveKey(key string) (*api.GossipKeysResponse, error)
+	ListKeys() (*api.GossipKeysResponse, error)
+}
+
 var _ api.LogServer = (*grpcServer)(nil)
 
 type grpcServer struct {
 	api.UnimplementedLogServer
 	*Config
+	traces *traceContexts
 }
 
 // newgrpcServer returns a new gRPC server that wraps the given CommitLog.
@@ -56,26 +157,70 @@ type grpcServer struct {
 func newgrpcServer(Config *Config) (srv *grpcServer, err error) {
 	srv = &grpcServer{
 		Config: Config,
+		traces: newTraceContexts(),
 	}
 	return srv, nil
 }
 
+// topicObject returns the resource name of the server's log used to
+// authorize requests reading or writing it.
+func (s *grpcServer) topicObject() string {
+	topic := s.Topic
+
+	if topic == "" {
+		topic = DefaultTopic
+	}
+
+	return "topics/" + topic
+}
+
 // Produce appends a record to the log and returns the offset.
-// It returns an error if it cannot append the record.
+// When the request is being traced, the trace context it carries in its
+// gRPC metadata is streamed to replicas with the record, so that their
+// appends continue the same trace, but is not stored in the log.
+// A request with ACKS_ALL is only answered once every in-sync replica has
+// replicated the record, or with an ErrorAckTimeout if they do not in time.
+// It returns an ErrorNodeDraining if the server is draining, and an error if
+// it cannot append the record.
 func (s *grpcServer) Produce(ctx context.Context, req *api.ProduceRequest) (*api.ProduceResponse, error) {
 
-	if err := s.Authorizer.Authorize(
-		subject(ctx),
-		objectWildcard,
+	if err := s.checkDraining(); err != nil {
+		return nil, err
+	}
+
+	return s.produce(ctx, req)
+}
+
+// produce authorizes and appends a record to the log, whether or not the
+// server is draining.
+func (s *grpcServer) produce(ctx context.Context, req *api.ProduceRequest) (*api.ProduceResponse, error) {
+
+	if err := s.authorize(
+		ctx,
+		s.topicObject(),
 		produceAction,
 	); err != nil {
 		return nil, err
 	}
 
+	if err := s.checkAcks(req); err != nil {
+		return nil, err
+	}
+
+	// The trace context only travels with the record on its way to the
+	// replicas, never into the log.
+	req.Record.TraceContext = nil
+
 	offset, err := s.CommitLog.Append(req.Record)
 	if err != nil {
 		return nil, err
 	}
+
+	s.traces.add(ctx, offset)
+
+	if err := s.waitAcks(ctx, req, offset); err != nil {
+		return nil, err
+	}
 	return &api.ProduceResponse{
 		Offset: offset,
 	}, nil
@@ -83,16 +228,33 @@ func (s *grpcServer) Produce(ctx context.Context, req *api.ProduceRequest) (*api
 
 // Consume retrieves a record from the log at the specified offset
 // provided in the ConsumeRequest. It returns a ConsumeResponse
-// containing the record, or an error if the record cannot be read.
+// containing the record, or an error if the record cannot be read. A
+// request with a MinOffset or MaxStalenessMs is refused with an
+// ErrorStaleRead carrying the leader's address if the log is too far behind
+// the leader's to serve it. A request naming the AuditTopic reads the audit
+// log instead, and one naming any other topic than the server's is refused
+// with NotFound.
 func (s *grpcServer) Consume(ctx context.Context, req *api.ConsumeRequest) (*api.ConsumeResponse, error) {
 
-	if err := s.Authorizer.Authorize(
-		subject(ctx),
-		objectWildcard,
+	if req.Topic == AuditTopic {
+		return s.consumeAudit(ctx, req)
+	}
+
+	if req.Topic != "" && "topics/"+req.Topic != s.topicObject() {
+		return nil, status.Errorf(codes.NotFound, "topic %q not found", req.Topic)
+	}
+
+	if err := s.authorize(
+		ctx,
+		s.topicObject(),
 		consumeAction,
 	); err != nil {
 		return nil, err
 	}
+
+	if err := s.checkStaleness(req); err != nil {
+		return nil, err
+	}
 	record, err := s.CommitLog.Read(req.Offset)
 	if err != nil {
 		return nil, err
@@ -104,23 +266,45 @@ func (s *grpcServer) Consume(ctx context.Context, req *api.ConsumeRequest) (*api
 
 // ProduceStream streams records to the log. It takes a stream of ProduceRequest
 // messages and for each message appends the given record to the log and sends
-// a ProduceResponse message containing the offset. It returns an error if it
-// cannot append the record or if the stream is closed.
+// a ProduceResponse message containing the offset, except for requests with
+// ACKS_NONE, which are not answered. It returns an error if it cannot
+// append the record, and nil once the client closes the stream. While the
+// server is draining, new streams are refused, and streams opened before it
+// began draining end with an ErrorNodeDraining at their next record.
 func (s *grpcServer) ProduceStream(stream api.Log_ProduceStreamServer) error {
 
+	if err := s.checkDraining(); err != nil {
+		return err
+	}
+
+	ctx, done := s.Streams.track(withAuditStream(stream.Context()))
+	defer done()
+
 	for {
-		req, err := stream.Recv()
+		req, err := s.recv(ctx, stream)
+
+		if err == io.EOF {
+			return nil
+		}
 
 		if err != nil {
 			return err
 		}
 
-		res, err := s.Produce(stream.Context(), req)
+		if err := s.checkDraining(); err != nil {
+			return err
+		}
+
+		res, err := s.produce(ctx, req)
 
 		if err != nil {
 			return err
 		}
 
+		if req.Acks == api.Acks_ACKS_NONE {
+			continue
+		}
+
 		if err = stream.Send(res); err != nil {
 			return err
 		}
@@ -132,18 +316,22 @@ func (s *grpcServer) ProduceStream(stream api.Log_ProduceStreamServer) error {
 // messages back to the client for each record read from the log. The stream
 // terminates when the context is done or an error occurs while reading or sending
 // records. If the offset is out of range, it will continue to attempt to read the
-// next available record.
+// next available record. Records recently produced with a trace context are
+// sent with it, so replicas appending them continue the trace.
 func (s *grpcServer) ConsumeStream(
 	req *api.ConsumeRequest,
 	stream api.Log_ConsumeStreamServer,
 ) error {
+	ctx, done := s.Streams.track(withAuditStream(stream.Context()))
+	defer done()
+
 	for {
 		select {
-		case <-stream.Context().Done():
+		case <-ctx.Done():
 			return nil
 
 		default:
-			res, err := s.Consume(stream.Context(), req)
+			res, err := s.Consume(ctx, req)
 
 			switch err.(type) {
 			case nil:
@@ -153,6 +341,10 @@ func (s *grpcServer) ConsumeStream(
 				return err
 			}
 
+			if req.Topic != AuditTopic {
+				res.Record.TraceContext = s.traces.get(res.Record.Offset)
+			}
+
 			if err = stream.Send(res); err != nil {
 				return err
 			}
@@ -161,9 +353,363 @@ func (s *grpcServer) ConsumeStream(
 	}
 }
 
+// Snapshot streams a point-in-time snapshot of the log back to the caller. The
+// snapshot is sent as a sequence of chunks, followed by a final message that
+// carries the highest offset contained in the snapshot. Only subjects allowed
+// to perform the admin action may take snapshots. It returns Unimplemented if
+// the server was not configured with a Snapshotter.
+func (s *grpcServer) Snapshot(
+	req *api.SnapshotRequest,
+	stream api.Log_SnapshotServer,
+) error {
+
+	if err := s.authorize(
+		stream.Context(),
+		s.topicObject(),
+		adminAction,
+	); err != nil {
+		return err
+	}
+
+	if s.Snapshotter == nil {
+		return status.Error(codes.Unimplemented, "snapshots are not supported")
+	}
+
+	offset, err := s.Snapshotter.Snapshot(&snapshotWriter{stream: stream})
+
+	if err != nil {
+		return err
+	}
+
+	return stream.Send(&api.SnapshotResponse{
+		Offset: offset,
+	})
+}
+
+type snapshotWriter struct {
+	stream api.Log_SnapshotServer
+}
+
+// Write sends p to the client as a single snapshot chunk. It returns the
+// number of bytes written and any error encountered while sending.
+func (w *snapshotWriter) Write(p []byte) (int, error) {
+	if err := w.stream.Send(&api.SnapshotResponse{Chunk: p}); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Describe returns the lowest and highest offsets held by the log together
+// with a description of each of its segments, and how far it has replicated
+// each of its peers' logs if the server was configured with a
+// ReplicationState. It returns Unimplemented if the
+// server was not configured with a Describer.
+func (s *grpcServer) Describe(ctx context.Context, req *api.DescribeRequest) (*api.DescribeResponse, error) {
+
+	if err := s.authorize(
+		ctx,
+		s.topicObject(),
+		describeAction,
+	); err != nil {
+		return nil, err
+	}
+
+	if s.Describer == nil {
+		return nil, status.Error(codes.Unimplemented, "describe is not supported")
+	}
+
+	lowest, err := s.Describer.LowestOffset()
+
+	if err != nil {
+		return nil, err
+	}
+
+	highest, err := s.Describer.HighestOffset()
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := &api.DescribeResponse{
+		LowestOffset:  lowest,
+		HighestOffset: highest,
+		Segments:      s.Describer.Segments(),
+	}
+
+	if s.ReplicationState != nil {
+		res.Replicated = s.ReplicationState.Replicated()
+	}
+
+	return res, nil
+}
+
+// GetServers returns the servers that are currently part of the cluster,
+// including their RPC addresses and whether they are the leader. It returns
+// Unimplemented if the server was not configured with a GetServerer.
+func (s *grpcServer) GetServers(ctx context.Context, req *api.GetServersRequest) (*api.GetServersResponse, error) {
+
+	if err := s.authorize(
+		ctx,
+		clusterObject,
+		describeAction,
+	); err != nil {
+		return nil, err
+	}
+
+	if s.GetServerer == nil {
+		return nil, status.Error(codes.Unimplemented, "get servers is not supported")
+	}
+
+	servers, err := s.GetServerer.GetServers()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.GetServersResponse{
+		Servers: servers,
+	}, nil
+}
+
+// AddPolicy allows the request's policy subject to perform its action on its
+// object, or grants it the policy's role, persisting the change. Only subjects
+// allowed to administer the server may change policies. It returns
+// Unimplemented if the server was not configured with a PolicyManager.
+func (s *grpcServer) AddPolicy(ctx context.Context, req *api.AddPolicyRequest) (*api.AddPolicyResponse, error) {
+
+	policy, err := s.policyRequest(ctx, req.Policy)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var added bool
+
+	if policy.Role != "" {
+		added, err = s.PolicyManager.AddRole(policy.Subject, policy.Role)
+	} else {
+		added, err = s.PolicyManager.AddPolicy(
+			policy.Subject,
+			policy.Object,
+			policy.Action,
+		)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.AddPolicyResponse{Added: added}, nil
+}
+
+// RemovePolicy stops allowing the request's policy subject to perform its
+// action on its object, or takes the policy's role away from it, persisting
+// the change. Only subjects allowed to administer the server may change
+// policies. It returns Unimplemented if the server was not configured with a
+// PolicyManager.
+func (s *grpcServer) RemovePolicy(ctx context.Context, req *api.RemovePolicyRequest) (*api.RemovePolicyResponse, error) {
+
+	policy, err := s.policyRequest(ctx, req.Policy)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var removed bool
+
+	if policy.Role != "" {
+		removed, err = s.PolicyManager.RemoveRole(policy.Subject, policy.Role)
+	} else {
+		removed, err = s.PolicyManager.RemovePolicy(
+			policy.Subject,
+			policy.Object,
+			policy.Action,
+		)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.RemovePolicyResponse{Removed: removed}, nil
+}
+
+// ListPolicies returns the policies currently enforced, including the roles
+// assigned to subjects as policies with a subject and role. Only subjects allowed
+// to administer the server may list policies. It returns Unimplemented if the
+// server was not configured with a PolicyManager.
+func (s *grpcServer) ListPolicies(ctx context.Context, req *api.ListPoliciesRequest) (*api.ListPoliciesResponse, error) {
+
+	if err := s.authorizePolicies(ctx); err != nil {
+		return nil, err
+	}
+
+	policies, err := s.PolicyManager.Policies()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.ListPoliciesResponse{Policies: policies}, nil
+}
+
+// authorizePolicies checks that the caller may administer the server and
+// that the server can manage policies.
+func (s *grpcServer) authorizePolicies(ctx context.Context) error {
+
+	if err := s.authorize(
+		ctx,
+		aclObject,
+		adminAction,
+	); err != nil {
+		return err
+	}
+
+	if s.PolicyManager == nil {
+		return status.Error(codes.Unimplemented, "policy management is not supported")
+	}
+
+	return nil
+}
+
+// policyRequest authorizes a request changing policy, returning an
+// InvalidArgument error unless the policy has a subject and either an object
+// and action or a role.
+func (s *grpcServer) policyRequest(ctx context.Context, policy *api.Policy) (*api.Policy, error) {
+
+	if err := s.authorizePolicies(ctx); err != nil {
+		return nil, err
+	}
+
+	if policy.GetRole() != "" {
+		if policy.GetSubject() == "" || policy.GetObject() != "" || policy.GetAction() != "" {
+			return nil, status.Error(
+				codes.InvalidArgument,
+				"role assignment requires a subject and no object or action",
+			)
+		}
+
+		return policy, nil
+	}
+
+	if policy.GetSubject() == "" || policy.GetObject() == "" || policy.GetAction() == "" {
+		return nil, status.Error(
+			codes.InvalidArgument,
+			"policy requires a subject, object and action",
+		)
+	}
+
+	return policy, nil
+}
+
+// InstallGossipKey installs the request's base64 encoded key on every member
+// of the cluster, ready to be used with UseGossipKey. Only subjects allowed to
+// administer the cluster may change gossip keys. It returns Unimplemented if
+// the server was not configured with a GossipKeyManager.
+func (s *grpcServer) InstallGossipKey(ctx context.Context, req *api.GossipKeyRequest) (*api.GossipKeysResponse, error) {
+
+	if err := s.gossipKeyRequest(ctx, req); err != nil {
+		return nil, err
+	}
+
+	return gossipKeysResponse(s.GossipKeyManager.InstallKey(req.Key))
+}
+
+// UseGossipKey makes the request's installed key the one encrypting gossip
+// on every member of the cluster.
+func (s *grpcServer) UseGossipKey(ctx context.Context, req *api.GossipKeyRequest) (*api.GossipKeysResponse, error) {
+
+	if err := s.gossipKeyRequest(ctx, req); err != nil {
+		return nil, err
+	}
+
+	return gossipKeysResponse(s.GossipKeyManager.UseKey(req.Key))
+}
+
+// RemoveGossipKey removes the request's key from every member of the
+// cluster. The key in use cannot be removed.
+func (s *grpcServer) RemoveGossipKey(ctx context.Context, req *api.GossipKeyRequest) (*api.GossipKeysResponse, error) {
+
+	if err := s.gossipKeyRequest(ctx, req); err != nil {
+		return nil, err
+	}
+
+	return gossipKeysResponse(s.GossipKeyManager.RemoveKey(req.Key))
+}
+
+// ListGossipKeys returns the gossip keys installed across the cluster and
+// how many members have each installed.
+func (s *grpcServer) ListGossipKeys(ctx context.Context, req *api.ListGossipKeysRequest) (*api.GossipKeysResponse, error) {
+
+	if err := s.authorizeGossipKeys(ctx); err != nil {
+		return nil, err
+	}
+
+	return gossipKeysResponse(s.GossipKeyManager.ListKeys())
+}
+
+// authorizeGossipKeys checks that the caller may administer the cluster and
+// that the server can manage gossip keys.
+func (s *grpcServer) authorizeGossipKeys(ctx context.Context) error {
+
+	if err := s.authorize(
+		ctx,
+		clusterObject,
+		adminAction,
+	); err != nil {
+		return err
+	}
+
+	if s.GossipKeyManager == nil {
+		return status.Error(codes.Unimplemented, "gossip key management is not supported")
+	}
+
+	return nil
+}
+
+// gossipKeyRequest authorizes a request changing gossip keys, returning an
+// InvalidArgument error unless its key is a base64 encoded AES key.
+func (s *grpcServer) gossipKeyRequest(ctx context.Context, req *api.GossipKeyRequest) error {
+
+	if err := s.authorizeGossipKeys(ctx); err != nil {
+		return err
+	}
+
+	key, err := base64.StdEncoding.DecodeString(req.GetKey())
+
+	if err != nil || (len(key) != 16 && len(key) != 24 && len(key) != 32) {
+		return status.Error(
+			codes.InvalidArgument,
+			"key must be a base64 encoded 16, 24 or 32 byte key",
+		)
+	}
+
+	return nil
+}
+
+// gossipKeysResponse returns the response of a gossip key operation, or a
+// FailedPrecondition error if no member could perform it.
+func gossipKeysResponse(res *api.GossipKeysResponse, err error) (*api.GossipKeysResponse, error) {
+
+	if _, ok := status.FromError(err); !ok {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
 // NewGRPCServer returns a new gRPC server that wraps the given CommitLog.
 // It registers the server with the gRPC API and returns the gRPC server and
-// an error if any.
+// an error if any. The grpc.health.v1 service is registered alongside it,
+// backed by the config's Health server. Requests are traced with the
+// config's TracerProvider, or the global one if it is nil, while OpenCensus
+// only records metrics. When the config has Quotas, they are enforced on
+// authenticated requests.
 func NewGRPCServer(config *Config, opts ...grpc.ServerOption) (*grpc.Server, error) {
 
 	logger := zap.L().Named("server")
@@ -179,81 +725,124 @@ func NewGRPCServer(config *Config, opts ...grpc.ServerOption) (*grpc.Server, err
 		),
 	}
 
-	halfSampler := trace.ProbabilitySampler(0.5)
-
-	trace.ApplyConfig(trace.Config{
-		DefaultSampler: func(sp trace.SamplingParameters) trace.SamplingDecision {
-			if strings.Contains(sp.Name, "Produce") {
-				return trace.SamplingDecision{Sample: true}
-			}
+	tracerProvider := config.TracerProvider
 
-			return halfSampler(sp)
-		},
-	})
+	if tracerProvider == nil {
+		tracerProvider = otel.GetTracerProvider()
+	}
 
 	err := view.Register(ocgrpc.DefaultServerViews...)
 	if err != nil {
 		return nil, err
 	}
 
+	srv, err := newgrpcServer(config)
+	if err != nil {
+		return nil, err
+	}
+
+	streamInterceptors := []grpc.StreamServerInterceptor{
+		grpc_ctxtags.StreamServerInterceptor(),
+		grpc_zap.StreamServerInterceptor(logger, zpOpts...),
+		grpc_auth.StreamServerInterceptor(srv.authenticate),
+	}
+
+	unaryInterceptors := []grpc.UnaryServerInterceptor{
+		grpc_ctxtags.UnaryServerInterceptor(),
+		grpc_zap.UnaryServerInterceptor(logger, zpOpts...),
+		grpc_auth.UnaryServerInterceptor(srv.authenticate),
+	}
+
+	if config.Quotas != nil {
+		streamInterceptors = append(
+			streamInterceptors,
+			config.Quotas.streamQuotaInterceptor,
+		)
+		unaryInterceptors = append(
+			unaryInterceptors,
+			config.Quotas.unaryQuotaInterceptor,
+		)
+	}
+
 	opts = append(opts,
 		grpc.StreamInterceptor(
-			grpc_middleware.ChainStreamServer(
-				grpc_ctxtags.StreamServerInterceptor(),
-				grpc_zap.StreamServerInterceptor(logger, zpOpts...),
-				grpc_auth.StreamServerInterceptor(authenticate),
-			),
+			grpc_middleware.ChainStreamServer(streamInterceptors...),
 		),
 		grpc.UnaryInterceptor(
-			grpc_middleware.ChainUnaryServer(
-				grpc_ctxtags.UnaryServerInterceptor(),
-				grpc_zap.UnaryServerInterceptor(logger, zpOpts...),
-				grpc_auth.UnaryServerInterceptor(authenticate),
-			),
+			grpc_middleware.ChainUnaryServer(unaryInterceptors...),
 		),
-		grpc.StatsHandler(&ocgrpc.ServerHandler{}),
+		grpc.StatsHandler(&ocgrpc.ServerHandler{
+			StartOptions: octrace.StartOptions{
+				Sampler: octrace.NeverSample(),
+			},
+		}),
+		grpc.StatsHandler(otelgrpc.NewServerHandler(
+			otelgrpc.WithTracerProvider(tracerProvider),
+			otelgrpc.WithPropagators(tracing.Propagator),
+		)),
 	)
 
 	gsrv := grpc.NewServer(opts...)
 
-	srv, err := newgrpcServer(config)
-	if err != nil {
-		return nil, err
-	}
 	api.RegisterLogServer(gsrv, srv)
 
+	healthServer := config.Health
+
+	if healthServer == nil {
+		healthServer = health.NewServer()
+	}
+
+	healthgrpc.RegisterHealthServer(gsrv, healthServer)
+
 	return gsrv, nil
 }
 
-// authenticate extracts the subject from the TLS connection and stores it in the
-// context. The subject is used to authorize the request. If the connection is not
-// TLS, the subject is set to the empty string.
-func authenticate(ctx context.Context) (context.Context, error) {
+// authenticate identifies the caller with the config's Authenticators, tried
+// in order, and stores the subject from the first to accept the request's
+// credentials in the context, where it is used to authorize the request.
+// Requests whose credentials are rejected, or that carry a bearer token no
+// authenticator accepts, fail with Unauthenticated. Requests without
+// credentials are given the empty subject.
+func (s *grpcServer) authenticate(ctx context.Context) (context.Context, error) {
 
-	peer, ok := peer.FromContext(ctx)
-
-	if !ok {
+	if _, ok := peer.FromContext(ctx); !ok {
 		return ctx, status.New(
 			codes.Unknown,
 			"no peer info found",
 		).Err()
 	}
 
-	if peer.AuthInfo == nil {
-		return context.WithValue(ctx, subjectContextKey{}, ""), nil
+	authenticators := s.Authenticators
+
+	if len(authenticators) == 0 {
+		authenticators = []Authenticator{auth.TLSAuthenticator{}}
 	}
 
-	tlsInfo := peer.AuthInfo.(credentials.TLSInfo)
+	for _, authenticator := range authenticators {
+		subject, ok, err := authenticator.Authenticate(ctx)
 
-	subject := tlsInfo.State.VerifiedChains[0][0].Subject.CommonName
+		if err != nil {
+			return ctx, status.Errorf(
+				codes.Unauthenticated,
+				"authentication failed: %v",
+				err,
+			)
+		}
+
+		if ok {
+			return context.WithValue(ctx, subjectContextKey{}, subject), nil
+		}
+	}
+
+	if _, ok := auth.BearerToken(ctx); ok {
+		return ctx, status.Error(codes.Unauthenticated, "invalid bearer token")
+	}
 
-	ctx = context.WithValue(ctx, subjectContextKey{}, subject)
-	return ctx, nil
+	return context.WithValue(ctx, subjectContextKey{}, ""), nil
 }
 
-// subject returns the subject from the TLS connection stored in the context by
-// the authenticate interceptor. If the connection is not TLS, the subject is
-// the empty string.
+// subject returns the subject stored in the context by authenticate. If the
+// request had no credentials, the subject is the empty string.
 func subject(ctx context.Context) string {
 	return ctx.Value(subjectContextKey{}).(string)
 }
@@ -1,10 +1,16 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"flag"
+	"io"
 	"net"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -18,7 +24,12 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 var debug = flag.Bool("debug", false, "Enable verbose debug logging")
@@ -55,6 +66,9 @@ func TestServer(t *testing.T) {
 		"produce/consume stream succeeds":                    testProduceConsumeStream,
 		"consume past log boundary fails":                    testConsumePastBoundary,
 		"unauthorized produce/consume fails":                 testUnathorized,
+		"snapshot can be restored":                           testSnapshot,
+		"unauthorized snapshot fails":                        testUnauthorizedSnapshot,
+		"describe reports offsets and segments":              testDescribe,
 	} {
 		t.Run(scenario, func(t *testing.T) {
 			rootClient, nobodyClient, config, teadown := setupTest(t, nil)
@@ -64,6 +78,41 @@ func TestServer(t *testing.T) {
 	}
 }
 
+// TestHealth tests that NewGRPCServer registers the grpc.health.v1 service
+// backed by the configured health server.
+func TestHealth(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	healthServer := health.NewServer()
+
+	srv, err := NewGRPCServer(&Config{Health: healthServer})
+	require.NoError(t, err)
+
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient(
+		l.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	client := healthpb.NewHealthClient(conn)
+	ctx := context.Background()
+
+	res, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, res.Status)
+
+	healthServer.Shutdown()
+
+	res, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, res.Status)
+}
+
 // setupTest returns a client, config, and teardown function for testing the
 // server. It will create a temporary directory, create a log in that directory,
 // and start a server listening on a random port. The client will be able to
@@ -127,7 +176,8 @@ func setupTest(t *testing.T, fn func(*Config)) (
 
 	clog, err := log.NewLog(dir, log.Config{})
 	require.NoError(t, err)
-	authorizer := auth.New(config.ACLModelFile, config.ACLPolicyFile)
+	authorizer, err := auth.New(config.ACLModelFile, config.ACLPolicyFile)
+	require.NoError(t, err)
 
 	var telemetryExporter *exporter.LogExporter
 
@@ -155,8 +205,10 @@ func setupTest(t *testing.T, fn func(*Config)) (
 	}
 
 	cfg = &Config{
-		CommitLog:  clog,
-		Authorizer: authorizer,
+		CommitLog:   clog,
+		Authorizer:  authorizer,
+		Snapshotter: clog,
+		Describer:   clog,
 	}
 
 	if fn != nil {
@@ -310,3 +362,391 @@ func testUnathorized(t *testing.T, _, client api.LogClient, config *Config) {
 		t.Fatalf("expected %v, got %v", wantCode, gotCode)
 	}
 }
+
+// testSnapshot tests that the Snapshot RPC method streams a snapshot of the
+// log that can be restored into a new log containing the produced record.
+func testSnapshot(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+	want := &api.Record{Value: []byte("hello world")}
+
+	produce, err := client.Produce(ctx, &api.ProduceRequest{Record: want})
+	require.NoError(t, err)
+
+	stream, err := client.Snapshot(ctx, &api.SnapshotRequest{})
+	require.NoError(t, err)
+
+	var (
+		buf    bytes.Buffer
+		offset uint64
+	)
+
+	for {
+		res, err := stream.Recv()
+
+		if err == io.EOF {
+			break
+		}
+
+		require.NoError(t, err)
+		buf.Write(res.Chunk)
+		offset = res.Offset
+	}
+
+	require.Equal(t, produce.Offset, offset)
+
+	dir, err := os.MkdirTemp("", "server_snapshot_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, log.Restore(dir, &buf))
+
+	restored, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	defer restored.Close()
+
+	read, err := restored.Read(produce.Offset)
+	require.NoError(t, err)
+	require.Equal(t, want.Value, read.Value)
+}
+
+// testUnauthorizedSnapshot tests that the Snapshot RPC method returns a
+// PermissionDenied error when the client is not allowed to perform admin
+// actions.
+func testUnauthorizedSnapshot(t *testing.T, _, client api.LogClient, config *Config) {
+	stream, err := client.Snapshot(context.Background(), &api.SnapshotRequest{})
+	require.NoError(t, err)
+
+	_, err = stream.Recv()
+
+	gotCode, wantCode := status.Code(err), codes.PermissionDenied
+
+	if gotCode != wantCode {
+		t.Fatalf("expected %v, got %v", wantCode, gotCode)
+	}
+}
+
+// testDescribe tests that the Describe RPC method reports the log's lowest
+// and highest offsets together with its segments, and that GetServers
+// returns Unimplemented when the server has no GetServerer.
+func testDescribe(t *testing.T, client, nobody api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err)
+	}
+
+	describe, err := client.Describe(ctx, &api.DescribeRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), describe.LowestOffset)
+	require.Equal(t, uint64(1), describe.HighestOffset)
+	require.Equal(t, 1, len(describe.Segments))
+	require.Equal(t, uint64(2), describe.Segments[0].NextOffset)
+
+	_, err = nobody.Describe(ctx, &api.DescribeRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = client.GetServers(ctx, &api.GetServersRequest{})
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+// TestPolicies tests that admins can list, add and remove policies and role
+// assignments through the admin RPCs, that the changes take effect immediately, and that other
+// subjects cannot manage policies. The policy file is copied so the changes
+// do not leak into other tests.
+func TestPolicies(t *testing.T) {
+	client, nobody, _, teardown := setupTest(t, func(c *Config) {
+		policy, err := os.ReadFile(config.ACLPolicyFile)
+		require.NoError(t, err)
+
+		policyFile := filepath.Join(t.TempDir(), "policy.csv")
+		require.NoError(t, os.WriteFile(policyFile, policy, 0644))
+
+		authorizer, err := auth.New(config.ACLModelFile, policyFile)
+		require.NoError(t, err)
+
+		c.Authorizer = authorizer
+		c.PolicyManager = authorizer
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	produce := &api.Policy{Subject: "nobody", Object: "*", Action: "produce"}
+	record := &api.ProduceRequest{Record: &api.Record{Value: []byte("hello world")}}
+
+	_, err := nobody.ListPolicies(ctx, &api.ListPoliciesRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = nobody.AddPolicy(ctx, &api.AddPolicyRequest{Policy: produce})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = client.AddPolicy(ctx, &api.AddPolicyRequest{
+		Policy: &api.Policy{Subject: "nobody"},
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	added, err := client.AddPolicy(ctx, &api.AddPolicyRequest{Policy: produce})
+	require.NoError(t, err)
+	require.True(t, added.Added)
+
+	list, err := client.ListPolicies(ctx, &api.ListPoliciesRequest{})
+	require.NoError(t, err)
+
+	found := false
+
+	for _, policy := range list.Policies {
+		found = found || proto.Equal(policy, produce)
+	}
+
+	require.True(t, found)
+
+	_, err = nobody.Produce(ctx, record)
+	require.NoError(t, err)
+
+	removed, err := client.RemovePolicy(ctx, &api.RemovePolicyRequest{Policy: produce})
+	require.NoError(t, err)
+	require.True(t, removed.Removed)
+
+	_, err = nobody.Produce(ctx, record)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Subjects are granted and lose roles through the same RPCs.
+	role := &api.Policy{Subject: "nobody", Role: "producer"}
+
+	_, err = client.AddPolicy(ctx, &api.AddPolicyRequest{
+		Policy: &api.Policy{Subject: "nobody", Object: "*", Role: "producer"},
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	added, err = client.AddPolicy(ctx, &api.AddPolicyRequest{Policy: role})
+	require.NoError(t, err)
+	require.True(t, added.Added)
+
+	list, err = client.ListPolicies(ctx, &api.ListPoliciesRequest{})
+	require.NoError(t, err)
+
+	found = false
+
+	for _, policy := range list.Policies {
+		found = found || proto.Equal(policy, role)
+	}
+
+	require.True(t, found)
+
+	_, err = nobody.Produce(ctx, record)
+	require.NoError(t, err)
+
+	removed, err = client.RemovePolicy(ctx, &api.RemovePolicyRequest{Policy: role})
+	require.NoError(t, err)
+	require.True(t, removed.Removed)
+
+	_, err = nobody.Produce(ctx, record)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+// TestGossipKeys tests that only administrators may rotate gossip keys, that
+// keys are validated, and that the RPCs are unimplemented without a
+// GossipKeyManager.
+func TestGossipKeys(t *testing.T) {
+	keys := &fakeKeyManager{}
+
+	client, nobody, cfg, teardown := setupTest(t, func(c *Config) {
+		c.GossipKeyManager = keys
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	key := &api.GossipKeyRequest{Key: "T9jncgl9mbLus+baTTa7q7nPSUrXwbDi2dhbtqir37s="}
+
+	_, err := nobody.InstallGossipKey(ctx, key)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = client.InstallGossipKey(ctx, &api.GossipKeyRequest{Key: "short"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	for _, rpc := range []func(context.Context, *api.GossipKeyRequest, ...grpc.CallOption) (*api.GossipKeysResponse, error){
+		client.InstallGossipKey,
+		client.UseGossipKey,
+		client.RemoveGossipKey,
+	} {
+		_, err = rpc(ctx, key)
+		require.NoError(t, err)
+	}
+
+	res, err := client.ListGossipKeys(ctx, &api.ListGossipKeysRequest{})
+	require.NoError(t, err)
+	require.Equal(t, map[string]int32{key.Key: 1}, res.Keys)
+
+	require.Equal(t, []string{
+		"install " + key.Key,
+		"use " + key.Key,
+		"remove " + key.Key,
+		"list",
+	}, keys.calls)
+
+	cfg.GossipKeyManager = nil
+
+	_, err = client.ListGossipKeys(ctx, &api.ListGossipKeysRequest{})
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+// fakeKeyManager records the gossip key operations it is asked to perform.
+type fakeKeyManager struct {
+	calls []string
+}
+
+func (m *fakeKeyManager) InstallKey(key string) (*api.GossipKeysResponse, error) {
+	m.calls = append(m.calls, "install "+key)
+	return &api.GossipKeysResponse{NumNodes: 1, NumResponses: 1}, nil
+}
+
+func (m *fakeKeyManager) UseKey(key string) (*api.GossipKeysResponse, error) {
+	m.calls = append(m.calls, "use "+key)
+	return &api.GossipKeysResponse{NumNodes: 1, NumResponses: 1}, nil
+}
+
+func (m *fakeKeyManager) RemoveKey(key string) (*api.GossipKeysResponse, error) {
+	m.calls = append(m.calls, "remove "+key)
+	return &api.GossipKeysResponse{NumNodes: 1, NumResponses: 1}, nil
+}
+
+func (m *fakeKeyManager) ListKeys() (*api.GossipKeysResponse, error) {
+	m.calls = append(m.calls, "list")
+
+	keys := make(map[string]int32)
+
+	for _, call := range m.calls {
+		if key, ok := strings.CutPrefix(call, "install "); ok {
+			keys[key] = 1
+		}
+	}
+
+	return &api.GossipKeysResponse{NumNodes: 1, NumResponses: 1, Keys: keys}, nil
+}
+
+// TestResourceNames tests that requests are authorized against the resource
+// they act on: the configured topic, the cluster, or the ACLs.
+func TestResourceNames(t *testing.T) {
+	authorizer := &recordingAuthorizer{}
+
+	client, _, _, teardown := setupTest(t, func(c *Config) {
+		c.Topic = "orders"
+		c.Authorizer = authorizer
+	})
+	defer teardown()
+
+	ctx := context.Background()
+
+	_, err := client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+
+	_, err = client.Describe(ctx, &api.DescribeRequest{})
+	require.NoError(t, err)
+
+	_, _ = client.GetServers(ctx, &api.GetServersRequest{})
+	_, _ = client.ListPolicies(ctx, &api.ListPoliciesRequest{})
+
+	require.Equal(t, [][2]string{
+		{"topics/orders", produceAction},
+		{"topics/orders", consumeAction},
+		{"topics/orders", describeAction},
+		{clusterObject, describeAction},
+		{aclObject, adminAction},
+	}, authorizer.requests())
+}
+
+// recordingAuthorizer allows every request and records the object and
+// action of each.
+type recordingAuthorizer struct {
+	mu        sync.Mutex
+	requested [][2]string
+}
+
+func (a *recordingAuthorizer) Authorize(subject, object, action string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.requested = append(a.requested, [2]string{object, action})
+
+	return nil
+}
+
+func (a *recordingAuthorizer) requests() [][2]string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.requested
+}
+
+// TestAuthenticators tests that authenticators are tried in order, that a
+// bearer token identifies a client in place of its certificate, and that
+// rejected tokens fail with Unauthenticated over both gRPC and the HTTP
+// gateway.
+func TestAuthenticators(t *testing.T) {
+	tokens := filepath.Join(t.TempDir(), "tokens.csv")
+	require.NoError(t, os.WriteFile(tokens, []byte("root-token, root"), 0600))
+
+	tokenAuthenticator, err := auth.NewTokenAuthenticator(tokens)
+	require.NoError(t, err)
+
+	withAuthenticators := func(c *Config) {
+		c.Authenticators = []Authenticator{
+			auth.TLSAuthenticator{},
+			tokenAuthenticator,
+		}
+	}
+
+	_, nobody, _, teardown := setupTest(t, withAuthenticators)
+	defer teardown()
+
+	record := &api.ProduceRequest{Record: &api.Record{Value: []byte("hello world")}}
+
+	_, err = nobody.Produce(context.Background(), record)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = nobody.Produce(
+		metadata.AppendToOutgoingContext(
+			context.Background(),
+			"authorization", "Bearer root-token",
+		),
+		record,
+	)
+	require.NoError(t, err)
+
+	_, err = nobody.Produce(
+		metadata.AppendToOutgoingContext(
+			context.Background(),
+			"authorization", "Bearer wrong-token",
+		),
+		record,
+	)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	url, _, nobodyClient, httpTeardown := setupHTTPTest(t, withAuthenticators)
+	defer httpTeardown()
+
+	for token, want := range map[string]int{
+		"root-token":  http.StatusOK,
+		"wrong-token": http.StatusUnauthorized,
+	} {
+		req, err := http.NewRequest(
+			http.MethodPost,
+			url+"/v1/records",
+			strings.NewReader(`{"record":{"value":"aGVsbG8gd29ybGQ="}}`),
+		)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		res, err := nobodyClient.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, want, res.StatusCode, token)
+	}
+}
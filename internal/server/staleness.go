@@ -0,0 +1,78 @@
+package server
+
+import (
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ReplicationState reports how up to date a server's log is.
+type ReplicationState interface {
+	// Staleness returns how long ago the server's log last held every
+	// record of the leader's, which is zero on the leader, and the leader's
+	// RPC address if the server is not the leader and it is known.
+	Staleness() (staleness time.Duration, leader string)
+	// Replicated returns, for every peer the server replicates from, the
+	// offset after the last record of the peer's log it has replicated.
+	Replicated() map[string]uint64
+	// LeaderReplicated returns the offset after the last record of the
+	// leader's log the server has replicated.
+	LeaderReplicated() uint64
+}
+
+// checkStaleness returns an ErrorStaleRead with the leader's address if the
+// server cannot serve the request within its bounds: when its log is more
+// than the request's MaxStalenessMs behind the leader's, or it has not yet
+// replicated the leader's record at the request's MinOffset. Followers
+// append replicated records at offsets of their own, so the MinOffset is
+// compared with how far they replicated the leader's log rather than with
+// their log's highest offset. The leader itself returns an
+// ErrorOffsetOutOfRange for a MinOffset it does not hold yet. It returns
+// Unimplemented for bounded requests if the server was not configured with
+// a ReplicationState and a Describer.
+func (s *grpcServer) checkStaleness(req *api.ConsumeRequest) error {
+	if req.MinOffset == 0 && req.MaxStalenessMs == 0 {
+		return nil
+	}
+
+	if s.ReplicationState == nil || s.Describer == nil {
+		return status.Error(
+			codes.Unimplemented,
+			"bounded staleness reads are not supported",
+		)
+	}
+
+	staleness, leader := s.ReplicationState.Staleness()
+
+	maxStaleness := time.Duration(req.MaxStalenessMs) * time.Millisecond
+
+	if req.MaxStalenessMs > 0 && staleness > maxStaleness {
+		return api.ErrorStaleRead{Leader: leader}
+	}
+
+	if req.MinOffset == 0 {
+		return nil
+	}
+
+	if leader != "" || staleness > 0 {
+		if s.ReplicationState.LeaderReplicated() > req.MinOffset {
+			return nil
+		}
+
+		return api.ErrorStaleRead{Leader: leader}
+	}
+
+	highest, err := s.Describer.HighestOffset()
+
+	if err != nil {
+		return err
+	}
+
+	if highest < req.MinOffset {
+		return api.ErrorOffsetOutOfRange{Offset: req.MinOffset}
+	}
+
+	return nil
+}
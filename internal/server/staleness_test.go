@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestStaleness tests that a follower refuses consumes bounding their
+// staleness when its log is too far behind the leader's, redirecting them
+// to the leader, and that the leader serves them.
+func TestStaleness(t *testing.T) {
+	replication := &fakeReplication{replicated: 2}
+
+	client, _, cfg, teardown := setupTest(t, func(c *Config) {
+		c.ReplicationState = replication
+	})
+	defer teardown()
+
+	ctx := context.Background()
+
+	for range 2 {
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err)
+	}
+
+	// A follower replicating within the bound serves the read.
+	replication.set(50*time.Millisecond, "127.0.0.1:8400")
+
+	_, err := client.Consume(ctx, &api.ConsumeRequest{
+		Offset:         0,
+		MinOffset:      1,
+		MaxStalenessMs: 100,
+	})
+	require.NoError(t, err)
+
+	// Too stale a follower redirects to the leader.
+	_, err = client.Consume(ctx, &api.ConsumeRequest{MaxStalenessMs: 10})
+	requireStaleRead(t, err, "127.0.0.1:8400")
+
+	// So does a follower that has not replicated the minimum offset yet.
+	_, err = client.Consume(ctx, &api.ConsumeRequest{MinOffset: 2})
+	requireStaleRead(t, err, "127.0.0.1:8400")
+
+	// Even if its own log holds that offset, since it holds the records of
+	// other peers too.
+	replication.setReplicated(1)
+
+	_, err = client.Consume(ctx, &api.ConsumeRequest{MinOffset: 1})
+	requireStaleRead(t, err, "127.0.0.1:8400")
+
+	// The leader is never stale, and has not appended the offset yet.
+	replication.set(0, "")
+
+	_, err = client.Consume(ctx, &api.ConsumeRequest{MaxStalenessMs: 10})
+	require.NoError(t, err)
+
+	_, err = client.Consume(ctx, &api.ConsumeRequest{MinOffset: 2})
+	require.Equal(
+		t,
+		status.Code(api.ErrorOffsetOutOfRange{}.GRPCStatus().Err()),
+		status.Code(err),
+	)
+
+	// Unbounded reads do not need to know the replication state.
+	cfg.ReplicationState = nil
+
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 1})
+	require.NoError(t, err)
+
+	_, err = client.Consume(ctx, &api.ConsumeRequest{MinOffset: 1})
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+// requireStaleRead checks that err is an Unavailable error redirecting to
+// leader.
+func requireStaleRead(t *testing.T, err error, leader string) {
+	t.Helper()
+
+	st := status.Convert(err)
+	require.Equal(t, codes.Unavailable, st.Code())
+
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			require.Equal(t, "STALE_READ", info.Reason)
+			require.Equal(t, leader, info.Metadata["leader_addr"])
+			return
+		}
+	}
+
+	t.Fatal("error has no ErrorInfo detail")
+}
+
+// fakeReplication reports the staleness, leader and replicated offset it is
+// set to.
+type fakeReplication struct {
+	mu         sync.Mutex
+	staleness  time.Duration
+	leader     string
+	replicated uint64
+}
+
+func (r *fakeReplication) set(staleness time.Duration, leader string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.staleness = staleness
+	r.leader = leader
+}
+
+func (r *fakeReplication) setReplicated(off uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.replicated = off
+}
+
+func (r *fakeReplication) Staleness() (time.Duration, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.staleness, r.leader
+}
+
+func (r *fakeReplication) Replicated() map[string]uint64 {
+	return map[string]uint64{"leader": r.LeaderReplicated()}
+}
+
+func (r *fakeReplication) LeaderReplicated() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.replicated
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Gibson-Gichuru/prolog/internal/tracing"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traceContextsSize is the number of recently produced records whose trace
+// context the server remembers for replicas streaming them.
+const traceContextsSize = 1024
+
+// traceContexts remembers the trace contexts sampled produces arrived with in
+// their gRPC metadata, keyed by the offset of the record they appended, so
+// that the records are streamed to replicas with the context that produced
+// them without it being stored in the log. Only the most recent
+// traceContextsSize are kept; older records are streamed without one.
+type traceContexts struct {
+	mu       sync.Mutex
+	contexts map[uint64]map[string]string
+	// offsets holds the offsets in contexts in the order they were added,
+	// as a ring buffer starting at next.
+	offsets []uint64
+	next    int
+}
+
+func newTraceContexts() *traceContexts {
+	return &traceContexts{
+		contexts: make(map[uint64]map[string]string),
+	}
+}
+
+// add remembers the trace context of ctx for the record at offset, if ctx is
+// being sampled.
+func (t *traceContexts) add(ctx context.Context, offset uint64) {
+	if !trace.SpanContextFromContext(ctx).IsSampled() {
+		return
+	}
+
+	carrier := make(map[string]string)
+	tracing.Propagator.Inject(ctx, propagation.MapCarrier(carrier))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.offsets) < traceContextsSize {
+		t.offsets = append(t.offsets, offset)
+	} else {
+		delete(t.contexts, t.offsets[t.next])
+		t.offsets[t.next] = offset
+		t.next = (t.next + 1) % traceContextsSize
+	}
+
+	t.contexts[offset] = carrier
+}
+
+// get returns the trace context remembered for the record at offset, or nil
+// if there is none.
+func (t *traceContexts) get(offset uint64) map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.contexts[offset]
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TestTraceContexts tests that only sampled trace contexts are remembered,
+// and that the oldest are forgotten once traceContextsSize are kept.
+func TestTraceContexts(t *testing.T) {
+	traces := newTraceContexts()
+
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{1},
+		SpanID:  trace.SpanID{1},
+	})
+
+	traces.add(trace.ContextWithSpanContext(context.Background(), spanContext), 0)
+	require.Nil(t, traces.get(0))
+
+	sampled := trace.ContextWithSpanContext(
+		context.Background(),
+		spanContext.WithTraceFlags(trace.FlagsSampled),
+	)
+
+	for offset := uint64(0); offset <= traceContextsSize; offset++ {
+		traces.add(sampled, offset)
+	}
+
+	require.Nil(t, traces.get(0))
+	require.Contains(t, traces.get(1), "traceparent")
+	require.Contains(t, traces.get(traceContextsSize), "traceparent")
+}
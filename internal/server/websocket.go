@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// WebSocketSendBuffer is the number of records buffered for each
+	// WebSocket client. A client that falls this far behind is disconnected.
+	WebSocketSendBuffer = 256
+	// WebSocketWriteTimeout is how long writing a single frame to a
+	// WebSocket client may take before the client is disconnected.
+	WebSocketWriteTimeout = 10 * time.Second
+)
+
+var errSlowConsumer = errors.New("slow consumer")
+
+// webSocketRecord is the JSON frame sent to WebSocket clients for each
+// record.
+type webSocketRecord struct {
+	Offset uint64 `json:"offset"`
+	Value  string `json:"value"`
+	// Encoding is base64 if the value is base64 encoded.
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// handleWebSocketTail upgrades the request to a WebSocket and streams records
+// to the client from the offset query parameter, or 0 if it is absent, with
+// the same semantics as ConsumeStream: it waits for records that have not
+// been appended yet and streams until the client disconnects. Each record is
+// sent as a JSON text frame holding its offset and value. Values are sent as
+// strings unless the base64 query parameter is true, in which case they are
+// base64 encoded. Values that are not valid UTF-8, which JSON strings cannot
+// hold, are base64 encoded regardless. Frames with base64 encoded values
+// carry the base64 encoding.
+//
+// Records are queued for the client in a buffer of WebSocketSendBuffer
+// records. A client that lets the buffer fill up, or takes longer than
+// WebSocketWriteTimeout to accept a frame, is disconnected with a policy
+// violation close frame rather than buffering without bound.
+func (h *httpServer) handleWebSocketTail(w http.ResponseWriter, r *http.Request) {
+
+	offset, err := offsetQuery(r)
+
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	encode := false
+
+	if v := r.URL.Query().Get("base64"); v != "" {
+		if encode, err = strconv.ParseBool(v); err != nil {
+			h.writeError(w, status.Error(codes.InvalidArgument, "invalid base64"))
+			return
+		}
+	}
+
+	ctx, err := h.httpContext(r)
+
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	// Each record read is authorized again, but only changed decisions are
+	// audited.
+	ctx, done := h.Streams.track(withAuditStream(ctx))
+	defer done()
+
+	if err = h.authorize(
+		ctx,
+		h.topicObject(),
+		consumeAction,
+	); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+
+	if err != nil {
+		// The upgrader has already responded to the client.
+		return
+	}
+
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Clients are not expected to send anything, but reading processes
+	// control frames and notices when the client goes away.
+	go func() {
+		defer cancel()
+
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	records := make(chan *api.Record, WebSocketSendBuffer)
+	written := make(chan struct{})
+
+	go func() {
+		defer close(written)
+		defer cancel()
+
+		for record := range records {
+			frame := webSocketRecord{
+				Offset: record.Offset,
+				Value:  string(record.Value),
+			}
+
+			if encode || !utf8.Valid(record.Value) {
+				frame.Value = base64.StdEncoding.EncodeToString(record.Value)
+				frame.Encoding = "base64"
+			}
+
+			_ = conn.SetWriteDeadline(time.Now().Add(WebSocketWriteTimeout))
+
+			if err := conn.WriteJSON(frame); err != nil {
+				return
+			}
+		}
+	}()
+
+	err = h.tail(ctx, offset, func(res *api.ConsumeResponse) error {
+		select {
+		case records <- res.Record:
+			return nil
+		default:
+			return errSlowConsumer
+		}
+	})
+
+	close(records)
+
+	code, reason := websocket.CloseNormalClosure, ""
+
+	switch {
+	case errors.Is(err, errSlowConsumer):
+		code, reason = websocket.ClosePolicyViolation, err.Error()
+	case err != nil:
+		code, reason = websocket.CloseInternalServerErr, "internal error"
+		h.logger.Error("websocket tail failed", zap.Error(err))
+	}
+
+	_ = conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(time.Second),
+	)
+
+	// Closing the connection unblocks a writer stuck on a slow client.
+	conn.Close()
+	<-written
+}
+
+// checkOrigin allows WebSocket connections from the gateway's own origin and
+// from the configured AllowedOrigins.
+func (h *httpServer) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+
+	if origin == "" || slices.Contains(h.AllowedOrigins, origin) {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+
+	return err == nil && u.Host == r.Host
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	api "github.com/Gibson-Gichuru/prolog/api/v1"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// TestWebSocketTail tests that the WebSocket tail endpoint streams existing
+// and newly appended records as JSON frames, with values sent as strings or
+// base64 encoded, and that values that are not valid UTF-8 are always base64
+// encoded.
+func TestWebSocketTail(t *testing.T) {
+	url, rootClient, _, teardown := setupHTTPTest(t, nil)
+	defer teardown()
+
+	values := []string{"first message", "second message", "\xff\xfe binary"}
+
+	res := produce(t, rootClient, url, []byte(values[0]))
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	plain := dialWebSocket(t, url, rootClient, "/v1/ws/tail?offset=0")
+	defer plain.Close()
+
+	encoded := dialWebSocket(t, url, rootClient, "/v1/ws/tail?offset=0&base64=true")
+	defer encoded.Close()
+
+	for _, value := range values[1:] {
+		res := produce(t, rootClient, url, []byte(value))
+		res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	for i, value := range values {
+		frame := webSocketRecord{}
+
+		encodedValue := base64.StdEncoding.EncodeToString([]byte(value))
+
+		require.NoError(t, plain.ReadJSON(&frame))
+		require.Equal(t, uint64(i), frame.Offset)
+
+		if i < 2 {
+			require.Equal(t, value, frame.Value)
+			require.Empty(t, frame.Encoding)
+		} else {
+			require.Equal(t, encodedValue, frame.Value)
+			require.Equal(t, "base64", frame.Encoding)
+		}
+
+		frame = webSocketRecord{}
+
+		require.NoError(t, encoded.ReadJSON(&frame))
+		require.Equal(t, uint64(i), frame.Offset)
+		require.Equal(t, encodedValue, frame.Value)
+		require.Equal(t, "base64", frame.Encoding)
+	}
+}
+
+// TestWebSocketTailSlowConsumer tests that a client that stops reading is
+// disconnected once its send buffer fills up, rather than the server
+// buffering records for it without bound.
+func TestWebSocketTailSlowConsumer(t *testing.T) {
+	sendBuffer := WebSocketSendBuffer
+	WebSocketSendBuffer = 4
+	defer func() { WebSocketSendBuffer = sendBuffer }()
+
+	url, rootClient, _, teardown := setupHTTPTest(t, func(c *Config) {
+		c.CommitLog = endlessLog{value: bytes.Repeat([]byte("a"), 64<<10)}
+	})
+	defer teardown()
+
+	conn := dialWebSocket(t, url, rootClient, "/v1/ws/tail")
+	defer conn.Close()
+
+	// Stop reading until the server has given up on the client.
+	time.Sleep(500 * time.Millisecond)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(10*time.Second)))
+
+	var err error
+
+	for err == nil {
+		_, _, err = conn.ReadMessage()
+	}
+
+	require.True(
+		t,
+		websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure),
+		"unexpected error: %v", err,
+	)
+}
+
+// dialWebSocket opens a WebSocket to path on the gateway at url using the TLS
+// config of client.
+func dialWebSocket(
+	t *testing.T,
+	url string,
+	client *http.Client,
+	path string,
+) *websocket.Conn {
+	t.Helper()
+
+	dialer := websocket.Dialer{
+		TLSClientConfig: client.Transport.(*http.Transport).TLSClientConfig,
+	}
+
+	conn, res, err := dialer.Dial(strings.Replace(url, "https", "wss", 1)+path, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, res.StatusCode)
+
+	return conn
+}
+
+// endlessLog is a CommitLog holding a record with the same value at every
+// offset.
+type endlessLog struct {
+	value []byte
+}
+
+func (l endlessLog) Append(*api.Record) (uint64, error) {
+	return 0, nil
+}
+
+func (l endlessLog) Read(off uint64) (*api.Record, error) {
+	return &api.Record{Value: l.value, Offset: off}, nil
+}
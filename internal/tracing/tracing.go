@@ -0,0 +1,205 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	ExporterNone   = ""
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+// Propagator is the propagator used to carry trace context across gRPC calls
+// and inside replicated records.
+var Propagator = propagation.TraceContext{}
+
+type Config struct {
+	// Exporter selects where spans are sent: ExporterOTLP, ExporterStdout,
+	// ExporterFile, or ExporterNone to disable tracing.
+	Exporter string
+	// Endpoint is the OTLP gRPC collector address used by ExporterOTLP.
+	Endpoint string
+	// Insecure disables TLS when connecting to the OTLP collector.
+	Insecure bool
+	// File is the path spans are appended to by ExporterFile.
+	File string
+	// SpanExporter, if set, is used instead of the exporter selected by
+	// Exporter.
+	SpanExporter sdktrace.SpanExporter
+	// ServiceName identifies the agent in exported spans.
+	ServiceName string
+	// SampleRatio is the fraction of root traces that are sampled.
+	SampleRatio float64
+	// SampleProduces samples every Produce call regardless of SampleRatio.
+	SampleProduces bool
+}
+
+type Tracer struct {
+	trace.TracerProvider
+	shutdown func(context.Context) error
+}
+
+// New returns a Tracer configured from config. Spans are batched and sent to
+// the configured exporter. Child spans follow their parent's sampling
+// decision; root spans are sampled at SampleRatio, and always when their name
+// contains Produce if SampleProduces is set. When no exporter is configured
+// the returned Tracer records nothing.
+func New(config Config) (*Tracer, error) {
+
+	exporter, err := newExporter(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if exporter == nil {
+		return &Tracer{
+			TracerProvider: noop.NewTracerProvider(),
+			shutdown:       func(context.Context) error { return nil },
+		}, nil
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewSchemaless(
+			attribute.String("service.name", config.ServiceName),
+		),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(newSampler(config))),
+	)
+
+	return &Tracer{
+		TracerProvider: tp,
+		shutdown:       tp.Shutdown,
+	}, nil
+}
+
+// Shutdown flushes any spans that have not been exported yet and stops the
+// exporter.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	return t.shutdown(ctx)
+}
+
+// newExporter returns the span exporter selected by config, or nil when
+// tracing is disabled.
+func newExporter(config Config) (sdktrace.SpanExporter, error) {
+
+	if config.SpanExporter != nil {
+		return config.SpanExporter, nil
+	}
+
+	switch config.Exporter {
+	case ExporterNone:
+		return nil, nil
+
+	case ExporterOTLP:
+		opts := []otlptracegrpc.Option{
+			otlptracegrpc.WithEndpoint(config.Endpoint),
+		}
+
+		if config.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+
+		return otlptracegrpc.New(context.Background(), opts...)
+
+	case ExporterStdout:
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+
+	case ExporterFile:
+		f, err := os.OpenFile(
+			config.File,
+			os.O_RDWR|os.O_CREATE|os.O_APPEND,
+			0644,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		return &fileExporter{SpanExporter: exporter, file: f}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown trace exporter: %q", config.Exporter)
+	}
+}
+
+type fileExporter struct {
+	sdktrace.SpanExporter
+	file *os.File
+}
+
+// Shutdown stops the exporter and closes the file it writes to.
+func (e *fileExporter) Shutdown(ctx context.Context) error {
+	if err := e.SpanExporter.Shutdown(ctx); err != nil {
+		return err
+	}
+
+	return e.file.Close()
+}
+
+type produceSampler struct {
+	sdktrace.Sampler
+}
+
+// newSampler returns a sampler that samples SampleRatio of traces, wrapped
+// so that Produce spans are always sampled when SampleProduces is set.
+func newSampler(config Config) sdktrace.Sampler {
+	sampler := sdktrace.TraceIDRatioBased(config.SampleRatio)
+
+	if !config.SampleProduces {
+		return sampler
+	}
+
+	return &produceSampler{Sampler: sampler}
+}
+
+// ShouldSample samples spans whose name contains Produce and defers to the
+// wrapped sampler for all others.
+func (s *produceSampler) ShouldSample(
+	p sdktrace.SamplingParameters,
+) sdktrace.SamplingResult {
+
+	if strings.Contains(p.Name, "Produce") {
+		return sdktrace.SamplingResult{
+			Decision:   sdktrace.RecordAndSample,
+			Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+		}
+	}
+
+	return s.Sampler.ShouldSample(p)
+}
+
+// Description describes the sampler.
+func (s *produceSampler) Description() string {
+	return fmt.Sprintf("ProduceSampler{%s}", s.Sampler.Description())
+}
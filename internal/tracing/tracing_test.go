@@ -0,0 +1,104 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TestTracer exercises the Tracer type, checking the exporters it can be
+// configured with and how spans are sampled.
+func TestTracer(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T){
+		"no exporter records nothing":      testNoExporter,
+		"file exporter writes spans":       testFileExporter,
+		"produce spans are always sampled": testSampleProduces,
+		"unknown exporter fails":           testUnknownExporter,
+	} {
+		t.Run(scenario, fn)
+	}
+}
+
+// testNoExporter tests that a Tracer without an exporter does not record
+// spans.
+func testNoExporter(t *testing.T) {
+	tracer, err := New(Config{SampleRatio: 1})
+	require.NoError(t, err)
+
+	_, span := tracer.Tracer("test").Start(context.Background(), "span")
+	require.False(t, span.IsRecording())
+	span.End()
+
+	require.NoError(t, tracer.Shutdown(context.Background()))
+}
+
+// testFileExporter tests that the file exporter appends finished spans to
+// the configured file once the Tracer is shut down.
+func testFileExporter(t *testing.T) {
+	f, err := os.CreateTemp("", "traces-*.log")
+	require.NoError(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	tracer, err := New(Config{
+		Exporter:    ExporterFile,
+		File:        f.Name(),
+		ServiceName: "test",
+		SampleRatio: 1,
+	})
+	require.NoError(t, err)
+
+	_, span := tracer.Tracer("test").Start(context.Background(), "file-span")
+	span.End()
+
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	b, err := os.ReadFile(f.Name())
+	require.NoError(t, err)
+	require.Contains(t, string(b), "file-span")
+}
+
+// testSampleProduces tests that Produce spans are sampled even when the
+// sample ratio excludes every other root span, and that children follow
+// their parent's decision.
+func testSampleProduces(t *testing.T) {
+	spans := tracetest.NewInMemoryExporter()
+
+	tracer, err := New(Config{
+		SpanExporter:   spans,
+		SampleRatio:    0,
+		SampleProduces: true,
+	})
+	require.NoError(t, err)
+
+	ctx, produce := tracer.Tracer("test").Start(
+		context.Background(),
+		"log.v1.Log/Produce",
+	)
+	require.True(t, produce.SpanContext().IsSampled())
+
+	_, child := tracer.Tracer("test").Start(ctx, "child")
+	require.True(t, child.SpanContext().IsSampled())
+
+	_, consume := tracer.Tracer("test").Start(
+		context.Background(),
+		"log.v1.Log/Consume",
+	)
+	require.False(t, consume.SpanContext().IsSampled())
+
+	require.Equal(
+		t,
+		produce.SpanContext().TraceID(),
+		trace.SpanContextFromContext(ctx).TraceID(),
+	)
+}
+
+// testUnknownExporter tests that configuring an unknown exporter fails.
+func testUnknownExporter(t *testing.T) {
+	_, err := New(Config{Exporter: "carrier-pigeon"})
+	require.Error(t, err)
+}
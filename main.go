@@ -1,5 +1,55 @@
 package main
 
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+)
+
+const usage = `Usage: prolog <command> [arguments]
+
+Commands:
+  certs    create a CA and issue TLS certificates
+  keygen   print a new gossip encryption key
+`
+
 func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+
+	switch os.Args[1] {
+	case "certs":
+		err = runCerts(os.Args[2:])
+	case "keygen":
+		err = keygen()
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "prolog: unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "prolog: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// keygen prints a new base64 encoded 32 byte key for encrypting gossip.
+func keygen() error {
+	key := make([]byte, 32)
+
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+
+	fmt.Println(base64.StdEncoding.EncodeToString(key))
 
+	return nil
 }